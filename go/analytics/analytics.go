@@ -0,0 +1,101 @@
+// Package analytics counts page views and visitors by day without cookies or
+// storing anything that identifies a visitor.
+//
+// Visitors are told apart by a hash of their IP address and User-Agent with
+// a salt that is kept only in memory and replaced every day, see Hasher, so
+// the same visitor can't be recognized from one day to the next, and only the
+// daily counts are ever stored.
+package analytics
+
+import (
+	"context"
+	"time"
+)
+
+// Dimensions that views are counted by.
+const (
+	// TOTAL_DIMENSION counts every view, with an empty Value.
+	TOTAL_DIMENSION   = "total"
+	PATH_DIMENSION    = "path"
+	COUNTRY_DIMENSION = "country"
+	DEVICE_DIMENSION  = "device"
+)
+
+// Dimensions are all the dimensions, in the order they are shown.
+var Dimensions = []string{TOTAL_DIMENSION, PATH_DIMENSION, COUNTRY_DIMENSION, DEVICE_DIMENSION}
+
+// Count is the number of Views, and of distinct Visitors, on Day where the
+// Dimension had the given Value.
+type Count struct {
+	Day       time.Time
+	Dimension string `datastore:",noindex"`
+	Value     string `datastore:",noindex"`
+	Views     int64  `datastore:",noindex"`
+	Visitors  int64  `datastore:",noindex"`
+}
+
+// key identifies what a Count counts.
+func (c *Count) key() countKey {
+	return countKey{
+		day:       c.Day.Unix(),
+		dimension: c.Dimension,
+		value:     c.Value,
+	}
+}
+
+// countKey is a Count without the Views and Visitors, usable as a map key.
+type countKey struct {
+	day       int64
+	dimension string
+	value     string
+}
+
+// Store is the storage backend for Counts.
+type Store interface {
+	// Add adds the Views and Visitors of each Count to those already stored
+	// for the same Day, Dimension, and Value.
+	//
+	// If Add fails after some of the counts were written, the error is an
+	// *UnwrittenError, so that only the rest are added again.
+	Add(ctx context.Context, counts []*Count) error
+
+	// Get returns the stored Counts whose Day is in [begin, end).
+	Get(ctx context.Context, begin, end time.Time) ([]*Count, error)
+}
+
+// UnwrittenError is the error from Store.Add when only some of the counts
+// were written. Unwritten are the counts that weren't.
+type UnwrittenError struct {
+	Unwritten []*Count
+	Err       error
+}
+
+func (e *UnwrittenError) Error() string {
+	return e.Err.Error()
+}
+
+// Day returns the start of the day, in UTC, that t falls in.
+func Day(t time.Time) time.Time {
+	t = t.UTC()
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+// merge returns counts with the Views and Visitors of those for the same
+// Day, Dimension, and Value added together, in the order each first appears.
+// The returned Counts are copies.
+func merge(counts []*Count) []*Count {
+	ret := []*Count{}
+	merged := map[countKey]*Count{}
+	for _, c := range counts {
+		key := c.key()
+		if m, ok := merged[key]; ok {
+			m.Views += c.Views
+			m.Visitors += c.Visitors
+			continue
+		}
+		copied := *c
+		merged[key] = &copied
+		ret = append(ret, &copied)
+	}
+	return ret
+}
@@ -0,0 +1,80 @@
+package analytics
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDevice(t *testing.T) {
+	for ua, device := range map[string]string{
+		"Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/66.0.3359.139 Safari/537.36":                         DESKTOP_DEVICE,
+		"Mozilla/5.0 (Macintosh; Intel Mac OS X 10.13; rv:59.0) Gecko/20100101 Firefox/59.0":                                                DESKTOP_DEVICE,
+		"Mozilla/5.0 (iPhone; CPU iPhone OS 11_3 like Mac OS X) AppleWebKit/604.1.34 (KHTML, like Gecko) Version/11.0 Mobile/15E148 Safari": MOBILE_DEVICE,
+		"Mozilla/5.0 (Linux; Android 8.1.0; Pixel 2) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/66.0.3359.126 Mobile Safari/537.36":      MOBILE_DEVICE,
+		"Mozilla/5.0 (iPad; CPU OS 11_3 like Mac OS X) AppleWebKit/605.1.15 (KHTML, like Gecko) Version/11.0 Mobile/15E148 Safari/604.1":    TABLET_DEVICE,
+		"Mozilla/5.0 (Linux; Android 7.0; SM-T820) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/66.0.3359.126 Safari/537.36":               TABLET_DEVICE,
+		"Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)":                                                          BOT_DEVICE,
+		"Mozilla/5.0 (compatible; bingbot/2.0; +http://www.bing.com/bingbot.htm)":                                                           BOT_DEVICE,
+		"Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) HeadlessChrome/66.0.3359.139 Safari/537.36":                 BOT_DEVICE,
+		"facebookexternalhit/1.1 (+http://www.facebook.com/externalhit_uatext.php)":                                                         BOT_DEVICE,
+		"curl/7.58.0":            BOT_DEVICE,
+		"python-requests/2.18.4": BOT_DEVICE,
+		"Feedly/1.0":             BOT_DEVICE,
+		"":                       BOT_DEVICE,
+	} {
+		assert.Equal(t, device, Device(ua), ua)
+	}
+	assert.True(t, IsBot("Twitterbot/1.0"))
+	assert.False(t, IsBot("Mozilla/5.0 (Windows NT 10.0; Win64; x64; rv:59.0) Gecko/20100101 Firefox/59.0"))
+}
+
+func TestHasher(t *testing.T) {
+	h := NewHasher()
+	now := time.Date(2018, 3, 4, 15, 16, 0, 0, time.UTC)
+	ua := "Mozilla/5.0"
+	id := h.Visitor("192.0.2.1", ua, now)
+	assert.Len(t, id, 32)
+	assert.Equal(t, id, h.Visitor("192.0.2.1", ua, now.Add(time.Hour)))
+	assert.NotEqual(t, id, h.Visitor("192.0.2.2", ua, now))
+	assert.NotEqual(t, id, h.Visitor("192.0.2.1", "curl/7.58.0", now))
+	// The salt rotates every day.
+	assert.NotEqual(t, id, h.Visitor("192.0.2.1", ua, now.Add(24*time.Hour)))
+	// Another Hasher has another salt.
+	assert.NotEqual(t, id, NewHasher().Visitor("192.0.2.1", ua, now))
+}
+
+func TestGeoIP(t *testing.T) {
+	// Without a database everywhere is unknown.
+	g, err := LoadGeoIP("")
+	assert.NoError(t, err)
+	assert.Equal(t, UNKNOWN_COUNTRY, g.Country("192.0.2.1"))
+
+	_, err = LoadGeoIP("/nonexistent/GeoLite2-Country.mmdb")
+	assert.Error(t, err)
+}
+
+func TestReport(t *testing.T) {
+	day := time.Date(2018, 3, 4, 0, 0, 0, 0, time.UTC)
+	r := NewReport([]*Count{
+		{Day: day, Dimension: TOTAL_DIMENSION, Views: 3, Visitors: 2},
+		{Day: day.AddDate(0, 0, 1), Dimension: TOTAL_DIMENSION, Views: 4, Visitors: 2},
+		{Day: day, Dimension: PATH_DIMENSION, Value: "/a", Views: 1, Visitors: 1},
+		{Day: day, Dimension: PATH_DIMENSION, Value: "/b", Views: 2, Visitors: 1},
+		{Day: day.AddDate(0, 0, 1), Dimension: PATH_DIMENSION, Value: "/a", Views: 4, Visitors: 2},
+		{Day: day, Dimension: DEVICE_DIMENSION, Value: BOT_DEVICE, Views: 9, Visitors: 1},
+		// Outside the range.
+		{Day: day.AddDate(0, 0, 3), Dimension: TOTAL_DIMENSION, Views: 100, Visitors: 100},
+	}, day, day.AddDate(0, 0, 3))
+	assert.Len(t, r.Days, 3)
+	assert.Equal(t, []int64{3, 4, 0}, r.All.Views)
+	assert.Equal(t, int64(7), r.All.TotalViews)
+	assert.Equal(t, int64(4), r.All.TotalVisitors)
+	assert.Len(t, r.Paths, 2)
+	assert.Equal(t, "/a", r.Paths[0].Name)
+	assert.Equal(t, []int64{1, 2, 0}, r.Paths[0].Visitors)
+	assert.Len(t, r.Countries, 0)
+	assert.Len(t, r.Devices, 1)
+	assert.Equal(t, int64(9), r.Devices[0].TotalViews)
+}
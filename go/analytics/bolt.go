@@ -0,0 +1,108 @@
+package analytics
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// DAY_FORMAT is the sortable form of Count.Day at the start of BoltDB keys.
+const DAY_FORMAT = "2006-01-02"
+
+// BOLT_BUCKET is the name of the BoltDB bucket counts are kept in.
+var BOLT_BUCKET = []byte("Views")
+
+// boltStore implements Store using an embedded BoltDB file, shared with the
+// mentions and referrers. Counts are keyed by the day, dimension, and value,
+// separated by NULs, so a time range is a range of keys. The values are the
+// views and then the visitors as big endian uint64s.
+type boltStore struct {
+	db *bolt.DB
+}
+
+// NewBoltStore returns a Store backed by the BoltDB database db.
+func NewBoltStore(db *bolt.DB) (Store, error) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(BOLT_BUCKET)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create bucket: %s", err)
+	}
+	return &boltStore{db: db}, nil
+}
+
+// dayPrefix returns the start of the keys for the day that begins at t.
+func dayPrefix(t time.Time) []byte {
+	return []byte(t.UTC().Format(DAY_FORMAT) + "\x00")
+}
+
+func boltKey(c *Count) []byte {
+	return append(dayPrefix(c.Day), []byte(c.Dimension+"\x00"+c.Value)...)
+}
+
+func (b *boltStore) Add(ctx context.Context, counts []*Count) error {
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(BOLT_BUCKET)
+		for _, c := range counts {
+			key := boltKey(c)
+			var views, visitors uint64
+			if v := bucket.Get(key); len(v) == 16 {
+				views = binary.BigEndian.Uint64(v[:8])
+				visitors = binary.BigEndian.Uint64(v[8:])
+			}
+			buf := make([]byte, 16)
+			binary.BigEndian.PutUint64(buf[:8], views+uint64(c.Views))
+			binary.BigEndian.PutUint64(buf[8:], visitors+uint64(c.Visitors))
+			if err := bucket.Put(key, buf); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("Failed writing view counts: %s", err)
+	}
+	return nil
+}
+
+func (b *boltStore) Get(ctx context.Context, begin, end time.Time) ([]*Count, error) {
+	ret := []*Count{}
+	err := b.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(BOLT_BUCKET).Cursor()
+		// Keys only have the date, so the exact range is checked against the
+		// parsed Day.
+		for k, v := c.Seek(dayPrefix(begin)); k != nil; k, v = c.Next() {
+			parts := strings.SplitN(string(k), "\x00", 3)
+			if len(parts) != 3 || len(v) != 16 {
+				return fmt.Errorf("Invalid key %q", string(k))
+			}
+			day, err := time.Parse(DAY_FORMAT, parts[0])
+			if err != nil {
+				return fmt.Errorf("Invalid key %q: %s", string(k), err)
+			}
+			if !day.Before(end) {
+				break
+			}
+			if day.Before(begin) {
+				continue
+			}
+			ret = append(ret, &Count{
+				Day:       day,
+				Dimension: parts[1],
+				Value:     parts[2],
+				Views:     int64(binary.BigEndian.Uint64(v[:8])),
+				Visitors:  int64(binary.BigEndian.Uint64(v[8:])),
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Failed reading view counts: %s", err)
+	}
+	return ret, nil
+}
@@ -0,0 +1,157 @@
+package analytics
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// MAX_UNFLUSHED is the most counts a Counter keeps for retrying when writing
+// them to the Store fails.
+const MAX_UNFLUSHED = 100000
+
+// View is a single page view.
+type View struct {
+	Path string
+
+	// Visitor is the ID of the visitor from a Hasher.
+	Visitor string
+
+	// Country is from GeoIP.Country.
+	Country string
+
+	// Device is from Device.
+	Device string
+
+	Time time.Time
+}
+
+// Counter counts views and distinct visitors in memory, safe for concurrent
+// use, until they are written to a Store with Flush.
+//
+// To count each visitor only once a day the visitor IDs seen are kept, but
+// only for today and yesterday, and never written anywhere.
+type Counter struct {
+	// mutex protects everything below.
+	mutex sync.Mutex
+
+	// today is the latest day a view was added for.
+	today time.Time
+
+	// seen are the visitors already counted for each countKey.
+	seen map[countKey]map[string]bool
+
+	// unflushed are the counts not yet written to a Store.
+	unflushed map[countKey]*Count
+}
+
+// NewCounter returns a new Counter.
+func NewCounter() *Counter {
+	return &Counter{
+		seen:      map[countKey]map[string]bool{},
+		unflushed: map[countKey]*Count{},
+	}
+}
+
+// Add counts the view. Bots are only counted by DEVICE_DIMENSION, as
+// BOT_DEVICE, and are left out of every other dimension.
+func (c *Counter) Add(v View) {
+	day := Day(v.Time)
+	counts := []*Count{
+		{Day: day, Dimension: DEVICE_DIMENSION, Value: v.Device},
+	}
+	if v.Device != BOT_DEVICE {
+		counts = append(counts,
+			&Count{Day: day, Dimension: TOTAL_DIMENSION},
+			&Count{Day: day, Dimension: PATH_DIMENSION, Value: v.Path},
+			&Count{Day: day, Dimension: COUNTRY_DIMENSION, Value: v.Country},
+		)
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if day.After(c.today) {
+		c.today = day
+		// Forget the visitors from before yesterday, they can't be seen
+		// again, except by views that arrive very late.
+		yesterday := day.AddDate(0, 0, -1).Unix()
+		for k := range c.seen {
+			if k.day < yesterday {
+				delete(c.seen, k)
+			}
+		}
+	}
+	for _, count := range counts {
+		key := count.key()
+		seen, ok := c.seen[key]
+		if !ok {
+			seen = map[string]bool{}
+			c.seen[key] = seen
+		}
+		if !seen[v.Visitor] {
+			seen[v.Visitor] = true
+			count.Visitors = 1
+		}
+		count.Views = 1
+		c.add(count)
+	}
+}
+
+// add adds count to the unflushed counts. The caller must hold mutex.
+func (c *Counter) add(count *Count) {
+	key := count.key()
+	if u, ok := c.unflushed[key]; ok {
+		u.Views += count.Views
+		u.Visitors += count.Visitors
+		return
+	}
+	copied := *count
+	c.unflushed[key] = &copied
+}
+
+// counts returns copies of the unflushed counts, removing them if take is
+// true.
+func (c *Counter) counts(take bool) []*Count {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	ret := make([]*Count, 0, len(c.unflushed))
+	for _, u := range c.unflushed {
+		copied := *u
+		ret = append(ret, &copied)
+	}
+	if take {
+		c.unflushed = map[countKey]*Count{}
+	}
+	return ret
+}
+
+// Counts returns copies of all the counts not yet written to a Store.
+func (c *Counter) Counts() []*Count {
+	return c.counts(false)
+}
+
+// Flush writes all the unflushed counts to store. If that fails those not
+// written are kept to be written by the next Flush, unless there are already
+// MAX_UNFLUSHED waiting, in which case they are dropped.
+func (c *Counter) Flush(ctx context.Context, store Store) error {
+	counts := c.counts(true)
+	if len(counts) == 0 {
+		return nil
+	}
+	err := store.Add(ctx, counts)
+	if err == nil {
+		return nil
+	}
+	if uerr, ok := err.(*UnwrittenError); ok {
+		counts = uerr.Unwritten
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if len(c.unflushed)+len(counts) > MAX_UNFLUSHED {
+		return fmt.Errorf("Dropped %d counts, too many are unflushed: %s", len(counts), err)
+	}
+	for _, count := range counts {
+		c.add(count)
+	}
+	return fmt.Errorf("Failed to write %d counts, will retry: %s", len(counts), err)
+}
@@ -0,0 +1,173 @@
+package analytics
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// failingStore is a Store whose Add fails.
+type failingStore struct {
+	Store
+}
+
+func (f failingStore) Add(ctx context.Context, counts []*Count) error {
+	return fmt.Errorf("Failed")
+}
+
+// partialStore is a Store whose Add only writes the first count.
+type partialStore struct {
+	Store
+}
+
+func (p partialStore) Add(ctx context.Context, counts []*Count) error {
+	if err := p.Store.Add(ctx, counts[:1]); err != nil {
+		return err
+	}
+	return &UnwrittenError{Unwritten: counts[1:], Err: fmt.Errorf("Failed")}
+}
+
+// find returns the views and visitors in counts for the dimension and value.
+func find(counts []*Count, dimension, value string) (int64, int64) {
+	var views, visitors int64
+	for _, c := range counts {
+		if c.Dimension == dimension && c.Value == value {
+			views += c.Views
+			visitors += c.Visitors
+		}
+	}
+	return views, visitors
+}
+
+func TestCounter(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2018, 3, 4, 15, 16, 0, 0, time.UTC)
+	c := NewCounter()
+	c.Add(View{Path: "/a", Visitor: "1", Country: "NZ", Device: DESKTOP_DEVICE, Time: now})
+	c.Add(View{Path: "/a", Visitor: "1", Country: "NZ", Device: DESKTOP_DEVICE, Time: now.Add(time.Minute)})
+	c.Add(View{Path: "/b", Visitor: "1", Country: "NZ", Device: DESKTOP_DEVICE, Time: now.Add(time.Hour)})
+	c.Add(View{Path: "/a", Visitor: "2", Country: "US", Device: MOBILE_DEVICE, Time: now})
+	c.Add(View{Path: "/a", Visitor: "3", Country: UNKNOWN_COUNTRY, Device: BOT_DEVICE, Time: now})
+
+	counts := c.Counts()
+	views, visitors := find(counts, TOTAL_DIMENSION, "")
+	assert.Equal(t, int64(4), views)
+	assert.Equal(t, int64(2), visitors)
+	views, visitors = find(counts, PATH_DIMENSION, "/a")
+	assert.Equal(t, int64(3), views)
+	assert.Equal(t, int64(2), visitors)
+	views, visitors = find(counts, COUNTRY_DIMENSION, "NZ")
+	assert.Equal(t, int64(3), views)
+	assert.Equal(t, int64(1), visitors)
+	views, _ = find(counts, DEVICE_DIMENSION, DESKTOP_DEVICE)
+	assert.Equal(t, int64(3), views)
+	// Bots are only counted by device.
+	views, visitors = find(counts, DEVICE_DIMENSION, BOT_DEVICE)
+	assert.Equal(t, int64(1), views)
+	assert.Equal(t, int64(1), visitors)
+	views, _ = find(counts, COUNTRY_DIMENSION, UNKNOWN_COUNTRY)
+	assert.Equal(t, int64(0), views)
+
+	// Counts returns copies.
+	counts[0].Views = 100
+	views, _ = find(c.Counts(), TOTAL_DIMENSION, "")
+	assert.Equal(t, int64(4), views)
+
+	// Failed writes are retried.
+	assert.Error(t, c.Flush(ctx, failingStore{}))
+	views, _ = find(c.Counts(), TOTAL_DIMENSION, "")
+	assert.Equal(t, int64(4), views)
+
+	s := NewMemoryStore()
+	assert.NoError(t, c.Flush(ctx, s))
+	assert.Len(t, c.Counts(), 0)
+
+	// A visitor already counted today isn't counted again after a Flush, but
+	// is the next day.
+	c.Add(View{Path: "/a", Visitor: "1", Country: "NZ", Device: DESKTOP_DEVICE, Time: now.Add(2 * time.Hour)})
+	c.Add(View{Path: "/a", Visitor: "1", Country: "NZ", Device: DESKTOP_DEVICE, Time: now.Add(24 * time.Hour)})
+	assert.NoError(t, c.Flush(ctx, s))
+	day := Day(now)
+	stored, err := s.Get(ctx, day, day.AddDate(0, 0, 1))
+	assert.NoError(t, err)
+	views, visitors = find(stored, TOTAL_DIMENSION, "")
+	assert.Equal(t, int64(5), views)
+	assert.Equal(t, int64(2), visitors)
+	stored, err = s.Get(ctx, day.AddDate(0, 0, 1), day.AddDate(0, 0, 2))
+	assert.NoError(t, err)
+	views, visitors = find(stored, TOTAL_DIMENSION, "")
+	assert.Equal(t, int64(1), views)
+	assert.Equal(t, int64(1), visitors)
+
+	// Visitors from before yesterday are forgotten.
+	c.Add(View{Path: "/a", Visitor: "1", Country: "NZ", Device: DESKTOP_DEVICE, Time: now.Add(48 * time.Hour)})
+	c.mutex.Lock()
+	for k := range c.seen {
+		assert.True(t, k.day >= day.AddDate(0, 0, 1).Unix())
+	}
+	c.mutex.Unlock()
+}
+
+// TestCounterConcurrent adds, reads, and flushes at once, run with -race to
+// find data races.
+func TestCounterFlushPartial(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2018, 3, 4, 15, 16, 0, 0, time.UTC)
+	c := NewCounter()
+	c.Add(View{Path: "/a", Visitor: "1", Country: "NZ", Device: DESKTOP_DEVICE, Time: now})
+	n := len(c.Counts())
+
+	// Only the counts that weren't written are retried.
+	s := NewMemoryStore()
+	assert.Error(t, c.Flush(ctx, partialStore{s}))
+	assert.Len(t, c.Counts(), n-1)
+	assert.NoError(t, c.Flush(ctx, s))
+	assert.Len(t, c.Counts(), 0)
+	stored, err := s.Get(ctx, Day(now), Day(now).AddDate(0, 0, 1))
+	assert.NoError(t, err)
+	views, visitors := find(stored, TOTAL_DIMENSION, "")
+	assert.Equal(t, int64(1), views)
+	assert.Equal(t, int64(1), visitors)
+	views, visitors = find(stored, PATH_DIMENSION, "/a")
+	assert.Equal(t, int64(1), views)
+	assert.Equal(t, int64(1), visitors)
+	views, _ = find(stored, COUNTRY_DIMENSION, "NZ")
+	assert.Equal(t, int64(1), views)
+	views, _ = find(stored, DEVICE_DIMENSION, DESKTOP_DEVICE)
+	assert.Equal(t, int64(1), views)
+}
+
+func TestCounterConcurrent(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2018, 3, 4, 15, 16, 0, 0, time.UTC)
+	c := NewCounter()
+	s := NewMemoryStore()
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				c.Add(View{Path: fmt.Sprintf("/p%d", j%10), Visitor: fmt.Sprint(i), Country: "NZ", Device: MOBILE_DEVICE, Time: now})
+				if j%25 == 0 {
+					c.Counts()
+					assert.NoError(t, c.Flush(ctx, s))
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	assert.NoError(t, c.Flush(ctx, s))
+	stored, err := s.Get(ctx, Day(now), Day(now).AddDate(0, 0, 1))
+	assert.NoError(t, err)
+	views, visitors := find(stored, TOTAL_DIMENSION, "")
+	assert.Equal(t, int64(800), views)
+	assert.Equal(t, int64(8), visitors)
+	views, visitors = find(stored, PATH_DIMENSION, "/p3")
+	assert.Equal(t, int64(80), views)
+	assert.Equal(t, int64(8), visitors)
+}
@@ -0,0 +1,88 @@
+package analytics
+
+import (
+	"context"
+	"crypto/md5"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"github.com/jcgregorio/userve/go/dsbatch"
+	"go.skia.org/infra/go/ds"
+	"google.golang.org/api/iterator"
+)
+
+const VIEWS ds.Kind = "Views"
+
+// datastoreStore implements Store using Google Cloud Datastore.
+type datastoreStore struct{}
+
+// NewDatastoreStore returns a Store backed by Google Cloud Datastore.
+//
+// ds.Init must be called before using the returned Store.
+func NewDatastoreStore() Store {
+	return &datastoreStore{}
+}
+
+// newKey returns the key of the entity for c, named by a hash of its day,
+// dimension, and value, since paths can be longer than key names are allowed
+// to be.
+func newKey(c *Count) *datastore.Key {
+	key := ds.NewKey(VIEWS)
+	key.Name = fmt.Sprintf("%x", md5.Sum([]byte(fmt.Sprintf("%d\x00%s\x00%s", c.Day.Unix(), c.Dimension, c.Value))))
+	return key
+}
+
+func (d *datastoreStore) Add(ctx context.Context, counts []*Count) error {
+	// A transaction reads each entity once, so Counts for the same entity
+	// have to be added together first or all but the last would be lost.
+	counts = merge(counts)
+	keys := make([]*datastore.Key, len(counts))
+	for i, c := range counts {
+		keys[i] = newKey(c)
+	}
+	written, err := dsbatch.Update(ctx, len(counts), func(tx *datastore.Transaction, begin, end int) error {
+		stored := make([]Count, end-begin)
+		if err := dsbatch.GetMulti(tx, keys[begin:end], stored); err != nil {
+			return err
+		}
+		for i, c := range counts[begin:end] {
+			views, visitors := stored[i].Views, stored[i].Visitors
+			stored[i] = *c
+			stored[i].Day = c.Day.UTC()
+			stored[i].Views += views
+			stored[i].Visitors += visitors
+		}
+		_, err := tx.PutMulti(keys[begin:end], stored)
+		return err
+	})
+	if err != nil {
+		return &UnwrittenError{
+			Unwritten: counts[written:],
+			Err:       fmt.Errorf("Failed writing view counts: %s", err),
+		}
+	}
+	return nil
+}
+
+func (d *datastoreStore) Get(ctx context.Context, begin, end time.Time) ([]*Count, error) {
+	ret := []*Count{}
+	q := ds.NewQuery(VIEWS).
+		Filter("Day >=", begin.UTC()).
+		Filter("Day <", end.UTC()).
+		Order("Day")
+	it := ds.DS.Run(ctx, q)
+	for {
+		c := &Count{}
+		_, err := it.Next(c)
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return ret, fmt.Errorf("Failed while reading: %s", err)
+		}
+		c.Day = c.Day.UTC()
+		ret = append(ret, c)
+	}
+	return ret, nil
+}
@@ -0,0 +1,49 @@
+package analytics
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Device classes.
+const (
+	DESKTOP_DEVICE = "desktop"
+	MOBILE_DEVICE  = "mobile"
+	TABLET_DEVICE  = "tablet"
+	BOT_DEVICE     = "bot"
+)
+
+var (
+	// botAgents matches the User-Agents of crawlers, feed readers, link
+	// previewers, monitoring, and HTTP libraries.
+	botAgents = regexp.MustCompile(`(?i)bot\b|crawl|spider|slurp|archiver|scan|fetch|curl|wget|python|java/|go-http-client|okhttp|libwww|http\.rb|headless|lighthouse|pingdom|uptime|monitor|feed|rss|preview|facebookexternalhit|embedly|mediapartners`)
+
+	// tabletAgents matches tablets. Android tablets are found by leaving out
+	// "Mobile".
+	tabletAgents = regexp.MustCompile(`(?i)ipad|tablet|kindle|silk/|playbook`)
+
+	// mobileAgents matches phones.
+	mobileAgents = regexp.MustCompile(`(?i)mobi|iphone|ipod|android|blackberry|opera mini|windows phone`)
+)
+
+// IsBot returns true if the User-Agent isn't a person using a browser,
+// including when it is empty.
+func IsBot(userAgent string) bool {
+	return strings.TrimSpace(userAgent) == "" || botAgents.MatchString(userAgent)
+}
+
+// Device returns the class of device of the User-Agent, one of
+// DESKTOP_DEVICE, MOBILE_DEVICE, TABLET_DEVICE, or BOT_DEVICE.
+func Device(userAgent string) string {
+	switch {
+	case IsBot(userAgent):
+		return BOT_DEVICE
+	case tabletAgents.MatchString(userAgent):
+		return TABLET_DEVICE
+	case strings.Contains(strings.ToLower(userAgent), "android") && !strings.Contains(userAgent, "Mobile"):
+		return TABLET_DEVICE
+	case mobileAgents.MatchString(userAgent):
+		return MOBILE_DEVICE
+	}
+	return DESKTOP_DEVICE
+}
@@ -0,0 +1,61 @@
+package analytics
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// UNKNOWN_COUNTRY is the country of IP addresses that aren't in the GeoIP
+// database, or when there isn't one.
+const UNKNOWN_COUNTRY = "??"
+
+// GeoIP finds the country of IP addresses in a local MaxMind DB file, such
+// as GeoLite2-Country.mmdb, safe for concurrent use. A nil *GeoIP finds
+// UNKNOWN_COUNTRY for everything.
+type GeoIP struct {
+	reader *maxminddb.Reader
+}
+
+// countryRecord is the part of a GeoIP2 or GeoLite2 record that is used.
+type countryRecord struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+}
+
+// LoadGeoIP reads the MaxMind DB file filename into memory, so it can be
+// replaced while the old one is still in use. An empty filename returns nil.
+func LoadGeoIP(filename string) (*GeoIP, error) {
+	if filename == "" {
+		return nil, nil
+	}
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read GeoIP database: %s", err)
+	}
+	reader, err := maxminddb.FromBytes(b)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open GeoIP database %q: %s", filename, err)
+	}
+	return &GeoIP{reader: reader}, nil
+}
+
+// Country returns the ISO 3166-1 code of the country of ip, which is an IP
+// address without a port.
+func (g *GeoIP) Country(ip string) string {
+	if g == nil {
+		return UNKNOWN_COUNTRY
+	}
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return UNKNOWN_COUNTRY
+	}
+	var record countryRecord
+	if err := g.reader.Lookup(addr, &record); err != nil || record.Country.ISOCode == "" {
+		return UNKNOWN_COUNTRY
+	}
+	return record.Country.ISOCode
+}
@@ -0,0 +1,59 @@
+package analytics
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// memoryStore implements Store entirely in memory, for tests and for running
+// locally without any external services.
+type memoryStore struct {
+	mutex  sync.Mutex
+	counts map[countKey]*Count
+}
+
+// NewMemoryStore returns a Store that keeps everything in memory.
+func NewMemoryStore() Store {
+	return &memoryStore{
+		counts: map[countKey]*Count{},
+	}
+}
+
+func (s *memoryStore) Add(ctx context.Context, counts []*Count) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, c := range counts {
+		key := c.key()
+		stored, ok := s.counts[key]
+		if !ok {
+			stored = &Count{
+				Day:       Day(c.Day),
+				Dimension: c.Dimension,
+				Value:     c.Value,
+			}
+			s.counts[key] = stored
+		}
+		stored.Views += c.Views
+		stored.Visitors += c.Visitors
+	}
+	return nil
+}
+
+func (s *memoryStore) Get(ctx context.Context, begin, end time.Time) ([]*Count, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	ret := []*Count{}
+	for k, c := range s.counts {
+		if k.day < begin.Unix() || k.day >= end.Unix() {
+			continue
+		}
+		copied := *c
+		ret = append(ret, &copied)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Day.Before(ret[j].Day)
+	})
+	return ret, nil
+}
@@ -0,0 +1,107 @@
+package analytics
+
+import (
+	"sort"
+	"time"
+)
+
+// Series is the views and visitors of a path, country, device, or
+// everything, on each day of a Report.
+type Series struct {
+	// Name is the path, country, or device.
+	Name string
+
+	// Views and Visitors have the counts for each of Report.Days.
+	Views    []int64
+	Visitors []int64
+
+	// TotalViews and TotalVisitors are the sums of Views and Visitors. Since
+	// visitors can't be recognized from one day to the next TotalVisitors is
+	// the sum of the daily visitors, not the distinct visitors over the whole
+	// Report.
+	TotalViews    int64
+	TotalVisitors int64
+}
+
+// Report summarizes the Counts of a range of days.
+type Report struct {
+	Begin time.Time
+	End   time.Time
+
+	// Days are the starts of the days in [Begin, End).
+	Days []time.Time
+
+	// All is the series for all views by people.
+	All *Series
+
+	// Paths, Countries, and Devices are the series of each value of their
+	// dimensions, most views first. Devices includes BOT_DEVICE.
+	Paths     []*Series
+	Countries []*Series
+	Devices   []*Series
+}
+
+// NewReport summarizes counts in [begin, end).
+func NewReport(counts []*Count, begin, end time.Time) *Report {
+	begin = Day(begin)
+	r := &Report{
+		Begin: begin,
+		End:   end,
+		Days:  []time.Time{},
+	}
+	for t := begin; t.Before(end); t = t.AddDate(0, 0, 1) {
+		r.Days = append(r.Days, t)
+	}
+	newSeries := func(name string) *Series {
+		return &Series{
+			Name:     name,
+			Views:    make([]int64, len(r.Days)),
+			Visitors: make([]int64, len(r.Days)),
+		}
+	}
+	r.All = newSeries("")
+	dimensions := map[string]map[string]*Series{
+		PATH_DIMENSION:    {},
+		COUNTRY_DIMENSION: {},
+		DEVICE_DIMENSION:  {},
+	}
+	for _, c := range counts {
+		i := int(c.Day.Sub(begin) / (24 * time.Hour))
+		if c.Day.Before(begin) || i >= len(r.Days) {
+			continue
+		}
+		s := r.All
+		if m, ok := dimensions[c.Dimension]; ok {
+			s, ok = m[c.Value]
+			if !ok {
+				s = newSeries(c.Value)
+				m[c.Value] = s
+			}
+		} else if c.Dimension != TOTAL_DIMENSION {
+			continue
+		}
+		s.Views[i] += c.Views
+		s.Visitors[i] += c.Visitors
+		s.TotalViews += c.Views
+		s.TotalVisitors += c.Visitors
+	}
+	r.Paths = sortedSeries(dimensions[PATH_DIMENSION])
+	r.Countries = sortedSeries(dimensions[COUNTRY_DIMENSION])
+	r.Devices = sortedSeries(dimensions[DEVICE_DIMENSION])
+	return r
+}
+
+// sortedSeries returns the series in m, most views first.
+func sortedSeries(m map[string]*Series) []*Series {
+	ret := make([]*Series, 0, len(m))
+	for _, s := range m {
+		ret = append(ret, s)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].TotalViews != ret[j].TotalViews {
+			return ret[i].TotalViews > ret[j].TotalViews
+		}
+		return ret[i].Name < ret[j].Name
+	})
+	return ret
+}
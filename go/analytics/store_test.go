@@ -0,0 +1,97 @@
+package analytics
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	bolt "go.etcd.io/bbolt"
+)
+
+// testStore exercises the Store s, which must be empty.
+func testStore(t *testing.T, s Store) {
+	ctx := context.Background()
+	day := time.Date(2018, 3, 4, 0, 0, 0, 0, time.UTC)
+	next := day.AddDate(0, 0, 1)
+
+	assert.NoError(t, s.Add(ctx, []*Count{
+		{Day: day, Dimension: TOTAL_DIMENSION, Views: 3, Visitors: 2},
+		{Day: day, Dimension: PATH_DIMENSION, Value: "/a", Views: 3, Visitors: 2},
+		{Day: next, Dimension: COUNTRY_DIMENSION, Value: "NZ", Views: 1, Visitors: 1},
+	}))
+	// Adding again adds to the stored counts, including several for the
+	// same day, dimension, and value at once.
+	assert.NoError(t, s.Add(ctx, []*Count{
+		{Day: day, Dimension: TOTAL_DIMENSION, Views: 1, Visitors: 1},
+		{Day: day, Dimension: TOTAL_DIMENSION, Views: 1},
+	}))
+
+	counts, err := s.Get(ctx, day, next)
+	assert.NoError(t, err)
+	assert.Len(t, counts, 2)
+	for _, c := range counts {
+		assert.True(t, day.Equal(c.Day))
+		if c.Dimension == TOTAL_DIMENSION {
+			assert.Equal(t, int64(5), c.Views)
+			assert.Equal(t, int64(3), c.Visitors)
+		} else {
+			assert.Equal(t, "/a", c.Value)
+		}
+	}
+
+	counts, err = s.Get(ctx, next, next.AddDate(0, 0, 1))
+	assert.NoError(t, err)
+	assert.Len(t, counts, 1)
+	assert.Equal(t, "NZ", counts[0].Value)
+	assert.Equal(t, COUNTRY_DIMENSION, counts[0].Dimension)
+
+	counts, err = s.Get(ctx, day, next.AddDate(0, 0, 1))
+	assert.NoError(t, err)
+	assert.Len(t, counts, 3)
+
+	// Only Counts whose Day is in [begin, end) are returned, even if begin
+	// and end aren't at midnight.
+	counts, err = s.Get(ctx, day.Add(time.Hour), next.Add(time.Hour))
+	assert.NoError(t, err)
+	assert.Len(t, counts, 1)
+	assert.Equal(t, "NZ", counts[0].Value)
+}
+
+func TestBoltStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "analytics")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	db, err := bolt.Open(filepath.Join(dir, "test.db"), 0600, nil)
+	assert.NoError(t, err)
+	defer db.Close()
+	s, err := NewBoltStore(db)
+	assert.NoError(t, err)
+	testStore(t, s)
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, NewMemoryStore())
+}
+
+func TestMerge(t *testing.T) {
+	day := time.Date(2018, 3, 4, 0, 0, 0, 0, time.UTC)
+	merged := merge([]*Count{
+		{Day: day, Dimension: PATH_DIMENSION, Value: "/a", Views: 1, Visitors: 1},
+		{Day: day, Dimension: PATH_DIMENSION, Value: "/b", Views: 2, Visitors: 1},
+		{Day: day, Dimension: PATH_DIMENSION, Value: "/a", Views: 4, Visitors: 2},
+	})
+	assert.Len(t, merged, 2)
+	assert.Equal(t, "/a", merged[0].Value)
+	assert.Equal(t, int64(5), merged[0].Views)
+	assert.Equal(t, int64(3), merged[0].Visitors)
+}
+
+func TestDay(t *testing.T) {
+	ts := time.Date(2018, 3, 4, 21, 16, 17, 0, time.FixedZone("EST", -5*3600))
+	assert.Equal(t, time.Date(2018, 3, 5, 0, 0, 0, 0, time.UTC), Day(ts))
+}
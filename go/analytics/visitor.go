@@ -0,0 +1,59 @@
+package analytics
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+)
+
+// SALT_SIZE is the number of random bytes in the daily salt.
+const SALT_SIZE = 32
+
+// Hasher turns the IP address and User-Agent of a request into an opaque
+// visitor ID that is the same for the whole of a day, safe for concurrent
+// use.
+//
+// The salt is random, never written anywhere, and replaced at the start of
+// each day, so neither the IDs of one day nor the stored counts can be
+// linked to the IDs of another day, or back to an IP address. A restart also
+// replaces the salt, so visitors before and after it are counted twice.
+type Hasher struct {
+	// mutex protects day and salt.
+	mutex sync.Mutex
+	day   time.Time
+	salt  []byte
+}
+
+// NewHasher returns a new Hasher.
+func NewHasher() *Hasher {
+	return &Hasher{}
+}
+
+// saltFor returns the salt for the day t is in, replacing the salt of any
+// other day.
+func (h *Hasher) saltFor(t time.Time) []byte {
+	day := Day(t)
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if h.salt == nil || !h.day.Equal(day) {
+		salt := make([]byte, SALT_SIZE)
+		if _, err := rand.Read(salt); err != nil {
+			// There's no safe fallback, don't count anyone rather than risk
+			// hashes that could be reversed.
+			panic(err)
+		}
+		h.day, h.salt = day, salt
+	}
+	return h.salt
+}
+
+// Visitor returns the ID of the visitor with the given IP address and
+// User-Agent at time t.
+func (h *Hasher) Visitor(ip, userAgent string, t time.Time) string {
+	sum := sha256.New()
+	_, _ = sum.Write(h.saltFor(t))
+	_, _ = sum.Write([]byte(ip + "\x00" + userAgent))
+	return hex.EncodeToString(sum.Sum(nil)[:16])
+}
@@ -0,0 +1,498 @@
+// Package config reads the userve configuration file.
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"path"
+	"regexp"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Storage backends, for Storage.Type.
+const (
+	DATASTORE_STORAGE = "datastore"
+	BOLT_STORAGE      = "bolt"
+	MEMORY_STORAGE    = "memory"
+)
+
+// DEFAULT_FEED is the Atom feed of a site, relative to its Source.
+const DEFAULT_FEED = "news/feed/index.atom"
+
+// DEFAULT_CACHE_CONTROL is the Cache-Control for files that don't match any
+// CachePolicy.
+const DEFAULT_CACHE_CONTROL = "max-age=300"
+
+// DefaultCachePolicies are used for a Site that doesn't give any. Assets
+// with a content hash in the name, such as main-3f2a9c1b.css, never change
+// so can be cached forever, while HTML and Atom change whenever the site is
+// rebuilt.
+var DefaultCachePolicies = []CachePolicy{
+	{Pattern: `~-[0-9a-f]{8,}\.[a-z0-9]+$`, CacheControl: "public, max-age=31536000, immutable"},
+	{Pattern: "*.html", CacheControl: "public, max-age=300"},
+	{Pattern: "*.atom", CacheControl: "public, max-age=300"},
+}
+
+// DefaultErrorPages are the error pages, relative to Source, used for the
+// statuses a Site doesn't give its own page for.
+var DefaultErrorPages = map[int]string{
+	404: "404.html",
+	410: "410.html",
+	500: "500.html",
+}
+
+// Config is the userve configuration, usually loaded from a YAML file. A
+// single site can be configured at the top level, for example:
+//
+//	origins:
+//	  - https://example.com
+//	target_hosts:
+//	  - example.com
+//	  - www.example.com
+//	admin:
+//	  client_id: 1234.apps.googleusercontent.com
+//	  emails:
+//	    - me@example.com
+//	storage:
+//	  type: bolt
+//	  file: /var/lib/userve/userve.db
+//	tls:
+//	  cache_file: /var/lib/userve/letsencrypt.cache
+//	referrer_blocklist: /etc/userve/referrer-spam
+//	geoip_database: /var/lib/userve/GeoLite2-Country.mmdb
+//
+// or several sites, chosen by the Host header of each request, under sites:
+//
+//	sites:
+//	  - name: example
+//	    origins: [https://example.com]
+//	    source: /var/www/example
+//	  - name: blog
+//	    origins: [https://blog.example.org]
+//	    source: /var/www/blog
+//	    redirect_file: /var/www/blog.redirects
+//	    namespace: blog
+//	    cache:
+//	      - pattern: /static/*
+//	        cache_control: public, max-age=86400
+//	      - pattern: "*.html"
+//	        cache_control: no-cache
+//	    error_pages:
+//	      404: errors/missing.html
+//	    private:
+//	      - drafts/
+//	      - "*.bak"
+//	admin:
+//	  ...
+type Config struct {
+	// SingleSite is the site configured at the top level, if Sites isn't
+	// used.
+	SingleSite Site `yaml:",inline"`
+
+	// Sites are all the sites served. After loading it always has at least
+	// one Site, which is SingleSite if no sites were given.
+	Sites []*Site `yaml:"sites"`
+
+	Admin   Admin   `yaml:"admin"`
+	Storage Storage `yaml:"storage"`
+	TLS     TLS     `yaml:"tls"`
+
+	// ReferrerBlocklist is a file of referrer spam patterns, see
+	// referrers.Blocklist. It is reloaded when it changes.
+	ReferrerBlocklist string `yaml:"referrer_blocklist"`
+
+	// GeoIPDatabase is a MaxMind DB file, such as GeoLite2-Country.mmdb,
+	// used to find the country of visitors. It is reloaded when it changes.
+	GeoIPDatabase string `yaml:"geoip_database"`
+}
+
+// Site is a single site served by userve.
+type Site struct {
+	// Name identifies the site in logs.
+	Name string `yaml:"name"`
+
+	// Origins are the origins the site is served from, such as
+	// "https://example.com". The first is the canonical origin. Requests are
+	// matched to a site by the hosts of its origins.
+	Origins []string `yaml:"origins"`
+
+	// TargetHosts are the hosts accepted in Webmention targets. Defaults to
+	// the hosts of Origins.
+	TargetHosts []string `yaml:"target_hosts"`
+
+	// WebmentionEndpoint is the URL advertised in the Link rel=webmention
+	// header. Defaults to /u/webmention on the canonical origin.
+	WebmentionEndpoint string `yaml:"webmention_endpoint"`
+
+	// Source is the directory with the static files of the site.
+	Source string `yaml:"source"`
+
+	// RedirectFile is the file of redirect rules for the site, if any, see
+	// the redirect package.
+	RedirectFile string `yaml:"redirect_file"`
+
+	// Feed is the Atom feed that is monitored for outgoing Webmentions,
+	// relative to Source. Defaults to DEFAULT_FEED.
+	Feed string `yaml:"feed"`
+
+	// Namespace keeps the mentions of the site separate from those of other
+	// sites in storage. The empty namespace is the default one for the
+	// storage, so at most one site may leave it empty.
+	Namespace string `yaml:"namespace"`
+
+	// Cache are the Cache-Control policies for static files, the first that
+	// matches is used. Defaults to DefaultCachePolicies.
+	Cache []CachePolicy `yaml:"cache"`
+
+	// ErrorPages are the pages, relative to Source, served for error
+	// statuses. A page is a html/template, executed with the status, path,
+	// and suggested paths for the request. Missing statuses default to
+	// DefaultErrorPages, and a page that doesn't exist falls back to a
+	// plain built in one.
+	ErrorPages map[int]string `yaml:"error_pages"`
+
+	// Private are patterns, as for CachePolicy, of files that are never
+	// served. A pattern matches a file if it matches its path or the path
+	// of any directory it is in, so "drafts" hides every drafts directory
+	// and all that's in them, and "/notes/*.txt" hides text files in
+	// /notes. Files and directories whose names start with a ".", other
+	// than .well-known, are always private.
+	Private []string `yaml:"private"`
+
+	// AllowExternalSymlinks allows serving files through symlinks that lead
+	// outside of Source. By default they are refused.
+	AllowExternalSymlinks bool `yaml:"allow_external_symlinks"`
+
+	// cacheMatchers are the compiled patterns of Cache.
+	cacheMatchers []func(string) bool
+
+	// privateMatchers are the compiled patterns of Private.
+	privateMatchers []func(string) bool
+}
+
+// CachePolicy is the Cache-Control for static files that match Pattern.
+//
+// Pattern is either a regular expression prefixed with ~, matched against
+// the path of the file relative to Source, such as "/css/main.css", or a
+// glob as used by path.Match. A glob with a / is matched against the whole
+// path, otherwise just against the file name, so "*.css" matches CSS files
+// in any directory.
+type CachePolicy struct {
+	Pattern      string `yaml:"pattern"`
+	CacheControl string `yaml:"cache_control"`
+}
+
+// matcher compiles the Pattern.
+func (c CachePolicy) matcher() (func(string) bool, error) {
+	if c.CacheControl == "" {
+		return nil, fmt.Errorf("Cache policy %q needs a cache_control.", c.Pattern)
+	}
+	m, err := compilePattern(c.Pattern)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid cache pattern %q: %s", c.Pattern, err)
+	}
+	return m, nil
+}
+
+// compilePattern compiles a pattern as described for CachePolicy.
+func compilePattern(pattern string) (func(string) bool, error) {
+	if strings.HasPrefix(pattern, "~") {
+		re, err := regexp.Compile(pattern[1:])
+		if err != nil {
+			return nil, err
+		}
+		return re.MatchString, nil
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, err
+	}
+	return func(p string) bool {
+		if !strings.Contains(pattern, "/") {
+			p = path.Base(p)
+		}
+		match, _ := path.Match(pattern, p)
+		return match
+	}, nil
+}
+
+// Admin controls who can see the admin pages, such as triage and referrers.
+type Admin struct {
+	// ClientID is the Google Sign-In OAuth client id.
+	ClientID string `yaml:"client_id"`
+
+	// Emails are the email addresses of admins.
+	Emails []string `yaml:"emails"`
+}
+
+// Storage controls where mentions are stored.
+type Storage struct {
+	// Type is one of DATASTORE_STORAGE, BOLT_STORAGE, or MEMORY_STORAGE. If
+	// empty it is up to the caller to pick one.
+	Type string `yaml:"type"`
+
+	// File is the database file for BOLT_STORAGE.
+	File string `yaml:"file"`
+
+	// Project and Namespace are the Cloud Datastore project and default
+	// namespace for DATASTORE_STORAGE.
+	Project   string `yaml:"project"`
+	Namespace string `yaml:"namespace"`
+}
+
+// TLS controls the letsencrypt certificates used when not running locally.
+type TLS struct {
+	// CacheFile is where letsencrypt state and certificates are kept.
+	CacheFile string `yaml:"cache_file"`
+
+	// Hosts restricts the hosts certificates are requested for. Defaults to
+	// the hosts of all the sites.
+	Hosts []string `yaml:"hosts"`
+}
+
+// Default returns the configuration used when there is no config file, which
+// is the one for bitworking.org.
+func Default() *Config {
+	c := &Config{
+		SingleSite: Site{
+			Origins: []string{"https://bitworking.org"},
+		},
+		Admin: Admin{
+			ClientID: "952643138919-jh0117ivtbqkc9njoh91csm7s465c4na.apps.googleusercontent.com",
+			Emails:   []string{"joe.gregorio@gmail.com"},
+		},
+		Storage: Storage{
+			File:      "userve.db",
+			Project:   "heroic-muse-88515",
+			Namespace: "blog",
+		},
+		TLS: TLS{
+			CacheFile: "/home/jcgregorio/letsencrypt.cache",
+		},
+	}
+	if err := c.setDefaults(); err != nil {
+		panic(err)
+	}
+	return c
+}
+
+// Parse parses and validates a YAML configuration.
+func Parse(b []byte) (*Config, error) {
+	c := &Config{}
+	if err := yaml.UnmarshalStrict(b, c); err != nil {
+		return nil, fmt.Errorf("Failed to parse config: %s", err)
+	}
+	if err := c.setDefaults(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Load reads, parses, and validates the YAML configuration file at filename.
+func Load(filename string) (*Config, error) {
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to read config: %s", err)
+	}
+	return Parse(b)
+}
+
+// setDefaults validates the config and fills in the values derived from
+// others.
+func (c *Config) setDefaults() error {
+	if len(c.Sites) == 0 {
+		c.Sites = []*Site{&c.SingleSite}
+	} else if len(c.SingleSite.Origins) > 0 {
+		return fmt.Errorf("Sites can't be given both at the top level and under sites.")
+	}
+	hosts := map[string]string{}
+	namespaces := map[string]string{}
+	allHosts := []string{}
+	for i, s := range c.Sites {
+		if s.Name == "" {
+			if len(c.Sites) > 1 {
+				return fmt.Errorf("Site %d needs a name.", i)
+			}
+			s.Name = "default"
+		}
+		if err := s.setDefaults(); err != nil {
+			return fmt.Errorf("Site %q: %s", s.Name, err)
+		}
+		if len(c.Sites) > 1 && s.Source == "" {
+			return fmt.Errorf("Site %q: A source directory is required.", s.Name)
+		}
+		if other, ok := namespaces[s.Namespace]; ok {
+			return fmt.Errorf("Sites %q and %q have the same namespace %q.", other, s.Name, s.Namespace)
+		}
+		namespaces[s.Namespace] = s.Name
+		for _, h := range s.Hosts() {
+			if other, ok := hosts[h]; ok {
+				if other == s.Name {
+					continue
+				}
+				return fmt.Errorf("Sites %q and %q both serve %q.", other, s.Name, h)
+			}
+			hosts[h] = s.Name
+			allHosts = append(allHosts, h)
+		}
+	}
+	if len(c.TLS.Hosts) == 0 {
+		c.TLS.Hosts = allHosts
+	}
+	switch c.Storage.Type {
+	case "", DATASTORE_STORAGE, BOLT_STORAGE, MEMORY_STORAGE:
+	default:
+		return fmt.Errorf("Unknown storage type: %q", c.Storage.Type)
+	}
+	if c.Storage.File == "" {
+		c.Storage.File = "userve.db"
+	}
+	if c.Storage.Type == DATASTORE_STORAGE && c.Storage.Project == "" {
+		return fmt.Errorf("Storage project is required for datastore storage.")
+	}
+	return nil
+}
+
+func (s *Site) setDefaults() error {
+	if len(s.Origins) == 0 {
+		return fmt.Errorf("At least one origin is required.")
+	}
+	hosts := []string{}
+	for i, o := range s.Origins {
+		u, err := url.Parse(o)
+		if err != nil {
+			return fmt.Errorf("Invalid origin %q: %s", o, err)
+		}
+		if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || strings.Trim(u.Path, "/") != "" {
+			return fmt.Errorf("Invalid origin %q: must be a scheme and host, e.g. https://example.com.", o)
+		}
+		s.Origins[i] = u.Scheme + "://" + strings.ToLower(u.Host)
+		hosts = append(hosts, strings.ToLower(u.Hostname()))
+	}
+	if len(s.TargetHosts) == 0 {
+		s.TargetHosts = hosts
+	}
+	if s.WebmentionEndpoint == "" {
+		s.WebmentionEndpoint = s.Origins[0] + "/u/webmention"
+	}
+	if s.Feed == "" {
+		s.Feed = DEFAULT_FEED
+	}
+	if len(s.Cache) == 0 {
+		s.Cache = append([]CachePolicy{}, DefaultCachePolicies...)
+	}
+	if s.ErrorPages == nil {
+		s.ErrorPages = map[int]string{}
+	}
+	for status, page := range s.ErrorPages {
+		if status < 400 || status > 599 {
+			return fmt.Errorf("Error page %q must be for a 4xx or 5xx status, not %d.", page, status)
+		}
+	}
+	for status, page := range DefaultErrorPages {
+		if _, ok := s.ErrorPages[status]; !ok {
+			s.ErrorPages[status] = page
+		}
+	}
+	s.cacheMatchers = nil
+	for _, c := range s.Cache {
+		m, err := c.matcher()
+		if err != nil {
+			return err
+		}
+		s.cacheMatchers = append(s.cacheMatchers, m)
+	}
+	s.privateMatchers = nil
+	for _, p := range s.Private {
+		m, err := compilePattern(strings.TrimSuffix(p, "/"))
+		if err != nil {
+			return fmt.Errorf("Invalid private pattern %q: %s", p, err)
+		}
+		s.privateMatchers = append(s.privateMatchers, m)
+	}
+	return nil
+}
+
+// CacheControl returns the Cache-Control for the static file at filename,
+// relative to Source.
+func (s *Site) CacheControl(filename string) string {
+	for i, m := range s.cacheMatchers {
+		if m(filename) {
+			return s.Cache[i].CacheControl
+		}
+	}
+	return DEFAULT_CACHE_CONTROL
+}
+
+// IsPrivate returns true if the file at filename, relative to Source, must
+// not be served, see Private.
+func (s *Site) IsPrivate(filename string) bool {
+	filename = path.Clean("/" + filename)
+	for p := filename; p != "/"; p = path.Dir(p) {
+		name := path.Base(p)
+		if strings.HasPrefix(name, ".") && name != ".well-known" {
+			return true
+		}
+		for _, m := range s.privateMatchers {
+			if m(p) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Hosts returns the hosts of the site's origins, without ports.
+func (s *Site) Hosts() []string {
+	ret := []string{}
+	for _, o := range s.Origins {
+		if u, err := url.Parse(o); err == nil {
+			ret = append(ret, u.Hostname())
+		}
+	}
+	return ret
+}
+
+// IsOwnURL returns true if u is on one of the site's origins.
+func (s *Site) IsOwnURL(u string) bool {
+	for _, o := range s.Origins {
+		if u == o || strings.HasPrefix(u, o+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// SiteFor returns the Site for requests to host, which may include a port,
+// or nil if there isn't one. If only one site is configured it is used for
+// every host, which makes running locally easy.
+func (c *Config) SiteFor(host string) *Site {
+	if len(c.Sites) == 1 {
+		return c.Sites[0]
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(host)
+	for _, s := range c.Sites {
+		for _, h := range s.Hosts() {
+			if h == host {
+				return s
+			}
+		}
+	}
+	return nil
+}
+
+// IsAdmin returns true if email belongs to an admin.
+func (c *Config) IsAdmin(email string) bool {
+	for _, e := range c.Admin.Emails {
+		if email != "" && strings.EqualFold(e, email) {
+			return true
+		}
+	}
+	return false
+}
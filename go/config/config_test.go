@@ -0,0 +1,229 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParse(t *testing.T) {
+	c, err := Parse([]byte(`
+origins:
+  - https://example.com/
+  - http://www.example.com
+admin:
+  client_id: 1234.apps.googleusercontent.com
+  emails:
+    - Me@example.com
+storage:
+  type: bolt
+  file: /var/lib/userve/userve.db
+tls:
+  cache_file: /var/lib/userve/letsencrypt.cache
+referrer_blocklist: /etc/userve/referrer-spam
+geoip_database: /var/lib/userve/GeoLite2-Country.mmdb
+`))
+	assert.NoError(t, err)
+	assert.Len(t, c.Sites, 1)
+	site := c.Sites[0]
+	assert.Equal(t, "default", site.Name)
+	assert.Equal(t, []string{"https://example.com", "http://www.example.com"}, site.Origins)
+	assert.Equal(t, []string{"example.com", "www.example.com"}, site.TargetHosts)
+	assert.Equal(t, []string{"example.com", "www.example.com"}, c.TLS.Hosts)
+	assert.Equal(t, "https://example.com/u/webmention", site.WebmentionEndpoint)
+	assert.Equal(t, DEFAULT_FEED, site.Feed)
+	assert.Equal(t, "", site.Namespace)
+	assert.Equal(t, DefaultErrorPages, site.ErrorPages)
+	assert.Equal(t, BOLT_STORAGE, c.Storage.Type)
+	assert.Equal(t, "/var/lib/userve/userve.db", c.Storage.File)
+	assert.Equal(t, "/var/lib/userve/letsencrypt.cache", c.TLS.CacheFile)
+	assert.Equal(t, "/etc/userve/referrer-spam", c.ReferrerBlocklist)
+	assert.Equal(t, "/var/lib/userve/GeoLite2-Country.mmdb", c.GeoIPDatabase)
+
+	assert.True(t, c.IsAdmin("me@example.com"))
+	assert.False(t, c.IsAdmin("you@example.com"))
+	assert.False(t, c.IsAdmin(""))
+
+	assert.True(t, site.IsOwnURL("https://example.com"))
+	assert.True(t, site.IsOwnURL("http://www.example.com/news/"))
+	assert.False(t, site.IsOwnURL("https://example.com.evil.org/"))
+	assert.False(t, site.IsOwnURL("https://other.org/"))
+
+	// With a single site every host is served by it.
+	assert.Equal(t, site, c.SiteFor("localhost:8000"))
+}
+
+func TestParseExplicit(t *testing.T) {
+	c, err := Parse([]byte(`
+origins: [https://example.com]
+target_hosts: [example.com, example.org]
+webmention_endpoint: https://mentions.example.com/
+error_pages:
+  404: errors/missing.html
+`))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"example.com", "example.org"}, c.Sites[0].TargetHosts)
+	assert.Equal(t, []string{"example.com"}, c.TLS.Hosts)
+	assert.Equal(t, "https://mentions.example.com/", c.Sites[0].WebmentionEndpoint)
+	assert.Equal(t, "userve.db", c.Storage.File)
+	assert.Equal(t, "errors/missing.html", c.Sites[0].ErrorPages[404])
+	assert.Equal(t, "410.html", c.Sites[0].ErrorPages[410])
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, bad := range []string{
+		``,
+		`origins: [example.com]`,
+		`origins: [https://example.com/blog]`,
+		`origins: [https://example.com]
+storage:
+  type: sqlite`,
+		`origins: [https://example.com]
+storage:
+  type: datastore`,
+		`origins: [https://example.com]
+unknown: true`,
+		`origins: [https://example.com]
+sites:
+  - name: other
+    origins: [https://example.org]
+    source: /tmp`,
+		`sites:
+  - name: a
+    origins: [https://example.com]
+    source: /tmp
+  - origins: [https://example.org]
+    source: /tmp
+    namespace: b`,
+		`sites:
+  - name: a
+    origins: [https://example.com]
+    source: /tmp
+  - name: b
+    origins: [https://example.org]
+    source: /tmp`,
+		`sites:
+  - name: a
+    origins: [https://example.com]
+    source: /tmp
+  - name: b
+    origins: [https://example.com]
+    source: /tmp
+    namespace: b`,
+		`sites:
+  - name: a
+    origins: [https://example.com]
+    source: /tmp
+  - name: b
+    origins: [https://example.org]
+    namespace: b`,
+	} {
+		_, err := Parse([]byte(bad))
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestParseSites(t *testing.T) {
+	c, err := Parse([]byte(`
+sites:
+  - name: example
+    origins: [https://example.com, https://www.example.com]
+    source: /var/www/example
+  - name: blog
+    origins: [https://blog.example.org]
+    source: /var/www/blog
+    redirect_file: /var/www/blog.redirects
+    feed: feed.atom
+    namespace: blog
+`))
+	assert.NoError(t, err)
+	assert.Len(t, c.Sites, 2)
+	assert.Equal(t, []string{"example.com", "www.example.com", "blog.example.org"}, c.TLS.Hosts)
+	blog := c.Sites[1]
+	assert.Equal(t, "blog", blog.Namespace)
+	assert.Equal(t, "feed.atom", blog.Feed)
+	assert.Equal(t, []string{"blog.example.org"}, blog.TargetHosts)
+	assert.Equal(t, "https://blog.example.org/u/webmention", blog.WebmentionEndpoint)
+
+	assert.Equal(t, c.Sites[0], c.SiteFor("www.example.com"))
+	assert.Equal(t, c.Sites[0], c.SiteFor("Example.com:443"))
+	assert.Equal(t, blog, c.SiteFor("blog.example.org"))
+	assert.Nil(t, c.SiteFor("other.org"))
+}
+
+func TestDefault(t *testing.T) {
+	c := Default()
+	assert.Len(t, c.Sites, 1)
+	assert.Equal(t, []string{"bitworking.org"}, c.Sites[0].TargetHosts)
+	assert.Equal(t, "https://bitworking.org/u/webmention", c.Sites[0].WebmentionEndpoint)
+	assert.True(t, c.IsAdmin("joe.gregorio@gmail.com"))
+}
+
+func TestCacheControl(t *testing.T) {
+	c, err := Parse([]byte(`
+origins: [https://example.com]
+cache:
+  - pattern: /static/*
+    cache_control: public, max-age=86400
+  - pattern: ~^/fonts/
+    cache_control: public, max-age=604800
+  - pattern: "*.html"
+    cache_control: no-cache
+`))
+	assert.NoError(t, err)
+	site := c.Sites[0]
+	assert.Equal(t, "public, max-age=86400", site.CacheControl("/static/main.css"))
+	assert.Equal(t, DEFAULT_CACHE_CONTROL, site.CacheControl("/static/css/main.css"))
+	assert.Equal(t, "public, max-age=604800", site.CacheControl("/fonts/a/b.woff2"))
+	assert.Equal(t, "no-cache", site.CacheControl("/news/2018/post.html"))
+	// The first policy that matches wins.
+	assert.Equal(t, "public, max-age=86400", site.CacheControl("/static/index.html"))
+	assert.Equal(t, DEFAULT_CACHE_CONTROL, site.CacheControl("/feed.atom"))
+
+	for _, bad := range []string{
+		"cache: [{pattern: '~[', cache_control: no-cache}]",
+		"cache: [{pattern: '[', cache_control: no-cache}]",
+		"cache: [{pattern: '*.css'}]",
+		"error_pages: {301: moved.html}",
+		"private: ['~[']",
+	} {
+		_, err := Parse([]byte("origins: [https://example.com]\n" + bad))
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestDefaultCachePolicies(t *testing.T) {
+	site := Default().Sites[0]
+	assert.Equal(t, "public, max-age=31536000, immutable", site.CacheControl("/css/main-3f2a9c1b.css"))
+	assert.Equal(t, "public, max-age=300", site.CacheControl("/news/2018/post.html"))
+	assert.Equal(t, "public, max-age=300", site.CacheControl("/news/feed/index.atom"))
+	assert.Equal(t, DEFAULT_CACHE_CONTROL, site.CacheControl("/css/main.css"))
+}
+
+func TestIsPrivate(t *testing.T) {
+	c, err := Parse([]byte(`
+origins: [https://example.com]
+private:
+  - drafts/
+  - "*.bak"
+  - /notes/*.txt
+  - ~^/tmp-
+`))
+	assert.NoError(t, err)
+	site := c.Sites[0]
+	assert.False(t, site.IsPrivate("/index.html"))
+	assert.False(t, site.IsPrivate("/news/2018/post.html"))
+	assert.False(t, site.IsPrivate("/.well-known/webfinger"))
+	assert.False(t, site.IsPrivate("/notes/a/b.txt"))
+	assert.False(t, site.IsPrivate("/draftsman.html"))
+
+	assert.True(t, site.IsPrivate("/.git/config"))
+	assert.True(t, site.IsPrivate("/css/.htaccess"))
+	assert.True(t, site.IsPrivate("/drafts"))
+	assert.True(t, site.IsPrivate("/drafts/post.html"))
+	assert.True(t, site.IsPrivate("/news/drafts/post.html"))
+	assert.True(t, site.IsPrivate("/index.html.bak"))
+	assert.True(t, site.IsPrivate("/notes/todo.txt"))
+	assert.True(t, site.IsPrivate("/tmp-upload/a.png"))
+	assert.True(t, site.IsPrivate("drafts/post.html"))
+}
@@ -0,0 +1,54 @@
+// Package dsbatch reads and writes Datastore entities in batches small enough
+// to fit in a single transaction.
+package dsbatch
+
+import (
+	"context"
+
+	"cloud.google.com/go/datastore"
+	"go.skia.org/infra/go/ds"
+)
+
+// MAX_BATCH is the most entities read and written in a single transaction.
+const MAX_BATCH = 250
+
+// Update calls f in a transaction for each batch of at most MAX_BATCH of n
+// entities, with the indexes [begin, end) of the entities in the batch.
+//
+// Each batch is committed before the next one starts, so if one fails the
+// ones before it have already been written. Update returns how many of the n
+// entities were written, which on success is all of them.
+func Update(ctx context.Context, n int, f func(tx *datastore.Transaction, begin, end int) error) (int, error) {
+	for begin := 0; begin < n; begin += MAX_BATCH {
+		end := begin + MAX_BATCH
+		if end > n {
+			end = n
+		}
+		_, err := ds.DS.RunInTransaction(ctx, func(tx *datastore.Transaction) error {
+			return f(tx, begin, end)
+		})
+		if err != nil {
+			return begin, err
+		}
+	}
+	return n, nil
+}
+
+// GetMulti is tx.GetMulti, except that entities that don't exist are left as
+// the zero value in dst rather than being an error.
+func GetMulti(tx *datastore.Transaction, keys []*datastore.Key, dst interface{}) error {
+	err := tx.GetMulti(keys, dst)
+	if err == nil {
+		return nil
+	}
+	merr, ok := err.(datastore.MultiError)
+	if !ok {
+		return err
+	}
+	for _, e := range merr {
+		if e != nil && e != datastore.ErrNoSuchEntity {
+			return e
+		}
+	}
+	return nil
+}
@@ -0,0 +1,209 @@
+package mention
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/skia-dev/glog"
+	"go.skia.org/infra/go/util"
+	"willnorris.com/go/microformats"
+)
+
+// UrlToPageReader returns a reader for the HTML page at the given url, used
+// for following author links.
+type UrlToPageReader func(url string) (io.ReadCloser, error)
+
+// MakeUrlToPageReader returns a UrlToPageReader that fetches pages with c,
+// with the same limits as for sources.
+func MakeUrlToPageReader(c *http.Client) UrlToPageReader {
+	return func(u string) (io.ReadCloser, error) {
+		resp, err := c.Get(u)
+		if err != nil {
+			return nil, fmt.Errorf("Error retrieving author page: %s", err)
+		}
+		if resp.StatusCode != 200 {
+			util.Close(resp.Body)
+			return nil, fmt.Errorf("Not a 200 response: %d", resp.StatusCode)
+		}
+		if _, err := checkContentType(resp, []string{HTML_TYPE, XHTML_TYPE}); err != nil {
+			util.Close(resp.Body)
+			return nil, err
+		}
+		return &limitedReadCloser{
+			ReadCloser: resp.Body,
+			remaining:  MAX_SOURCE_SIZE,
+		}, nil
+	}
+}
+
+// findEntry returns the h-entry in items that refers to target, or the first
+// h-entry if none do, along with the h-feed it is in, if any.
+func findEntry(items []*microformats.Microformat, target string) (*microformats.Microformat, *microformats.Microformat) {
+	var first, firstFeed *microformats.Microformat
+	var walk func(items []*microformats.Microformat, feed *microformats.Microformat) (*microformats.Microformat, *microformats.Microformat)
+	walk = func(items []*microformats.Microformat, feed *microformats.Microformat) (*microformats.Microformat, *microformats.Microformat) {
+		for _, it := range items {
+			if in("h-entry", it.Type) {
+				if mentionType(it, target) != MENTION_TYPE {
+					return it, feed
+				}
+				if first == nil {
+					first, firstFeed = it, feed
+				}
+			}
+			childFeed := feed
+			if in("h-feed", it.Type) {
+				childFeed = it
+			}
+			if e, f := walk(it.Children, childFeed); e != nil {
+				return e, f
+			}
+		}
+		return nil, nil
+	}
+	if e, f := walk(items, nil); e != nil {
+		return e, f
+	}
+	return first, firstFeed
+}
+
+// findHCards returns all the h-cards in items, including nested ones.
+func findHCards(items []*microformats.Microformat) []*microformats.Microformat {
+	ret := []*microformats.Microformat{}
+	for _, it := range items {
+		if in("h-card", it.Type) {
+			ret = append(ret, it)
+		}
+		ret = append(ret, findHCards(it.Children)...)
+	}
+	return ret
+}
+
+// representativeHCard returns the representative h-card of the page at
+// pageURL, see http://microformats.org/wiki/representative-h-card-parsing,
+// or nil if there isn't one.
+func representativeHCard(data *microformats.Data, pageURL string) *microformats.Microformat {
+	cards := findHCards(data.Items)
+	for _, c := range cards {
+		if containsURL(propURLs(c, "uid"), pageURL) && containsURL(propURLs(c, "url"), pageURL) {
+			return c
+		}
+	}
+	for _, c := range cards {
+		for _, u := range propURLs(c, "url") {
+			if containsURL(data.Rels["me"], u) {
+				return c
+			}
+		}
+	}
+	var match *microformats.Microformat
+	for _, c := range cards {
+		if containsURL(propURLs(c, "url"), pageURL) {
+			if match != nil {
+				return nil
+			}
+			match = c
+		}
+	}
+	return match
+}
+
+// fetchRepresentativeHCard fetches the page at u and returns its
+// representative h-card, or nil if it doesn't have one.
+func fetchRepresentativeHCard(u2p UrlToPageReader, u string) *microformats.Microformat {
+	pageURL, err := url.Parse(u)
+	if err != nil {
+		return nil
+	}
+	r, err := u2p(u)
+	if err != nil {
+		glog.Warningf("Failed to retrieve author page %q: %s", u, err)
+		return nil
+	}
+	defer util.Close(r)
+	return representativeHCard(microformats.Parse(r, pageURL), u)
+}
+
+// isURL returns true if s is an absolute http or https URL.
+func isURL(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// authorFromHCard records the author from an h-card, using defaultURL if the
+// h-card doesn't have a url.
+func authorFromHCard(ctx context.Context, u2r UrlToImageReader, m *Mention, card *microformats.Microformat, defaultURL string) {
+	findAuthor(ctx, u2r, m, card)
+	if m.AuthorURL == "" {
+		m.AuthorURL = defaultURL
+	}
+}
+
+// authorFromURL records the author found by following the author page at u.
+func authorFromURL(ctx context.Context, u2r UrlToImageReader, u2p UrlToPageReader, m *Mention, u string) {
+	if card := fetchRepresentativeHCard(u2p, u); card != nil {
+		authorFromHCard(ctx, u2r, m, card, u)
+		return
+	}
+	name := u
+	if parsed, err := url.Parse(u); err == nil {
+		name = parsed.Host
+	}
+	setAuthor(ctx, u2r, m, name, u, "")
+}
+
+// findAuthorship implements the authorship algorithm,
+// https://indieweb.org/authorship-spec, for a source whose h-entry doesn't
+// have an embedded h-card author, which findHEntry already handles.
+//
+// The author is taken from the h-entry author property, falling back to the
+// author of the h-feed it is in, then to the rel=author link of the page, and
+// finally to a lone h-card on the page. An author given as a URL is followed
+// to find the representative h-card there.
+func findAuthorship(ctx context.Context, u2r UrlToImageReader, u2p UrlToPageReader, m *Mention, data *microformats.Data) {
+	if m.Author != "" || m.AuthorURL != "" {
+		return
+	}
+	entry, feed := findEntry(data.Items, m.Target)
+	if entry == nil {
+		return
+	}
+	authors := entry.Properties["author"]
+	if len(authors) == 0 && feed != nil {
+		authors = feed.Properties["author"]
+	}
+	for _, a := range authors {
+		switch t := a.(type) {
+		case *microformats.Microformat:
+			if name := firstPropAsString(t, "name"); name == "" && t.Value == "" && firstPropAsString(t, "url") != "" {
+				authorFromURL(ctx, u2r, u2p, m, firstPropAsString(t, "url"))
+			} else {
+				findAuthor(ctx, u2r, m, t)
+			}
+			return
+		case string:
+			if isURL(t) {
+				authorFromURL(ctx, u2r, u2p, m, t)
+			} else if t != "" {
+				setAuthor(ctx, u2r, m, t, "", "")
+			}
+			return
+		}
+	}
+	if rels := data.Rels["author"]; len(rels) > 0 && isURL(rels[0]) {
+		authorFromURL(ctx, u2r, u2p, m, rels[0])
+		return
+	}
+	cards := []*microformats.Microformat{}
+	for _, it := range data.Items {
+		if in("h-card", it.Type) {
+			cards = append(cards, it)
+		}
+	}
+	if len(cards) == 1 {
+		findAuthor(ctx, u2r, m, cards[0])
+	}
+}
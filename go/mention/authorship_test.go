@@ -0,0 +1,87 @@
+package mention
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// authorshipPages maps the URLs of author pages to their saved copies in
+// testdata/authorship.
+var authorshipPages = map[string]string{
+	"https://alice.example.com/":    "alice.html",
+	"https://bob.example.com/about": "bob.html",
+}
+
+func parseAuthorship(t *testing.T, filename string) (*Mention, []string) {
+	Init(NewMemoryStore())
+	fetched := []string{}
+	urlToPageReader := func(url string) (io.ReadCloser, error) {
+		fetched = append(fetched, url)
+		name, ok := authorshipPages[url]
+		if !ok {
+			return nil, fmt.Errorf("Not found: %s", url)
+		}
+		return os.Open("./testdata/authorship/" + name)
+	}
+	urlToImageReader := func(url string) (io.ReadCloser, error) {
+		return os.Open("./testdata/author_image.jpg")
+	}
+	f, err := os.Open("./testdata/authorship/" + filename)
+	assert.NoError(t, err)
+	defer f.Close()
+	m := New("https://example.com/"+filename, "https://bitworking.org/news/2018/01/webmention-only")
+	m.ParseMicroformats(context.Background(), f, urlToImageReader, urlToPageReader)
+	return m, fetched
+}
+
+func TestAuthorshipFollowsAuthorURL(t *testing.T) {
+	m, fetched := parseAuthorship(t, "author-url.html")
+	assert.Equal(t, []string{"https://alice.example.com/"}, fetched)
+	assert.Equal(t, "Alice Example", m.Author)
+	assert.Equal(t, "https://alice.example.com/", m.AuthorURL)
+	assert.NotEqual(t, "", m.Thumbnail)
+}
+
+func TestAuthorshipFeedAuthor(t *testing.T) {
+	m, fetched := parseAuthorship(t, "feed-author.html")
+	assert.Empty(t, fetched)
+	assert.Equal(t, "Carol Example", m.Author)
+	assert.Equal(t, "https://carol.example.com/", m.AuthorURL)
+	assert.Equal(t, "", m.Thumbnail)
+}
+
+func TestAuthorshipRelAuthor(t *testing.T) {
+	m, fetched := parseAuthorship(t, "rel-author.html")
+	assert.Equal(t, []string{"https://bob.example.com/about"}, fetched)
+	assert.Equal(t, "Bob Example", m.Author)
+	assert.Equal(t, "https://bob.example.com/", m.AuthorURL)
+}
+
+func TestAuthorshipNameOnly(t *testing.T) {
+	m, fetched := parseAuthorship(t, "name-only.html")
+	assert.Empty(t, fetched)
+	assert.Equal(t, "Dave", m.Author)
+	assert.Equal(t, "", m.AuthorURL)
+}
+
+func TestAuthorshipPageHCard(t *testing.T) {
+	m, fetched := parseAuthorship(t, "page-hcard.html")
+	assert.Empty(t, fetched)
+	assert.Equal(t, "Erin Example", m.Author)
+	assert.Equal(t, "https://erin.example.com/", m.AuthorURL)
+}
+
+func TestAuthorshipUnreachableAuthorURL(t *testing.T) {
+	Init(NewMemoryStore())
+	m := New("https://example.com/", "https://bitworking.org/news/2018/01/webmention-only")
+	authorFromURL(context.Background(), nil, func(url string) (io.ReadCloser, error) {
+		return nil, fmt.Errorf("Not found: %s", url)
+	}, m, "https://missing.example.com/")
+	assert.Equal(t, "missing.example.com", m.Author)
+	assert.Equal(t, "https://missing.example.com/", m.AuthorURL)
+}
@@ -0,0 +1,265 @@
+package mention
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	mentionsBucket       = []byte("Mentions")
+	webMentionSentBucket = []byte("WebMentionSent")
+	thumbnailBucket      = []byte("Thumbnail")
+)
+
+// boltStore implements Store using an embedded BoltDB file. Mentions and
+// WebMentionSent records are stored as JSON, Thumbnails as raw PNG bytes.
+//
+// The default namespace uses the Mentions, WebMentionSent, and Thumbnail
+// buckets, other namespaces use buckets of the same names prefixed with the
+// namespace and a slash, created when first written to.
+type boltStore struct {
+	db *bolt.DB
+}
+
+// NewBoltStore returns a Store backed by the BoltDB file at filename, which
+// is created if it doesn't exist.
+func NewBoltStore(filename string) (Store, error) {
+	db, err := bolt.Open(filename, 0600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open %q: %s", filename, err)
+	}
+	return NewBoltStoreFromDB(db)
+}
+
+// NewBoltStoreFromDB returns a Store backed by the already open BoltDB
+// database db, which can be shared with other stores.
+func NewBoltStoreFromDB(db *bolt.DB) (Store, error) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		for _, b := range [][]byte{mentionsBucket, webMentionSentBucket, thumbnailBucket} {
+			if _, err := tx.CreateBucketIfNotExists(b); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create buckets: %s", err)
+	}
+	return &boltStore{db: db}, nil
+}
+
+// bucketName returns the name of the bucket for the namespace in ctx.
+func bucketName(ctx context.Context, name []byte) []byte {
+	if ns := Namespace(ctx); ns != "" {
+		return []byte(ns + "/" + string(name))
+	}
+	return name
+}
+
+// readBucket returns the bucket for the namespace in ctx, or nil if nothing
+// has been written to it yet.
+func readBucket(ctx context.Context, tx *bolt.Tx, name []byte) *bolt.Bucket {
+	return tx.Bucket(bucketName(ctx, name))
+}
+
+// writeBucket returns the bucket for the namespace in ctx, creating it if
+// needed.
+func writeBucket(ctx context.Context, tx *bolt.Tx, name []byte) (*bolt.Bucket, error) {
+	return tx.CreateBucketIfNotExists(bucketName(ctx, name))
+}
+
+func (b *boltStore) Put(ctx context.Context, m *Mention) error {
+	buf, err := json.Marshal(m)
+	if err != nil {
+		return fmt.Errorf("Failed encoding %#v: %s", *m, err)
+	}
+	err = b.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := writeBucket(ctx, tx, mentionsBucket)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(m.ID()), buf)
+	})
+	if err != nil {
+		return fmt.Errorf("Failed writing %#v: %s", *m, err)
+	}
+	return nil
+}
+
+func (b *boltStore) Get(ctx context.Context, id string) (*Mention, error) {
+	m := &Mention{}
+	err := b.db.View(func(tx *bolt.Tx) error {
+		bucket := readBucket(ctx, tx, mentionsBucket)
+		if bucket == nil {
+			return ErrNotFound
+		}
+		v := bucket.Get([]byte(id))
+		if v == nil {
+			return ErrNotFound
+		}
+		return json.Unmarshal(v, m)
+	})
+	if err == ErrNotFound {
+		return nil, err
+	} else if err != nil {
+		return nil, fmt.Errorf("Failed to find mention %q: %s", id, err)
+	}
+	return m, nil
+}
+
+// filter returns all the stored Mentions with their keys for which f
+// returns true.
+func (b *boltStore) filter(ctx context.Context, f func(m *Mention) bool) ([]*MentionWithKey, error) {
+	ret := []*MentionWithKey{}
+	err := b.db.View(func(tx *bolt.Tx) error {
+		bucket := readBucket(ctx, tx, mentionsBucket)
+		if bucket == nil {
+			return nil
+		}
+		return bucket.ForEach(func(k, v []byte) error {
+			var m Mention
+			if err := json.Unmarshal(v, &m); err != nil {
+				return fmt.Errorf("Failed decoding %q: %s", string(k), err)
+			}
+			if f(&m) {
+				ret = append(ret, &MentionWithKey{
+					Mention: m,
+					Key:     string(k),
+				})
+			}
+			return nil
+		})
+	})
+	return ret, err
+}
+
+func withoutKeys(mk []*MentionWithKey) []*Mention {
+	ret := make([]*Mention, 0, len(mk))
+	for _, m := range mk {
+		ret = append(ret, &m.Mention)
+	}
+	return ret
+}
+
+func (b *boltStore) GetByTarget(ctx context.Context, target string, all bool) ([]*Mention, error) {
+	mk, err := b.filter(ctx, func(m *Mention) bool {
+		return m.Target == target && (all || (m.Verification == VERIFY_VERIFIED && m.Moderation == MODERATION_APPROVED))
+	})
+	return withoutKeys(mk), err
+}
+
+func (b *boltStore) GetTriage(ctx context.Context, limit, offset int) ([]*MentionWithKey, error) {
+	mk, err := b.filter(ctx, func(m *Mention) bool { return true })
+	if err != nil {
+		return nil, err
+	}
+	sort.SliceStable(mk, func(i, j int) bool { return mk[i].TS.After(mk[j].TS) })
+	if offset >= len(mk) {
+		return []*MentionWithKey{}, nil
+	}
+	mk = mk[offset:]
+	if limit < len(mk) {
+		mk = mk[:limit]
+	}
+	return mk, nil
+}
+
+func (b *boltStore) GetQueued(ctx context.Context) ([]*Mention, error) {
+	mk, err := b.filter(ctx, func(m *Mention) bool {
+		return m.Verification == VERIFY_PENDING
+	})
+	return withoutKeys(mk), err
+}
+
+func (b *boltStore) UpdateModeration(ctx context.Context, key, moderation string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket := readBucket(ctx, tx, mentionsBucket)
+		if bucket == nil {
+			return fmt.Errorf("No such mention: %q", key)
+		}
+		v := bucket.Get([]byte(key))
+		if v == nil {
+			return fmt.Errorf("No such mention: %q", key)
+		}
+		var m Mention
+		if err := json.Unmarshal(v, &m); err != nil {
+			return fmt.Errorf("Failed decoding %q: %s", key, err)
+		}
+		m.Moderation = moderation
+		buf, err := json.Marshal(m)
+		if err != nil {
+			return fmt.Errorf("Failed encoding %q: %s", key, err)
+		}
+		return bucket.Put([]byte(key), buf)
+	})
+}
+
+func (b *boltStore) Sent(ctx context.Context, source string) (time.Time, bool) {
+	var dst WebMentionSent
+	found := false
+	err := b.db.View(func(tx *bolt.Tx) error {
+		bucket := readBucket(ctx, tx, webMentionSentBucket)
+		if bucket == nil {
+			return nil
+		}
+		v := bucket.Get([]byte(source))
+		if v == nil {
+			return nil
+		}
+		found = true
+		return json.Unmarshal(v, &dst)
+	})
+	if err != nil || !found {
+		return time.Time{}, false
+	}
+	return dst.TS, true
+}
+
+func (b *boltStore) RecordSent(ctx context.Context, source string, updated time.Time) error {
+	buf, err := json.Marshal(&WebMentionSent{
+		TS: updated.UTC(),
+	})
+	if err != nil {
+		return err
+	}
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := writeBucket(ctx, tx, webMentionSentBucket)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(source), buf)
+	})
+}
+
+func (b *boltStore) PutThumbnail(ctx context.Context, id string, png []byte) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket, err := writeBucket(ctx, tx, thumbnailBucket)
+		if err != nil {
+			return err
+		}
+		return bucket.Put([]byte(id), png)
+	})
+}
+
+func (b *boltStore) GetThumbnail(ctx context.Context, id string) ([]byte, error) {
+	var ret []byte
+	err := b.db.View(func(tx *bolt.Tx) error {
+		bucket := readBucket(ctx, tx, thumbnailBucket)
+		if bucket == nil {
+			return fmt.Errorf("Failed to find image: %q", id)
+		}
+		v := bucket.Get([]byte(id))
+		if v == nil {
+			return fmt.Errorf("Failed to find image: %q", id)
+		}
+		// Bolt values are only valid for the life of the transaction.
+		ret = append([]byte{}, v...)
+		return nil
+	})
+	return ret, err
+}
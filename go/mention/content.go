@@ -0,0 +1,86 @@
+package mention
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"github.com/microcosm-cc/bluemonday"
+	"willnorris.com/go/microformats"
+)
+
+const (
+	// MAX_CONTENT_LENGTH is the longest reply text we keep, in runes. Longer
+	// replies are truncated and marked so a "read more" link can be shown.
+	MAX_CONTENT_LENGTH = 1000
+
+	// MAX_CONTENT_HTML_LENGTH is the longest sanitized reply HTML we keep, in
+	// bytes. Longer HTML is dropped in favor of the truncated text.
+	MAX_CONTENT_HTML_LENGTH = 8 * 1024
+)
+
+// contentPolicy is the allowlist of HTML elements and attributes kept in
+// reply content.
+var contentPolicy = newContentPolicy()
+
+func newContentPolicy() *bluemonday.Policy {
+	p := bluemonday.NewPolicy()
+	p.AllowElements("p", "br", "em", "strong", "b", "i", "u", "s", "blockquote", "code", "pre", "ul", "ol", "li")
+	p.AllowAttrs("href").OnElements("a")
+	p.AllowStandardURLs()
+	p.AllowRelativeURLs(false)
+	p.RequireNoFollowOnLinks(true)
+	return p
+}
+
+// SanitizeHTML returns s with everything not in contentPolicy removed.
+// Sanitizing HTML that already was leaves it unchanged, so ContentHTML can be
+// sanitized again before it is shown, whatever stored it.
+func SanitizeHTML(s string) string {
+	return strings.TrimSpace(contentPolicy.Sanitize(s))
+}
+
+// truncate returns s shortened to at most max runes, and whether it was
+// shortened.
+func truncate(s string, max int) (string, bool) {
+	if utf8.RuneCountInString(s) <= max {
+		return s, false
+	}
+	runes := []rune(s)
+	return strings.TrimSpace(string(runes[:max])) + "…", true
+}
+
+// contentValues returns the plain text and HTML of an h-entry's content, or
+// of its summary if it has no content.
+func contentValues(it *microformats.Microformat) (string, string) {
+	for _, v := range it.Properties["content"] {
+		switch t := v.(type) {
+		case string:
+			return t, ""
+		case map[string]string:
+			return t["value"], t["html"]
+		case map[string]interface{}:
+			text, _ := t["value"].(string)
+			html, _ := t["html"].(string)
+			return text, html
+		case *microformats.Microformat:
+			return t.Value, t.HTML
+		}
+	}
+	return firstPropAsString(it, "summary"), ""
+}
+
+// findContent sets the Content, ContentHTML and Truncated fields of m from
+// the h-entry it.
+func findContent(m *Mention, it *microformats.Microformat) {
+	text, html := contentValues(it)
+	text = strings.TrimSpace(text)
+	m.Content, m.Truncated = truncate(text, MAX_CONTENT_LENGTH)
+	m.ContentHTML = ""
+	if html != "" && !m.Truncated {
+		if s := SanitizeHTML(html); len(s) <= MAX_CONTENT_HTML_LENGTH {
+			m.ContentHTML = s
+		} else {
+			m.Truncated = true
+		}
+	}
+}
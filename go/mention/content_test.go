@@ -0,0 +1,61 @@
+package mention
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"willnorris.com/go/microformats"
+)
+
+func TestSanitizeHTML(t *testing.T) {
+	assert.Equal(t, `<p>Hi <a href="https://example.com/" rel="nofollow">there</a></p>`,
+		SanitizeHTML(`<p onclick="evil()">Hi <a href="https://example.com/" style="color: red">there</a></p>`))
+	assert.Equal(t, `<p>Hi</p>`, SanitizeHTML(`<p>Hi<script>alert(1)</script></p>`))
+	assert.Equal(t, `<p>Hi</p>`, SanitizeHTML(`<p>Hi<img src="https://example.com/track.gif"></p>`))
+	assert.Equal(t, `link`, SanitizeHTML(`<a href="javascript:alert(1)">link</a>`))
+	assert.Equal(t, `link`, SanitizeHTML(`<a href="/relative">link</a>`))
+
+	// Sanitizing again changes nothing.
+	clean := SanitizeHTML(`<p onclick="evil()">Hi <a href="https://example.com/">there</a><script>x</script></p>`)
+	assert.Equal(t, clean, SanitizeHTML(clean))
+}
+
+func TestFindContent(t *testing.T) {
+	entry := func(props map[string][]interface{}) *microformats.Microformat {
+		return &microformats.Microformat{
+			Type:       []string{"h-entry"},
+			Properties: props,
+		}
+	}
+
+	m := &Mention{}
+	findContent(m, entry(map[string][]interface{}{
+		"content": {map[string]interface{}{
+			"value": "Great post!",
+			"html":  `<p>Great <em>post</em>!</p><script>x</script>`,
+		}},
+	}))
+	assert.Equal(t, "Great post!", m.Content)
+	assert.Equal(t, "<p>Great <em>post</em>!</p>", m.ContentHTML)
+	assert.False(t, m.Truncated)
+
+	m = &Mention{}
+	findContent(m, entry(map[string][]interface{}{
+		"summary": {"Just a summary"},
+	}))
+	assert.Equal(t, "Just a summary", m.Content)
+	assert.Equal(t, "", m.ContentHTML)
+
+	long := strings.Repeat("a", MAX_CONTENT_LENGTH+10)
+	m = &Mention{}
+	findContent(m, entry(map[string][]interface{}{
+		"content": {map[string]interface{}{
+			"value": long,
+			"html":  "<p>" + long + "</p>",
+		}},
+	}))
+	assert.True(t, m.Truncated)
+	assert.Equal(t, "", m.ContentHTML)
+	assert.Equal(t, strings.Repeat("a", MAX_CONTENT_LENGTH)+"…", m.Content)
+}
@@ -0,0 +1,204 @@
+package mention
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"go.skia.org/infra/go/ds"
+	"google.golang.org/api/iterator"
+)
+
+const (
+	MENTIONS         ds.Kind = "Mentions"
+	WEB_MENTION_SENT ds.Kind = "WebMentionSent"
+	THUMBNAIL        ds.Kind = "Thumbnail"
+)
+
+type Thumbnail struct {
+	PNG []byte `datastore:",noindex"`
+}
+
+// datastoreStore implements Store using Google Cloud Datastore. The default
+// namespace is the one passed to ds.Init, others are Datastore namespaces of
+// the same name.
+type datastoreStore struct{}
+
+// NewDatastoreStore returns a Store backed by Google Cloud Datastore.
+//
+// ds.Init must be called before using the returned Store.
+func NewDatastoreStore() Store {
+	return &datastoreStore{}
+}
+
+// newKey returns a key of the given kind in the namespace of ctx.
+func newKey(ctx context.Context, kind ds.Kind) *datastore.Key {
+	key := ds.NewKey(kind)
+	if ns := Namespace(ctx); ns != "" {
+		key.Namespace = ns
+	}
+	return key
+}
+
+// newQuery returns a query for the given kind in the namespace of ctx.
+func newQuery(ctx context.Context, kind ds.Kind) *datastore.Query {
+	q := ds.NewQuery(kind)
+	if ns := Namespace(ctx); ns != "" {
+		q = q.Namespace(ns)
+	}
+	return q
+}
+
+func (d *datastoreStore) Put(ctx context.Context, m *Mention) error {
+	key := newKey(ctx, MENTIONS)
+	key.Name = m.ID()
+	if _, err := ds.DS.Put(ctx, key, m); err != nil {
+		return fmt.Errorf("Failed writing %#v: %s", *m, err)
+	}
+	return nil
+}
+
+func (d *datastoreStore) Get(ctx context.Context, id string) (*Mention, error) {
+	key := newKey(ctx, MENTIONS)
+	key.Name = id
+	m := &Mention{}
+	if err := ds.DS.Get(ctx, key, m); err == datastore.ErrNoSuchEntity {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("Failed to find mention %q: %s", id, err)
+	}
+	return m, nil
+}
+
+func (d *datastoreStore) GetByTarget(ctx context.Context, target string, all bool) ([]*Mention, error) {
+	ret := []*Mention{}
+	q := newQuery(ctx, MENTIONS).
+		Filter("Target =", target)
+	if !all {
+		q = q.Filter("Verification =", VERIFY_VERIFIED).Filter("Moderation =", MODERATION_APPROVED)
+	}
+
+	it := ds.DS.Run(ctx, q)
+	for {
+		m := &Mention{}
+		_, err := it.Next(m)
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return ret, fmt.Errorf("Failed while reading: %s", err)
+		}
+		ret = append(ret, m)
+	}
+	return ret, nil
+}
+
+func (d *datastoreStore) GetTriage(ctx context.Context, limit, offset int) ([]*MentionWithKey, error) {
+	ret := []*MentionWithKey{}
+	q := newQuery(ctx, MENTIONS).Order("-TS").Limit(limit).Offset(offset)
+
+	it := ds.DS.Run(ctx, q)
+	for {
+		var m Mention
+		key, err := it.Next(&m)
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return ret, fmt.Errorf("Failed while reading: %s", err)
+		}
+		ret = append(ret, &MentionWithKey{
+			Mention: m,
+			Key:     key.Encode(),
+		})
+	}
+	return ret, nil
+}
+
+func (d *datastoreStore) GetQueued(ctx context.Context) ([]*Mention, error) {
+	ret := []*Mention{}
+	q := newQuery(ctx, MENTIONS).
+		Filter("Verification =", VERIFY_PENDING)
+
+	it := ds.DS.Run(ctx, q)
+	for {
+		m := &Mention{}
+		_, err := it.Next(m)
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return ret, fmt.Errorf("Failed while reading: %s", err)
+		}
+		ret = append(ret, m)
+	}
+	return ret, nil
+}
+
+func (d *datastoreStore) UpdateModeration(ctx context.Context, encodedKey, moderation string) error {
+	tx, err := ds.DS.NewTransaction(ctx)
+	if err != nil {
+		return fmt.Errorf("client.NewTransaction: %v", err)
+	}
+	key, err := datastore.DecodeKey(encodedKey)
+	if err != nil {
+		return fmt.Errorf("Unable to decode key: %s", err)
+	}
+	var m Mention
+	if err := tx.Get(key, &m); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("tx.GetMulti: %v", err)
+	}
+	m.Moderation = moderation
+	if _, err := tx.Put(key, &m); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("tx.Put: %v", err)
+	}
+	if _, err = tx.Commit(); err != nil {
+		return fmt.Errorf("tx.Commit: %v", err)
+	}
+	return nil
+}
+
+func (d *datastoreStore) Sent(ctx context.Context, source string) (time.Time, bool) {
+	key := newKey(ctx, WEB_MENTION_SENT)
+	key.Name = source
+
+	dst := &WebMentionSent{}
+	if err := ds.DS.Get(ctx, key, dst); err != nil {
+		return time.Time{}, false
+	}
+	return dst.TS, true
+}
+
+func (d *datastoreStore) RecordSent(ctx context.Context, source string, updated time.Time) error {
+	key := newKey(ctx, WEB_MENTION_SENT)
+	key.Name = source
+
+	src := &WebMentionSent{
+		TS: updated.UTC(),
+	}
+	_, err := ds.DS.Put(ctx, key, src)
+	return err
+}
+
+func (d *datastoreStore) PutThumbnail(ctx context.Context, id string, png []byte) error {
+	t := &Thumbnail{
+		PNG: png,
+	}
+	key := newKey(ctx, THUMBNAIL)
+	key.Name = id
+	_, err := ds.DS.Put(ctx, key, t)
+	return err
+}
+
+func (d *datastoreStore) GetThumbnail(ctx context.Context, id string) ([]byte, error) {
+	key := newKey(ctx, THUMBNAIL)
+	key.Name = id
+	var t Thumbnail
+	if err := ds.DS.Get(ctx, key, &t); err != nil {
+		return nil, fmt.Errorf("Failed to find image: %s", err)
+	}
+	return t.PNG, nil
+}
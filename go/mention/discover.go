@@ -0,0 +1,179 @@
+package mention
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strings"
+
+	"willnorris.com/go/microformats"
+	"willnorris.com/go/webmention"
+)
+
+const (
+	HTML_TYPE  = "text/html"
+	XHTML_TYPE = "application/xhtml+xml"
+	JSON_TYPE  = "application/json"
+	MF2_TYPE   = "application/mf2+json"
+	TEXT_TYPE  = "text/plain"
+	ATOM_TYPE  = "application/atom+xml"
+	RSS_TYPE   = "application/rss+xml"
+	XML_TYPE   = "application/xml"
+	TXML_TYPE  = "text/xml"
+)
+
+// linksTo returns true if the source content b, served as mediaType, links to
+// target.
+//
+// HTML is searched for links, JSON for a property value equal to target,
+// plain text for target as a substring, and Atom and RSS for an attribute or
+// element value equal to target, or a link to target in escaped HTML content.
+func linksTo(mediaType string, b []byte, source, target string) (bool, error) {
+	switch mediaType {
+	case HTML_TYPE, XHTML_TYPE:
+		return htmlLinksTo(bytes.NewReader(b), source, target)
+	case JSON_TYPE, MF2_TYPE:
+		var v interface{}
+		if err := json.Unmarshal(b, &v); err != nil {
+			return false, fmt.Errorf("Invalid JSON: %s", err)
+		}
+		return jsonContains(v, target), nil
+	case TEXT_TYPE:
+		return bytes.Contains(b, []byte(target)), nil
+	case ATOM_TYPE, RSS_TYPE, XML_TYPE, TXML_TYPE:
+		return xmlLinksTo(b, source, target)
+	}
+	return false, fmt.Errorf("Unsupported Content-Type: %q", mediaType)
+}
+
+func htmlLinksTo(r io.Reader, source, target string) (bool, error) {
+	links, err := webmention.DiscoverLinksFromReader(r, source, "")
+	if err != nil {
+		return false, err
+	}
+	return in(target, links), nil
+}
+
+// jsonContains returns true if any string value in v, at any depth, is equal
+// to target.
+func jsonContains(v interface{}, target string) bool {
+	switch t := v.(type) {
+	case string:
+		return t == target
+	case []interface{}:
+		for _, e := range t {
+			if jsonContains(e, target) {
+				return true
+			}
+		}
+	case map[string]interface{}:
+		for _, e := range t {
+			if jsonContains(e, target) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func xmlLinksTo(b []byte, source, target string) (bool, error) {
+	d := xml.NewDecoder(bytes.NewReader(b))
+	d.Strict = false
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			return false, nil
+		}
+		if err != nil {
+			return false, fmt.Errorf("Invalid XML: %s", err)
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			for _, attr := range t.Attr {
+				if strings.TrimSpace(attr.Value) == target {
+					return true, nil
+				}
+			}
+		case xml.CharData:
+			s := strings.TrimSpace(string(t))
+			if s == target {
+				return true, nil
+			}
+			// Atom and RSS content is often escaped HTML.
+			if strings.Contains(s, "<") && strings.Contains(s, target) {
+				if found, err := htmlLinksTo(strings.NewReader(s), source, target); err == nil && found {
+					return true, nil
+				}
+			}
+		}
+	}
+}
+
+// parseMF2JSON parses a microformats2 JSON document, such as is served as
+// application/mf2+json, into the items it contains.
+func parseMF2JSON(b []byte) ([]*microformats.Microformat, error) {
+	var doc struct {
+		Items []interface{} `json:"items"`
+	}
+	if err := json.Unmarshal(b, &doc); err != nil {
+		return nil, fmt.Errorf("Invalid mf2 JSON: %s", err)
+	}
+	ret := []*microformats.Microformat{}
+	for _, item := range doc.Items {
+		if mf := toMicroformat(item); mf != nil {
+			ret = append(ret, mf)
+		}
+	}
+	return ret, nil
+}
+
+// toMicroformat converts the decoded JSON of a single microformat into a
+// Microformat, including embedded microformats in its properties, so that it
+// looks the same as one parsed from HTML. Returns nil if v isn't a
+// microformat.
+func toMicroformat(v interface{}) *microformats.Microformat {
+	obj, ok := v.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	types, ok := obj["type"].([]interface{})
+	if !ok {
+		return nil
+	}
+	mf := &microformats.Microformat{
+		Properties: map[string][]interface{}{},
+	}
+	for _, t := range types {
+		if s, ok := t.(string); ok {
+			mf.Type = append(mf.Type, s)
+		}
+	}
+	mf.ID, _ = obj["id"].(string)
+	mf.Value, _ = obj["value"].(string)
+	mf.HTML, _ = obj["html"].(string)
+	if props, ok := obj["properties"].(map[string]interface{}); ok {
+		for name, values := range props {
+			arr, ok := values.([]interface{})
+			if !ok {
+				continue
+			}
+			for _, value := range arr {
+				if child := toMicroformat(value); child != nil {
+					mf.Properties[name] = append(mf.Properties[name], child)
+				} else {
+					mf.Properties[name] = append(mf.Properties[name], value)
+				}
+			}
+		}
+	}
+	if children, ok := obj["children"].([]interface{}); ok {
+		for _, c := range children {
+			if child := toMicroformat(c); child != nil {
+				mf.Children = append(mf.Children, child)
+			}
+		}
+	}
+	return mf
+}
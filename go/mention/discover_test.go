@@ -0,0 +1,72 @@
+package mention
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const target = "https://bitworking.org/news/2018/01/webmention-only"
+
+func TestLinksTo(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		mediaType string
+		body      string
+		found     bool
+	}{
+		{"html", HTML_TYPE, `<p><a href="` + target + `">post</a></p>`, true},
+		{"html missing", HTML_TYPE, `<p>` + target + `</p>`, false},
+		{"json", JSON_TYPE, `{"links": [{"href": "` + target + `"}]}`, true},
+		{"json substring", JSON_TYPE, `{"text": "see ` + target + `"}`, false},
+		{"mf2", MF2_TYPE, `{"items": [{"type": ["h-entry"], "properties": {"in-reply-to": ["` + target + `"]}}]}`, true},
+		{"text", TEXT_TYPE, `I liked ` + target + ` a lot.`, true},
+		{"text missing", TEXT_TYPE, `I liked https://bitworking.org/ a lot.`, false},
+		{"atom link", ATOM_TYPE, `<feed xmlns="http://www.w3.org/2005/Atom"><entry><link href="` + target + `"/></entry></feed>`, true},
+		{"atom content", ATOM_TYPE, `<feed xmlns="http://www.w3.org/2005/Atom"><entry><content type="html">&lt;a href="` + target + `"&gt;post&lt;/a&gt;</content></entry></feed>`, true},
+		{"rss", RSS_TYPE, `<rss><channel><item><link>` + target + `</link></item></channel></rss>`, true},
+		{"rss missing", RSS_TYPE, `<rss><channel><item><link>https://example.com/</link></item></channel></rss>`, false},
+	} {
+		found, err := linksTo(tc.mediaType, []byte(tc.body), "https://example.com/source", target)
+		assert.NoError(t, err, tc.name)
+		assert.Equal(t, tc.found, found, tc.name)
+	}
+
+	_, err := linksTo(JSON_TYPE, []byte(`{`), "https://example.com/source", target)
+	assert.Error(t, err)
+	_, err = linksTo("image/png", []byte(``), "https://example.com/source", target)
+	assert.Error(t, err)
+}
+
+func TestSlowValidateMF2JSON(t *testing.T) {
+	Init(NewMemoryStore())
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", MF2_TYPE)
+		_, _ = w.Write([]byte(`{
+  "items": [{
+    "type": ["h-entry"],
+    "properties": {
+      "name": ["A reply"],
+      "in-reply-to": ["` + target + `"],
+      "published": ["2018-01-13T00:00:00-05:00"],
+      "author": [{
+        "type": ["h-card"],
+        "value": "Alice",
+        "properties": {"name": ["Alice"], "url": ["https://alice.example.com/"]}
+      }]
+    }
+  }]
+}`))
+	}))
+	defer ts.Close()
+
+	m := New(ts.URL, target)
+	assert.NoError(t, m.SlowValidate(context.Background(), ts.Client()))
+	assert.Equal(t, "A reply", m.Title)
+	assert.Equal(t, "Alice", m.Author)
+	assert.Equal(t, "https://alice.example.com/", m.AuthorURL)
+	assert.NotEqual(t, "", m.ContentHash)
+}
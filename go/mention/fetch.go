@@ -0,0 +1,197 @@
+package mention
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+const (
+	// USER_AGENT identifies us to the sites we fetch sources and photos from.
+	USER_AGENT = "userve-webmention/1.0 (+https://github.com/jcgregorio/userve)"
+
+	// MAX_REDIRECTS is the number of redirects followed when fetching.
+	MAX_REDIRECTS = 5
+
+	// MAX_SOURCE_SIZE is the largest source document we will read.
+	MAX_SOURCE_SIZE = 2 * 1024 * 1024
+
+	// MAX_PHOTO_SIZE is the largest author photo we will read.
+	MAX_PHOTO_SIZE = 1024 * 1024
+
+	// MAX_PHOTO_PIXELS is the largest author photo we will decode, in pixels.
+	MAX_PHOTO_PIXELS = 4096 * 4096
+
+	// FETCH_TIMEOUT is the time allowed for a single fetch, including
+	// redirects and reading the body.
+	FETCH_TIMEOUT = 20 * time.Second
+)
+
+var (
+	// sourceContentTypes are the media types accepted for a source, see
+	// linksTo.
+	sourceContentTypes = []string{HTML_TYPE, XHTML_TYPE, JSON_TYPE, MF2_TYPE, TEXT_TYPE, ATOM_TYPE, RSS_TYPE, XML_TYPE, TXML_TYPE}
+
+	// photoContentTypes are the media types accepted for an author photo.
+	photoContentTypes = []string{"image/png", "image/jpeg", "image/gif"}
+
+	errTooLarge = errors.New("Response body too large.")
+)
+
+// disallowedNets are address ranges, beyond the ones net.IP knows about, that
+// we never fetch from.
+var disallowedNets = mustParseCIDRs(
+	"0.0.0.0/8",     // "This" network.
+	"100.64.0.0/10", // Carrier-grade NAT.
+	"192.0.0.0/24",  // IETF protocol assignments.
+	"198.18.0.0/15", // Benchmarking.
+	"64:ff9b::/96",  // NAT64, which can map to private IPv4 addresses.
+)
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	ret := []*net.IPNet{}
+	for _, c := range cidrs {
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			panic(err)
+		}
+		ret = append(ret, n)
+	}
+	return ret
+}
+
+// isDisallowedIP returns true if ip is an address we should never connect to
+// on behalf of a webmention sender, such as loopback, private, or link-local.
+func isDisallowedIP(ip net.IP) bool {
+	if ip4 := ip.To4(); ip4 != nil {
+		ip = ip4
+	}
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() {
+		return true
+	}
+	for _, n := range disallowedNets {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// safeControl is a net.Dialer Control func that refuses to connect to
+// disallowed addresses. It runs after DNS resolution, so a hostname that
+// resolves to a private address is refused too.
+func safeControl(network, address string, c syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return fmt.Errorf("Invalid address %q: %s", address, err)
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return fmt.Errorf("Invalid IP address: %q", host)
+	}
+	if isDisallowedIP(ip) {
+		return fmt.Errorf("Refusing to connect to disallowed address: %s", ip)
+	}
+	return nil
+}
+
+// userAgentTransport sets the User-Agent on every request.
+type userAgentTransport struct {
+	http.RoundTripper
+}
+
+func (u userAgentTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r = r.Clone(r.Context())
+	r.Header.Set("User-Agent", USER_AGENT)
+	return u.RoundTripper.RoundTrip(r)
+}
+
+// NewSafeClient returns an http.Client suitable for fetching URLs supplied by
+// webmention senders. It refuses to connect to loopback, private, and
+// link-local addresses, only follows MAX_REDIRECTS redirects to http or https
+// URLs, ignores any proxy settings, and sends USER_AGENT.
+func NewSafeClient() *http.Client {
+	dialer := &net.Dialer{
+		Timeout:   5 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control:   safeControl,
+	}
+	return &http.Client{
+		Timeout: FETCH_TIMEOUT,
+		Transport: userAgentTransport{&http.Transport{
+			Proxy:                 nil,
+			DialContext:           dialer.DialContext,
+			TLSHandshakeTimeout:   5 * time.Second,
+			ResponseHeaderTimeout: 10 * time.Second,
+			MaxIdleConns:          10,
+			IdleConnTimeout:       90 * time.Second,
+		}},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > MAX_REDIRECTS {
+				return fmt.Errorf("Stopped after %d redirects.", MAX_REDIRECTS)
+			}
+			if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return fmt.Errorf("Refusing to redirect to scheme: %q", req.URL.Scheme)
+			}
+			return nil
+		},
+	}
+}
+
+// checkContentType returns the media type of resp, or an error if it isn't
+// one of allowed.
+func checkContentType(resp *http.Response, allowed []string) (string, error) {
+	mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type"))
+	if err != nil {
+		return "", fmt.Errorf("Invalid Content-Type: %s", err)
+	}
+	for _, a := range allowed {
+		if mediaType == a {
+			return mediaType, nil
+		}
+	}
+	return "", fmt.Errorf("Unsupported Content-Type: %q", mediaType)
+}
+
+// readLimited reads all of r, returning errTooLarge if there is more than max
+// bytes.
+func readLimited(r io.Reader, max int64) ([]byte, error) {
+	b, err := ioutil.ReadAll(io.LimitReader(r, max+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(b)) > max {
+		return nil, errTooLarge
+	}
+	return b, nil
+}
+
+// limitedReadCloser is an io.ReadCloser that returns errTooLarge once more
+// than remaining bytes have been read.
+type limitedReadCloser struct {
+	io.ReadCloser
+	remaining int64
+}
+
+func (l *limitedReadCloser) Read(p []byte) (int, error) {
+	if l.remaining < 0 {
+		return 0, errTooLarge
+	}
+	if int64(len(p)) > l.remaining+1 {
+		p = p[:l.remaining+1]
+	}
+	n, err := l.ReadCloser.Read(p)
+	l.remaining -= int64(n)
+	if l.remaining < 0 {
+		return n, errTooLarge
+	}
+	return n, err
+}
@@ -0,0 +1,112 @@
+package mention
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIsDisallowedIP(t *testing.T) {
+	for _, tc := range []struct {
+		ip         string
+		disallowed bool
+	}{
+		{"127.0.0.1", true},
+		{"10.1.2.3", true},
+		{"172.16.0.1", true},
+		{"192.168.1.1", true},
+		{"169.254.169.254", true},
+		{"100.64.0.1", true},
+		{"0.0.0.0", true},
+		{"::1", true},
+		{"fe80::1", true},
+		{"fd00::1", true},
+		{"::ffff:127.0.0.1", true},
+		{"::ffff:10.0.0.1", true},
+		{"8.8.8.8", false},
+		{"2001:4860:4860::8888", false},
+	} {
+		assert.Equal(t, tc.disallowed, isDisallowedIP(net.ParseIP(tc.ip)), tc.ip)
+	}
+}
+
+func TestSafeClientRefusesLoopback(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("secret"))
+	}))
+	defer ts.Close()
+
+	_, err := NewSafeClient().Get(ts.URL)
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "disallowed address")
+}
+
+func TestSafeClientSetsUserAgent(t *testing.T) {
+	ua := ""
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ua = r.UserAgent()
+	}))
+	defer ts.Close()
+
+	// Swap in a transport that allows loopback for the test.
+	c := NewSafeClient()
+	c.Transport = userAgentTransport{http.DefaultTransport}
+	_, err := c.Get(ts.URL)
+	assert.NoError(t, err)
+	assert.Equal(t, USER_AGENT, ua)
+}
+
+func TestReadLimited(t *testing.T) {
+	b, err := readLimited(bytes.NewReader([]byte("12345")), 5)
+	assert.NoError(t, err)
+	assert.Equal(t, "12345", string(b))
+
+	_, err = readLimited(bytes.NewReader([]byte("123456")), 5)
+	assert.Equal(t, errTooLarge, err)
+
+	r := &limitedReadCloser{
+		ReadCloser: ioutil.NopCloser(bytes.NewReader([]byte("123456"))),
+		remaining:  5,
+	}
+	_, err = ioutil.ReadAll(r)
+	assert.Equal(t, errTooLarge, err)
+}
+
+func TestSlowValidateRejectsContentType(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		_, _ = w.Write([]byte(`<a href="https://bitworking.org/bar">Link</a>`))
+	}))
+	defer ts.Close()
+
+	m := New(ts.URL, "https://bitworking.org/bar")
+	err := m.SlowValidate(context.Background(), ts.Client())
+	assert.Error(t, err)
+	assert.False(t, isTransient(err))
+	assert.Contains(t, err.Error(), "Unsupported Content-Type")
+}
+
+func TestMakeUrlToImageReader(t *testing.T) {
+	contentType := "image/png"
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		_, _ = w.Write(make([]byte, MAX_PHOTO_SIZE+1))
+	}))
+	defer ts.Close()
+
+	u2r := MakeUrlToImageReader(ts.Client())
+	r, err := u2r(ts.URL)
+	assert.NoError(t, err)
+	_, err = ioutil.ReadAll(r)
+	assert.Equal(t, errTooLarge, err)
+
+	contentType = "text/html"
+	_, err = u2r(ts.URL)
+	assert.Error(t, err)
+}
@@ -0,0 +1,148 @@
+package mention
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// memoryNamespace is everything stored in a single namespace.
+type memoryNamespace struct {
+	mentions   map[string]Mention
+	sent       map[string]time.Time
+	thumbnails map[string][]byte
+}
+
+// memoryStore implements Store entirely in memory, for tests and for running
+// locally without any external services.
+type memoryStore struct {
+	mutex      sync.Mutex
+	namespaces map[string]*memoryNamespace
+}
+
+// NewMemoryStore returns a Store that keeps everything in memory.
+func NewMemoryStore() Store {
+	return &memoryStore{
+		namespaces: map[string]*memoryNamespace{},
+	}
+}
+
+// ns returns the memoryNamespace for ctx, creating it if needed. The mutex
+// must be held.
+func (s *memoryStore) ns(ctx context.Context) *memoryNamespace {
+	name := Namespace(ctx)
+	ns, ok := s.namespaces[name]
+	if !ok {
+		ns = &memoryNamespace{
+			mentions:   map[string]Mention{},
+			sent:       map[string]time.Time{},
+			thumbnails: map[string][]byte{},
+		}
+		s.namespaces[name] = ns
+	}
+	return ns
+}
+
+func (s *memoryStore) Put(ctx context.Context, m *Mention) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.ns(ctx).mentions[m.ID()] = *m
+	return nil
+}
+
+func (s *memoryStore) Get(ctx context.Context, id string) (*Mention, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	m, ok := s.ns(ctx).mentions[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &m, nil
+}
+
+// filter returns copies of all the stored Mentions with their keys for which
+// f returns true, ordered by most recent TS first.
+func (s *memoryStore) filter(ctx context.Context, f func(m *Mention) bool) []*MentionWithKey {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	ret := []*MentionWithKey{}
+	for k, m := range s.ns(ctx).mentions {
+		if f(&m) {
+			ret = append(ret, &MentionWithKey{
+				Mention: m,
+				Key:     k,
+			})
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].TS.After(ret[j].TS) })
+	return ret
+}
+
+func (s *memoryStore) GetByTarget(ctx context.Context, target string, all bool) ([]*Mention, error) {
+	return withoutKeys(s.filter(ctx, func(m *Mention) bool {
+		return m.Target == target && (all || (m.Verification == VERIFY_VERIFIED && m.Moderation == MODERATION_APPROVED))
+	})), nil
+}
+
+func (s *memoryStore) GetTriage(ctx context.Context, limit, offset int) ([]*MentionWithKey, error) {
+	mk := s.filter(ctx, func(m *Mention) bool { return true })
+	if offset >= len(mk) {
+		return []*MentionWithKey{}, nil
+	}
+	mk = mk[offset:]
+	if limit < len(mk) {
+		mk = mk[:limit]
+	}
+	return mk, nil
+}
+
+func (s *memoryStore) GetQueued(ctx context.Context) ([]*Mention, error) {
+	return withoutKeys(s.filter(ctx, func(m *Mention) bool {
+		return m.Verification == VERIFY_PENDING
+	})), nil
+}
+
+func (s *memoryStore) UpdateModeration(ctx context.Context, key, moderation string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	m, ok := s.ns(ctx).mentions[key]
+	if !ok {
+		return fmt.Errorf("No such mention: %q", key)
+	}
+	m.Moderation = moderation
+	s.ns(ctx).mentions[key] = m
+	return nil
+}
+
+func (s *memoryStore) Sent(ctx context.Context, source string) (time.Time, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	ts, ok := s.ns(ctx).sent[source]
+	return ts, ok
+}
+
+func (s *memoryStore) RecordSent(ctx context.Context, source string, updated time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.ns(ctx).sent[source] = updated.UTC()
+	return nil
+}
+
+func (s *memoryStore) PutThumbnail(ctx context.Context, id string, png []byte) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.ns(ctx).thumbnails[id] = append([]byte{}, png...)
+	return nil
+}
+
+func (s *memoryStore) GetThumbnail(ctx context.Context, id string) ([]byte, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	b, ok := s.ns(ctx).thumbnails[id]
+	if !ok {
+		return nil, fmt.Errorf("Failed to find image: %q", id)
+	}
+	return append([]byte{}, b...), nil
+}
@@ -5,6 +5,7 @@ import (
 	"context"
 	"crypto/md5"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"image"
 	_ "image/gif"
@@ -19,10 +20,7 @@ import (
 	"strings"
 	"time"
 
-	"cloud.google.com/go/datastore"
-	"go.skia.org/infra/go/ds"
 	"go.skia.org/infra/go/util"
-	"google.golang.org/api/iterator"
 	"willnorris.com/go/microformats"
 	"willnorris.com/go/webmention"
 
@@ -31,42 +29,25 @@ import (
 	"github.com/skia-dev/glog"
 )
 
-const (
-	MENTIONS         ds.Kind = "Mentions"
-	WEB_MENTION_SENT ds.Kind = "WebMentionSent"
-	THUMBNAIL        ds.Kind = "Thumbnail"
-)
-
 type WebMentionSent struct {
 	TS time.Time
 }
 
-func sent(source string) (time.Time, bool) {
-	key := ds.NewKey(WEB_MENTION_SENT)
-	key.Name = source
-
-	dst := &WebMentionSent{}
-	if err := ds.DS.Get(context.Background(), key, dst); err != nil {
+func sent(ctx context.Context, source string) (time.Time, bool) {
+	ts, ok := store.Sent(ctx, source)
+	if !ok {
 		glog.Warningf("Failed to find source: %q", source)
-		return time.Time{}, false
 	} else {
 		glog.Warningf("Found source: %q", source)
-		return dst.TS, true
 	}
+	return ts, ok
 }
 
-func recordSent(source string, updated time.Time) error {
-	key := ds.NewKey(WEB_MENTION_SENT)
-	key.Name = source
-
-	src := &WebMentionSent{
-		TS: updated.UTC(),
-	}
-	_, err := ds.DS.Put(context.Background(), key, src)
-	return err
+func recordSent(ctx context.Context, source string, updated time.Time) error {
+	return store.RecordSent(ctx, source, updated)
 }
 
-func ProcessAtomFeed(c *http.Client, filename string) error {
+func ProcessAtomFeed(ctx context.Context, c *http.Client, filename string) error {
 	glog.Info("Processing Atom Feed")
 	f, err := os.Open(filename)
 	if err != nil {
@@ -79,7 +60,7 @@ func ProcessAtomFeed(c *http.Client, filename string) error {
 	}
 	wmc := webmention.New(c)
 	for source, ms := range mentionSources {
-		ts, ok := sent(source)
+		ts, ok := sent(ctx, source)
 		glog.Warningf("Updated: %v  ts: %v ok: %v after: %v", ms.Updated.Unix(), ts.Unix(), ok, ms.Updated.After(ts.Add(time.Second)))
 		if ok && ts.Before(ms.Updated.Add(time.Second)) {
 			glog.Infof("Skipping since already sent: %s", source)
@@ -103,7 +84,7 @@ func ProcessAtomFeed(c *http.Client, filename string) error {
 				glog.Infof("Sent webmention from %s to %s", source, target)
 			}
 		}
-		if err := recordSent(source, ms.Updated); err != nil {
+		if err := recordSent(ctx, source, ms.Updated); err != nil {
 			glog.Errorf("Failed recording Sent state: %s", err)
 		}
 	}
@@ -134,7 +115,7 @@ func ParseAtomFeed(r io.Reader) (map[string]*MentionSource, error) {
 		}
 		updated, err := time.Parse(time.RFC3339, entry.Updated)
 		if err != nil {
-			fmt.Errorf("Failed to parse entry timestamp: %s", err)
+			glog.Errorf("Failed to parse entry timestamp: %s", err)
 		}
 		ret[entry.Link.HREF] = &MentionSource{
 			Targets: links,
@@ -144,6 +125,22 @@ func ParseAtomFeed(r io.Reader) (map[string]*MentionSource, error) {
 	return ret, nil
 }
 
+// Verification outcomes, for Mention.Verification.
+const (
+	VERIFY_PENDING  = "pending"
+	VERIFY_VERIFIED = "verified"
+	VERIFY_FAILED   = "failed"
+	VERIFY_DELETED  = "deleted"
+)
+
+// Moderation outcomes, for Mention.Moderation.
+const (
+	MODERATION_PENDING  = "pending"
+	MODERATION_APPROVED = "approved"
+	MODERATION_REJECTED = "rejected"
+)
+
+// Legacy values of Mention.State, see Migrate.
 const (
 	GOOD_STATE      = "good"
 	UNTRIAGED_STATE = "untriaged"
@@ -153,31 +150,113 @@ const (
 type Mention struct {
 	Source string
 	Target string
-	State  string
 	TS     time.Time
 
+	// Verification is the outcome of SlowValidate, one of the VERIFY_*
+	// constants.
+	Verification string
+
+	// Moderation is the triage decision, one of the MODERATION_* constants.
+	Moderation string
+
+	// State is only set on Mentions stored before Verification and
+	// Moderation existed, see Migrate.
+	State string `datastore:",noindex"`
+
 	// Metadata found when validating. We might display this.
 	Title     string    `datastore:",noindex"`
 	Author    string    `datastore:",noindex"`
 	AuthorURL string    `datastore:",noindex"`
 	Published time.Time `datastore:",noindex"`
 	Thumbnail string    `datastore:",noindex"`
+
+	// Type is the kind of Mention, one of the *_TYPE constants, as found in
+	// the source h-entry.
+	Type string `datastore:",noindex"`
+
+	// InReplyTo are the URLs the source h-entry is in reply to, used for
+	// threading replies.
+	InReplyTo []string `datastore:",noindex"`
+
+	// Content is the plain text of the source h-entry content or summary,
+	// and ContentHTML is the sanitized HTML of the content, if any.
+	Content     string `datastore:",noindex"`
+	ContentHTML string `datastore:",noindex"`
+
+	// Truncated is true if the content was too long to keep in full.
+	Truncated bool `datastore:",noindex"`
+
+	// Error is the reason SlowValidate failed, if it did.
+	Error string `datastore:",noindex"`
+
+	// ContentHash is the md5 hash of the source content when last verified
+	// successfully.
+	ContentHash string `datastore:",noindex"`
+
+	// Attempts is the number of times verification has failed with a
+	// transient error.
+	Attempts int `datastore:",noindex"`
+
+	// NextAttempt is the earliest time to retry verification.
+	NextAttempt time.Time `datastore:",noindex"`
+}
+
+const (
+	QUEUED_STATUS   = "queued"
+	VERIFIED_STATUS = "verified"
+	REJECTED_STATUS = "rejected"
+	APPROVED_STATUS = "approved"
+	DELETED_STATUS  = "deleted"
+)
+
+// Status returns the status of the Mention as reported to the sender, one of
+// the *_STATUS constants.
+func (m *Mention) Status() string {
+	switch m.Verification {
+	case VERIFY_VERIFIED:
+		switch m.Moderation {
+		case MODERATION_APPROVED:
+			return APPROVED_STATUS
+		case MODERATION_REJECTED:
+			return REJECTED_STATUS
+		default:
+			return VERIFIED_STATUS
+		}
+	case VERIFY_FAILED:
+		return REJECTED_STATUS
+	case VERIFY_DELETED:
+		return DELETED_STATUS
+	default:
+		return QUEUED_STATUS
+	}
+}
+
+// Dead returns true if verification was abandoned after too many transient
+// failures.
+func (m *Mention) Dead() bool {
+	return m.Verification == VERIFY_FAILED && m.Attempts >= MAX_ATTEMPTS
 }
 
 func New(source, target string) *Mention {
 	return &Mention{
-		Source: source,
-		Target: target,
-		State:  UNTRIAGED_STATE,
-		TS:     time.Now(),
+		Source:       source,
+		Target:       target,
+		TS:           time.Now(),
+		Verification: VERIFY_PENDING,
+		Moderation:   MODERATION_PENDING,
 	}
 }
 
-func (m *Mention) key() string {
+// ID returns the unique id of the Mention, derived from the Source and Target.
+func (m *Mention) ID() string {
 	return fmt.Sprintf("%x", md5.Sum([]byte(m.Source+m.Target)))
 }
 
-func (m *Mention) FastValidate() error {
+// FastValidate checks the Mention without fetching anything, including that
+// the Target is an http or https URL on one of targetHosts. Either scheme is
+// accepted since sites can be configured with either, and hosts are compared
+// ignoring case.
+func (m *Mention) FastValidate(targetHosts []string) error {
 	if m.Source == "" {
 		return fmt.Errorf("Source is empty.")
 	}
@@ -191,45 +270,82 @@ func (m *Mention) FastValidate() error {
 	if err != nil {
 		return fmt.Errorf("Target is not a valid URL: %s", err)
 	}
-	if target.Hostname() != "bitworking.org" {
+	if !inFold(target.Hostname(), targetHosts) {
 		return fmt.Errorf("Wrong target domain.")
 	}
-	if target.Scheme != "https" {
+	if target.Scheme != "https" && target.Scheme != "http" {
 		return fmt.Errorf("Wrong scheme for target.")
 	}
 	return nil
 }
 
-func (m *Mention) SlowValidate(c *http.Client) error {
+var (
+	// errGone is returned from SlowValidate if the source responds with 410 Gone.
+	errGone = errors.New("Source is gone.")
+
+	// errNoLink is returned from SlowValidate if the source doesn't link to the target.
+	errNoLink = errors.New("Failed to find target link in source.")
+)
+
+func (m *Mention) SlowValidate(ctx context.Context, c *http.Client) error {
 	glog.Infof("SlowValidate: %q", m.Source)
 	resp, err := c.Get(m.Source)
 	if err != nil {
-		return fmt.Errorf("Failed to retrieve source: %s", err)
+		return transientError{fmt.Errorf("Failed to retrieve source: %s", err)}
 	}
 	defer util.Close(resp.Body)
-	b, err := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode == http.StatusGone {
+		return errGone
+	}
+	if resp.StatusCode >= 500 || resp.StatusCode == http.StatusTooManyRequests {
+		return transientError{fmt.Errorf("Not a 200 response: %d", resp.StatusCode)}
+	}
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("Not a 200 response: %d", resp.StatusCode)
+	}
+	mediaType, err := checkContentType(resp, sourceContentTypes)
 	if err != nil {
-		return fmt.Errorf("Failed to read content: %s", err)
+		return err
 	}
-	reader := bytes.NewReader(b)
-	links, err := webmention.DiscoverLinksFromReader(reader, m.Source, "")
+	b, err := readLimited(resp.Body, MAX_SOURCE_SIZE)
+	if err == errTooLarge {
+		return err
+	} else if err != nil {
+		return transientError{fmt.Errorf("Failed to read content: %s", err)}
+	}
+	found, err := linksTo(mediaType, b, m.Source, m.Target)
 	if err != nil {
 		return fmt.Errorf("Failed to discover links: %s", err)
 	}
-	for _, link := range links {
-		if link == m.Target {
-			_, err := reader.Seek(0, io.SeekStart)
-			if err != nil {
-				return nil
-			}
-			m.ParseMicroformats(reader, MakeUrlToImageReader(c))
-			return nil
+	if !found {
+		return errNoLink
+	}
+	m.ContentHash = fmt.Sprintf("%x", md5.Sum(b))
+	m.Type = ""
+	m.InReplyTo = nil
+	m.Content = ""
+	m.ContentHTML = ""
+	m.Truncated = false
+	m.Author = ""
+	m.AuthorURL = ""
+	m.Thumbnail = ""
+	switch mediaType {
+	case HTML_TYPE, XHTML_TYPE:
+		m.ParseMicroformats(ctx, bytes.NewReader(b), MakeUrlToImageReader(c), MakeUrlToPageReader(c))
+	case MF2_TYPE:
+		if items, err := parseMF2JSON(b); err == nil {
+			data := &microformats.Data{Items: items}
+			findHEntry(ctx, MakeUrlToImageReader(c), m, items)
+			findAuthorship(ctx, MakeUrlToImageReader(c), MakeUrlToPageReader(c), m, data)
 		}
 	}
-	return fmt.Errorf("Failed to find target link in source.")
+	if m.Type == "" {
+		m.Type = MENTION_TYPE
+	}
+	return nil
 }
 
-func (m *Mention) ParseMicroformats(r io.Reader, urlToImageReader UrlToImageReader) {
+func (m *Mention) ParseMicroformats(ctx context.Context, r io.Reader, urlToImageReader UrlToImageReader, urlToPageReader UrlToPageReader) {
 	u, err := url.Parse(m.Source)
 	if err != nil {
 		return
@@ -239,82 +355,33 @@ func (m *Mention) ParseMicroformats(r io.Reader, urlToImageReader UrlToImageRead
 	if err == nil {
 		glog.Infof("JSON: %q\n", string(b))
 	}
-	findHEntry(context.Background(), urlToImageReader, m, data.Items)
-	// Find an h-entry with the m.Target.
+	findHEntry(ctx, urlToImageReader, m, data.Items)
+	findAuthorship(ctx, urlToImageReader, urlToPageReader, m, data)
 }
 
-func VerifyQueuedMentions(c *http.Client) {
-	queued := GetQueued(context.Background())
-	glog.Infof("About to slow verify %d queud mentions.", len(queued))
-	for _, m := range queued {
-		glog.Infof("Verifying queued webmention from %q", m.Source)
-		if m.SlowValidate(c) == nil {
-			m.State = GOOD_STATE
-		} else {
-			m.State = SPAM_STATE
-			glog.Warningf("Failed to validate webmention: %#v", *m)
-		}
-		if err := Put(context.Background(), m); err != nil {
-			glog.Errorf("Failed to save validated message: %s", err)
-		}
+func GetAll(ctx context.Context, target string) []*Mention {
+	ret, err := store.GetByTarget(ctx, target, true)
+	if err != nil {
+		glog.Errorf("Failed to get mentions: %s", err)
 	}
+	return ret
 }
 
-func get(ctx context.Context, target string, all bool) []*Mention {
-	ret := []*Mention{}
-	q := ds.NewQuery(MENTIONS).
-		Filter("Target =", target)
-	if !all {
-		q = q.Filter("State =", GOOD_STATE)
-	}
-
-	it := ds.DS.Run(ctx, q)
-	for {
-		m := &Mention{}
-		_, err := it.Next(m)
-		if err == iterator.Done {
-			break
-		}
-		if err != nil {
-			glog.Errorf("Failed while reading: %s", err)
-			break
-		}
-		ret = append(ret, m)
+func GetGood(ctx context.Context, target string) []*Mention {
+	ret, err := store.GetByTarget(ctx, target, false)
+	if err != nil {
+		glog.Errorf("Failed to get mentions: %s", err)
 	}
 	return ret
 }
 
-func GetAll(ctx context.Context, target string) []*Mention {
-	return get(ctx, target, true)
+// Get returns the Mention with the given id, as returned from Mention.ID().
+func Get(ctx context.Context, id string) (*Mention, error) {
+	return store.Get(ctx, id)
 }
 
-func GetGood(ctx context.Context, target string) []*Mention {
-	return get(ctx, target, false)
-}
-
-func UpdateState(ctx context.Context, encodedKey, state string) error {
-	tx, err := ds.DS.NewTransaction(ctx)
-	if err != nil {
-		return fmt.Errorf("client.NewTransaction: %v", err)
-	}
-	key, err := datastore.DecodeKey(encodedKey)
-	if err != nil {
-		return fmt.Errorf("Unable to decode key: %s", err)
-	}
-	var m Mention
-	if err := tx.Get(key, &m); err != nil {
-		tx.Rollback()
-		return fmt.Errorf("tx.GetMulti: %v", err)
-	}
-	m.State = state
-	if _, err := tx.Put(key, &m); err != nil {
-		tx.Rollback()
-		return fmt.Errorf("tx.Put: %v", err)
-	}
-	if _, err = tx.Commit(); err != nil {
-		return fmt.Errorf("tx.Commit: %v", err)
-	}
-	return nil
+func UpdateModeration(ctx context.Context, key, moderation string) error {
+	return store.UpdateModeration(ctx, key, moderation)
 }
 
 type MentionWithKey struct {
@@ -323,57 +390,43 @@ type MentionWithKey struct {
 }
 
 func GetTriage(ctx context.Context, limit, offset int) []*MentionWithKey {
-	ret := []*MentionWithKey{}
-	q := ds.NewQuery(MENTIONS).Order("-TS").Limit(limit).Offset(offset)
-
-	it := ds.DS.Run(ctx, q)
-	for {
-		var m Mention
-		key, err := it.Next(&m)
-		if err == iterator.Done {
-			break
-		}
-		if err != nil {
-			glog.Errorf("Failed while reading: %s", err)
-			break
-		}
-		ret = append(ret, &MentionWithKey{
-			Mention: m,
-			Key:     key.Encode(),
-		})
+	ret, err := store.GetTriage(ctx, limit, offset)
+	if err != nil {
+		glog.Errorf("Failed to get mentions for triage: %s", err)
 	}
 	return ret
 }
 
 func GetQueued(ctx context.Context) []*Mention {
-	ret := []*Mention{}
-	q := ds.NewQuery(MENTIONS).
-		Filter("State =", UNTRIAGED_STATE)
-
-	it := ds.DS.Run(ctx, q)
-	for {
-		m := &Mention{}
-		_, err := it.Next(m)
-		if err == iterator.Done {
-			break
-		}
-		if err != nil {
-			glog.Errorf("Failed while reading: %s", err)
-			break
-		}
-		ret = append(ret, m)
+	ret, err := store.GetQueued(ctx)
+	if err != nil {
+		glog.Errorf("Failed to get queued mentions: %s", err)
 	}
 	return ret
 }
 
 func Put(ctx context.Context, mention *Mention) error {
-	// TODO See if there's an existing mention already, so we don't overwrite its status?
-	key := ds.NewKey(MENTIONS)
-	key.Name = mention.key()
-	if _, err := ds.DS.Put(ctx, key, mention); err != nil {
-		return fmt.Errorf("Failed writing %#v: %s", *mention, err)
+	return store.Put(ctx, mention)
+}
+
+// Enqueue stores a newly received Mention so that it gets verified.
+//
+// If the Mention is already known then this is an update, and the existing
+// Mention is queued again for verification, keeping its Moderation which is
+// only reset if the source content has changed.
+func Enqueue(ctx context.Context, m *Mention) error {
+	old, err := store.Get(ctx, m.ID())
+	if err == ErrNotFound {
+		return store.Put(ctx, m)
+	} else if err != nil {
+		return err
 	}
-	return nil
+	old.Verification = VERIFY_PENDING
+	old.TS = m.TS
+	old.Attempts = 0
+	old.NextAttempt = time.Time{}
+	*m = *old
+	return store.Put(ctx, m)
 }
 
 type UrlToImageReader func(url string) (io.ReadCloser, error)
@@ -387,6 +440,16 @@ func in(s string, arr []string) bool {
 	return false
 }
 
+// inFold is in, ignoring case.
+func inFold(s string, arr []string) bool {
+	for _, a := range arr {
+		if strings.EqualFold(a, s) {
+			return true
+		}
+	}
+	return false
+}
+
 func firstPropAsString(uf *microformats.Microformat, key string) string {
 	for _, sint := range uf.Properties[key] {
 		if s, ok := sint.(string); ok {
@@ -396,9 +459,32 @@ func firstPropAsString(uf *microformats.Microformat, key string) string {
 	return ""
 }
 
+// firstPropAsURL is like firstPropAsString, but also handles u-* properties
+// parsed with an alt, such as u-photo.
+func firstPropAsURL(uf *microformats.Microformat, key string) string {
+	for _, v := range uf.Properties[key] {
+		switch t := v.(type) {
+		case string:
+			return t
+		case map[string]string:
+			return t["value"]
+		case map[string]interface{}:
+			if s, ok := t["value"].(string); ok {
+				return s
+			}
+		}
+	}
+	return ""
+}
+
 func findHEntry(ctx context.Context, u2r UrlToImageReader, m *Mention, items []*microformats.Microformat) {
 	for _, it := range items {
 		if in("h-entry", it.Type) {
+			if t := mentionType(it, m.Target); t != MENTION_TYPE || m.Type == "" {
+				m.Type = t
+				m.InReplyTo = propURLs(it, "in-reply-to")
+				findContent(m, it)
+			}
 			m.Title = firstPropAsString(it, "name")
 			if strings.HasPrefix(m.Title, "tag:twitter") {
 				m.Title = "Twitter"
@@ -424,10 +510,6 @@ func findHEntry(ctx context.Context, u2r UrlToImageReader, m *Mention, items []*
 	}
 }
 
-type Thumbnail struct {
-	PNG []byte `datastore:",noindex"`
-}
-
 func MakeUrlToImageReader(c *http.Client) UrlToImageReader {
 	return func(u string) (io.ReadCloser, error) {
 		resp, err := c.Get(u)
@@ -435,17 +517,35 @@ func MakeUrlToImageReader(c *http.Client) UrlToImageReader {
 			return nil, fmt.Errorf("Error retrieving thumbnail: %s", err)
 		}
 		if resp.StatusCode != 200 {
+			util.Close(resp.Body)
 			return nil, fmt.Errorf("Not a 200 response: %d", resp.StatusCode)
 		}
-		return resp.Body, nil
+		if _, err := checkContentType(resp, photoContentTypes); err != nil {
+			util.Close(resp.Body)
+			return nil, err
+		}
+		return &limitedReadCloser{
+			ReadCloser: resp.Body,
+			remaining:  MAX_PHOTO_SIZE,
+		}, nil
 	}
 }
 
 func findAuthor(ctx context.Context, u2r UrlToImageReader, m *Mention, it *microformats.Microformat) {
 	glog.Info("Found author in microformat.")
-	m.Author = it.Value
-	m.AuthorURL = firstPropAsString(it, "url")
-	u := firstPropAsString(it, "photo")
+	name := it.Value
+	if name == "" {
+		name = firstPropAsString(it, "name")
+	}
+	setAuthor(ctx, u2r, m, name, firstPropAsString(it, "url"), firstPropAsURL(it, "photo"))
+}
+
+// setAuthor records the author of the Mention, including a thumbnail of
+// their photo if photoURL is given.
+func setAuthor(ctx context.Context, u2r UrlToImageReader, m *Mention, name, authorURL, photoURL string) {
+	m.Author = name
+	m.AuthorURL = authorURL
+	u := photoURL
 	if u == "" {
 		glog.Warning("No photo URL found.")
 		return
@@ -458,7 +558,21 @@ func findAuthor(ctx context.Context, u2r UrlToImageReader, m *Mention, it *micro
 	}
 
 	defer util.Close(r)
-	img, _, err := image.Decode(r)
+	b, err := ioutil.ReadAll(r)
+	if err != nil {
+		glog.Warningf("Failed to read photo: %s", err)
+		return
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(b))
+	if err != nil {
+		glog.Warning("Failed to decode photo.")
+		return
+	}
+	if config.Width*config.Height > MAX_PHOTO_PIXELS {
+		glog.Warningf("Photo too large: %dx%d", config.Width, config.Height)
+		return
+	}
+	img, _, err := image.Decode(bytes.NewReader(b))
 	if err != nil {
 		glog.Warning("Failed to decode photo.")
 		return
@@ -483,12 +597,7 @@ func findAuthor(ctx context.Context, u2r UrlToImageReader, m *Mention, it *micro
 	}
 
 	hash := fmt.Sprintf("%x", md5.Sum(buf.Bytes()))
-	t := &Thumbnail{
-		PNG: buf.Bytes(),
-	}
-	key := ds.NewKey(THUMBNAIL)
-	key.Name = hash
-	if _, err := ds.DS.Put(ctx, key, t); err != nil {
+	if err := store.PutThumbnail(ctx, hash, buf.Bytes()); err != nil {
 		glog.Errorf("Failed to write: %s", err)
 		return
 	}
@@ -496,12 +605,5 @@ func findAuthor(ctx context.Context, u2r UrlToImageReader, m *Mention, it *micro
 }
 
 func GetThumbnail(ctx context.Context, id string) ([]byte, error) {
-	key := ds.NewKey(THUMBNAIL)
-	key.Name = id
-	var t Thumbnail
-	if err := ds.DS.Get(ctx, key, &t); err != nil {
-		return nil, fmt.Errorf("Failed to find image: %s", err)
-	}
-	return t.PNG, nil
-
+	return store.GetThumbnail(ctx, id)
 }
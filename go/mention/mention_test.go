@@ -4,6 +4,8 @@ import (
 	"bytes"
 	"context"
 	"io"
+	"net/http"
+	"net/http/httptest"
 	"net/url"
 	"os"
 	"testing"
@@ -70,28 +72,32 @@ func TestParseAtomFeed(t *testing.T) {
 func TestDB(t *testing.T) {
 	cleanup := testutil.InitDatastore(t, MENTIONS)
 	defer cleanup()
+	Init(NewDatastoreStore())
 
 	err := Put(context.Background(), &Mention{
-		Source: "https://stackoverflow.com/foo",
-		Target: "https://bitworking.org/bar",
-		State:  GOOD_STATE,
-		TS:     time.Now(),
+		Source:       "https://stackoverflow.com/foo",
+		Target:       "https://bitworking.org/bar",
+		TS:           time.Now(),
+		Verification: VERIFY_VERIFIED,
+		Moderation:   MODERATION_APPROVED,
 	})
 	assert.NoError(t, err)
 
 	err = Put(context.Background(), &Mention{
-		Source: "https://spam.com/foo",
-		Target: "https://bitworking.org/bar",
-		State:  SPAM_STATE,
-		TS:     time.Now(),
+		Source:       "https://spam.com/foo",
+		Target:       "https://bitworking.org/bar",
+		TS:           time.Now(),
+		Verification: VERIFY_VERIFIED,
+		Moderation:   MODERATION_REJECTED,
 	})
 	assert.NoError(t, err)
 
 	err = Put(context.Background(), &Mention{
-		Source: "https://news.ycombinator.com/foo",
-		Target: "https://bitworking.org/bar",
-		State:  GOOD_STATE,
-		TS:     time.Now(),
+		Source:       "https://news.ycombinator.com/foo",
+		Target:       "https://bitworking.org/bar",
+		TS:           time.Now(),
+		Verification: VERIFY_VERIFIED,
+		Moderation:   MODERATION_APPROVED,
 	})
 	assert.NoError(t, err)
 	time.Sleep(2)
@@ -128,8 +134,7 @@ func TestParseMicroformats(t *testing.T) {
 	<div id="mentions"></div>
 </article>`
 
-	cleanup := testutil.InitDatastore(t, THUMBNAIL)
-	defer cleanup()
+	Init(NewMemoryStore())
 
 	reader := bytes.NewReader([]byte(raw))
 	u, err := url.Parse("https://bitworking.org/news/2018/01/webmention-only")
@@ -147,3 +152,143 @@ func TestParseMicroformats(t *testing.T) {
 	assert.Equal(t, "2018-01-13 00:00:00 -0500 EST", m.Published.String())
 	assert.Equal(t, "f3f799d1a61805b5ee2ccb5cf0aebafa", m.Thumbnail)
 }
+
+func TestVerifyUpdatesAndDeletes(t *testing.T) {
+	Init(NewMemoryStore())
+	ctx := context.Background()
+
+	content := `<a href="https://bitworking.org/bar">Link</a>`
+	status := 200
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(content))
+	}))
+	defer ts.Close()
+
+	verify := func() *Mention {
+		assert.NoError(t, Enqueue(ctx, New(ts.URL, "https://bitworking.org/bar")))
+		VerifyQueuedMentions(ctx, ts.Client())
+		m, err := Get(ctx, New(ts.URL, "https://bitworking.org/bar").ID())
+		assert.NoError(t, err)
+		return m
+	}
+
+	// A new valid mention is verified and waits for triage.
+	m := verify()
+	assert.Equal(t, VERIFY_VERIFIED, m.Verification)
+	assert.Equal(t, MODERATION_PENDING, m.Moderation)
+	assert.Equal(t, VERIFIED_STATUS, m.Status())
+	assert.Len(t, GetGood(ctx, "https://bitworking.org/bar"), 0)
+
+	// An update with unchanged content keeps the triage decision.
+	triage := GetTriage(ctx, 10, 0)
+	assert.Len(t, triage, 1)
+	assert.NoError(t, UpdateModeration(ctx, triage[0].Key, MODERATION_APPROVED))
+	m = verify()
+	assert.Equal(t, VERIFY_VERIFIED, m.Verification)
+	assert.Equal(t, MODERATION_APPROVED, m.Moderation)
+	assert.Len(t, GetGood(ctx, "https://bitworking.org/bar"), 1)
+
+	// An update with changed content needs triage again.
+	content = `<p>Updated</p><a href="https://bitworking.org/bar">Link</a>`
+	m = verify()
+	assert.Equal(t, VERIFY_VERIFIED, m.Verification)
+	assert.Equal(t, MODERATION_PENDING, m.Moderation)
+	assert.NoError(t, UpdateModeration(ctx, triage[0].Key, MODERATION_APPROVED))
+
+	// A source that no longer links to the target is deleted.
+	content = `<p>Updated</p>`
+	m = verify()
+	assert.Equal(t, VERIFY_DELETED, m.Verification)
+	assert.Len(t, GetGood(ctx, "https://bitworking.org/bar"), 0)
+
+	// As is one that has gone away.
+	content = `<a href="https://bitworking.org/bar">Link</a>`
+	m = verify()
+	assert.Equal(t, VERIFY_VERIFIED, m.Verification)
+	status = http.StatusGone
+	m = verify()
+	assert.Equal(t, VERIFY_DELETED, m.Verification)
+	assert.Equal(t, DELETED_STATUS, m.Status())
+
+	// A new mention that doesn't link to the target fails.
+	content = `<p>No link</p>`
+	status = 200
+	assert.NoError(t, Enqueue(ctx, New(ts.URL, "https://bitworking.org/other")))
+	VerifyQueuedMentions(ctx, ts.Client())
+	m, err := Get(ctx, New(ts.URL, "https://bitworking.org/other").ID())
+	assert.NoError(t, err)
+	assert.Equal(t, VERIFY_FAILED, m.Verification)
+	assert.Equal(t, REJECTED_STATUS, m.Status())
+	assert.Equal(t, errNoLink.Error(), m.Error)
+
+	// As does one whose source has gone away, since it was never verified.
+	status = http.StatusGone
+	assert.NoError(t, Enqueue(ctx, New(ts.URL, "https://bitworking.org/gone")))
+	VerifyQueuedMentions(ctx, ts.Client())
+	m, err = Get(ctx, New(ts.URL, "https://bitworking.org/gone").ID())
+	assert.NoError(t, err)
+	assert.Equal(t, VERIFY_FAILED, m.Verification)
+	assert.Equal(t, REJECTED_STATUS, m.Status())
+	assert.Equal(t, errGone.Error(), m.Error)
+}
+
+func TestMigrate(t *testing.T) {
+	Init(NewMemoryStore())
+	ctx := context.Background()
+
+	legacy := []*Mention{
+		{Source: "https://a.com/", Target: "https://bitworking.org/bar", State: GOOD_STATE},
+		{Source: "https://b.com/", Target: "https://bitworking.org/bar", State: SPAM_STATE},
+		{Source: "https://c.com/", Target: "https://bitworking.org/bar", State: SPAM_STATE, Error: "Not a 200 response: 404"},
+		{Source: "https://d.com/", Target: "https://bitworking.org/bar", State: UNTRIAGED_STATE},
+		{Source: "https://e.com/", Target: "https://bitworking.org/bar", State: SPAM_STATE, ContentHash: "abc"},
+	}
+	for _, m := range legacy {
+		assert.NoError(t, Put(ctx, m))
+	}
+	n, err := Migrate(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 5, n)
+
+	// Spam is only a triage decision if the Mention was verified.
+	expected := [][3]string{
+		{VERIFY_VERIFIED, MODERATION_APPROVED, ""},
+		{VERIFY_FAILED, MODERATION_PENDING, MIGRATED_ERROR},
+		{VERIFY_FAILED, MODERATION_PENDING, "Not a 200 response: 404"},
+		{VERIFY_PENDING, MODERATION_PENDING, ""},
+		{VERIFY_VERIFIED, MODERATION_REJECTED, ""},
+	}
+	for i, m := range legacy {
+		got, err := Get(ctx, m.ID())
+		assert.NoError(t, err)
+		assert.Equal(t, expected[i][0], got.Verification, m.Source)
+		assert.Equal(t, expected[i][1], got.Moderation, m.Source)
+		assert.Equal(t, expected[i][2], got.Error, m.Source)
+		assert.Equal(t, "", got.State)
+	}
+	assert.Len(t, GetQueued(ctx), 1)
+
+	// Running again is a no-op.
+	n, err = Migrate(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, n)
+}
+
+func TestFastValidate(t *testing.T) {
+	hosts := []string{"bitworking.org"}
+	assert.NoError(t, New("https://example.com/a", "https://bitworking.org/b").FastValidate(hosts))
+	assert.NoError(t, New("https://example.com/a", "http://bitworking.org/b").FastValidate(hosts))
+	assert.NoError(t, New("https://example.com/a", "https://BitWorking.org/b").FastValidate(hosts))
+	assert.NoError(t, New("https://example.com/a", "https://bitworking.org/b").FastValidate([]string{"BitWorking.org"}))
+	for _, bad := range []*Mention{
+		New("", "https://bitworking.org/b"),
+		New("https://example.com/a", ""),
+		New("https://bitworking.org/b", "https://bitworking.org/b"),
+		New("https://example.com/a", "https://example.org/b"),
+		New("https://example.com/a", "ftp://bitworking.org/b"),
+		New("https://example.com/a", "://bitworking.org/b"),
+	} {
+		assert.Error(t, bad.FastValidate(hosts), bad.Target)
+	}
+}
@@ -0,0 +1,74 @@
+package mention
+
+import (
+	"context"
+	"fmt"
+)
+
+// MIGRATE_BATCH_SIZE is the number of Mentions read at a time by Migrate.
+const MIGRATE_BATCH_SIZE = 100
+
+// MIGRATED_ERROR is the Error of legacy spam Mentions that can't be told
+// apart from ones that failed verification.
+const MIGRATED_ERROR = "Migrated: marked as spam before verification was recorded"
+
+// migrateState sets Verification and Moderation from the legacy State of a
+// Mention, returning false if the Mention doesn't need migrating.
+//
+// SlowValidate failures and triage decisions were both recorded as spam, and
+// Mentions stored before Error existed don't say which. Only a ContentHash
+// shows a Mention was verified, so spam without one is taken to have failed
+// verification.
+func migrateState(m *Mention) bool {
+	if m.State == "" {
+		return false
+	}
+	switch m.State {
+	case GOOD_STATE:
+		m.Verification = VERIFY_VERIFIED
+		m.Moderation = MODERATION_APPROVED
+	case SPAM_STATE:
+		if m.ContentHash != "" {
+			m.Verification = VERIFY_VERIFIED
+			m.Moderation = MODERATION_REJECTED
+		} else {
+			m.Verification = VERIFY_FAILED
+			m.Moderation = MODERATION_PENDING
+			if m.Error == "" {
+				m.Error = MIGRATED_ERROR
+			}
+		}
+	default:
+		m.Verification = VERIFY_PENDING
+		m.Moderation = MODERATION_PENDING
+	}
+	m.State = ""
+	return true
+}
+
+// Migrate converts all stored Mentions that only have the legacy State into
+// ones with Verification and Moderation. It is a one off step, run with the
+// -migrate flag of userve, though it is safe to run more than once. Returns
+// the number of Mentions migrated.
+func Migrate(ctx context.Context) (int, error) {
+	n := 0
+	for offset := 0; ; offset += MIGRATE_BATCH_SIZE {
+		batch, err := store.GetTriage(ctx, MIGRATE_BATCH_SIZE, offset)
+		if err != nil {
+			return n, fmt.Errorf("Failed to read mentions: %s", err)
+		}
+		for _, mk := range batch {
+			m := mk.Mention
+			if !migrateState(&m) {
+				continue
+			}
+			if err := store.Put(ctx, &m); err != nil {
+				return n, fmt.Errorf("Failed to migrate mention: %s", err)
+			}
+			n += 1
+		}
+		if len(batch) < MIGRATE_BATCH_SIZE {
+			return n, nil
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package mention
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/skia-dev/glog"
+)
+
+const (
+	// MAX_ATTEMPTS is the number of times verification is tried before a
+	// Mention is marked as failed.
+	MAX_ATTEMPTS = 10
+
+	// BASE_BACKOFF is the delay before the first retry, which doubles on each
+	// subsequent attempt.
+	BASE_BACKOFF = time.Minute
+
+	// MAX_BACKOFF is the longest delay between attempts.
+	MAX_BACKOFF = 12 * time.Hour
+)
+
+// transientError is returned from SlowValidate for failures that might go
+// away if tried again later, such as timeouts or 5xx responses.
+type transientError struct {
+	error
+}
+
+func isTransient(err error) bool {
+	_, ok := err.(transientError)
+	return ok
+}
+
+// backoff returns how long to wait before retrying after the given number
+// of failed attempts.
+func backoff(attempts int) time.Duration {
+	d := BASE_BACKOFF
+	for i := 1; i < attempts; i++ {
+		d *= 2
+		if d >= MAX_BACKOFF {
+			return MAX_BACKOFF
+		}
+	}
+	return d
+}
+
+// VerifyQueuedMentions runs SlowValidate on every queued Mention that is due
+// to be verified.
+//
+// Mentions that fail with a transient error stay queued and are retried with
+// exponential backoff, until MAX_ATTEMPTS is reached and they are marked as
+// failed. A Mention that was verified before keeps its Moderation unless the
+// source content has changed, and is marked as deleted if the source is gone
+// or no longer links to the target.
+func VerifyQueuedMentions(ctx context.Context, c *http.Client) {
+	queued := GetQueued(ctx)
+	glog.Infof("About to slow verify %d queud mentions.", len(queued))
+	now := time.Now()
+	for _, m := range queued {
+		if m.NextAttempt.After(now) {
+			continue
+		}
+		glog.Infof("Verifying queued webmention from %q", m.Source)
+		prevHash := m.ContentHash
+		err := m.SlowValidate(ctx, c)
+		if isTransient(err) {
+			m.Attempts += 1
+			m.Error = err.Error()
+			if m.Attempts >= MAX_ATTEMPTS {
+				m.Verification = VERIFY_FAILED
+				glog.Warningf("Giving up on webmention after %d attempts: %#v", m.Attempts, *m)
+			} else {
+				m.NextAttempt = now.Add(backoff(m.Attempts))
+				glog.Infof("Will retry webmention at %s: %s", m.NextAttempt, err)
+			}
+			if err := Put(ctx, m); err != nil {
+				glog.Errorf("Failed to save queued message: %s", err)
+			}
+			continue
+		}
+		if err == nil {
+			m.Verification = VERIFY_VERIFIED
+			m.Error = ""
+			if prevHash != "" && m.ContentHash != prevHash {
+				m.Moderation = MODERATION_PENDING
+			}
+		} else if (err == errGone || err == errNoLink) && prevHash != "" {
+			m.Verification = VERIFY_DELETED
+			m.Error = err.Error()
+			glog.Infof("Webmention deleted: %#v", *m)
+		} else {
+			m.Verification = VERIFY_FAILED
+			m.Error = err.Error()
+			glog.Warningf("Failed to validate webmention: %#v", *m)
+		}
+		m.Attempts = 0
+		m.NextAttempt = time.Time{}
+		if err := Put(ctx, m); err != nil {
+			glog.Errorf("Failed to save validated message: %s", err)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package mention
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackoff(t *testing.T) {
+	assert.Equal(t, time.Minute, backoff(1))
+	assert.Equal(t, 2*time.Minute, backoff(2))
+	assert.Equal(t, 4*time.Minute, backoff(3))
+	assert.Equal(t, 512*time.Minute, backoff(MAX_ATTEMPTS))
+	assert.Equal(t, MAX_BACKOFF, backoff(20))
+}
+
+func TestVerifyRetries(t *testing.T) {
+	Init(NewMemoryStore())
+	ctx := context.Background()
+
+	status := http.StatusServiceUnavailable
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(`<a href="https://bitworking.org/bar">Link</a>`))
+	}))
+	defer ts.Close()
+
+	m := New(ts.URL, "https://bitworking.org/bar")
+	assert.NoError(t, Enqueue(ctx, m))
+
+	// A transient failure leaves the mention queued with a backoff.
+	VerifyQueuedMentions(ctx, ts.Client())
+	m, err := Get(ctx, m.ID())
+	assert.NoError(t, err)
+	assert.Equal(t, VERIFY_PENDING, m.Verification)
+	assert.Equal(t, 1, m.Attempts)
+	assert.True(t, m.NextAttempt.After(time.Now()))
+	assert.Equal(t, QUEUED_STATUS, m.Status())
+
+	// Not retried until the backoff has passed.
+	status = 200
+	VerifyQueuedMentions(ctx, ts.Client())
+	m, err = Get(ctx, m.ID())
+	assert.NoError(t, err)
+	assert.Equal(t, VERIFY_PENDING, m.Verification)
+
+	m.NextAttempt = time.Now().Add(-time.Second)
+	assert.NoError(t, Put(ctx, m))
+	VerifyQueuedMentions(ctx, ts.Client())
+	m, err = Get(ctx, m.ID())
+	assert.NoError(t, err)
+	assert.Equal(t, VERIFY_VERIFIED, m.Verification)
+	assert.Equal(t, 0, m.Attempts)
+
+	// Too many transient failures is a dead letter.
+	status = http.StatusBadGateway
+	m.Verification = VERIFY_PENDING
+	m.Attempts = MAX_ATTEMPTS - 1
+	assert.NoError(t, Put(ctx, m))
+	VerifyQueuedMentions(ctx, ts.Client())
+	m, err = Get(ctx, m.ID())
+	assert.NoError(t, err)
+	assert.Equal(t, VERIFY_FAILED, m.Verification)
+	assert.True(t, m.Dead())
+	assert.Equal(t, REJECTED_STATUS, m.Status())
+
+	// A permanent failure is not retried.
+	status = http.StatusNotFound
+	assert.NoError(t, Enqueue(ctx, m))
+	VerifyQueuedMentions(ctx, ts.Client())
+	m, err = Get(ctx, m.ID())
+	assert.NoError(t, err)
+	assert.Equal(t, VERIFY_FAILED, m.Verification)
+	assert.False(t, m.Dead())
+	assert.Equal(t, 0, m.Attempts)
+}
+
+// flakyStore is a Store whose Get fails with something other than
+// ErrNotFound.
+type flakyStore struct {
+	Store
+}
+
+func (f flakyStore) Get(ctx context.Context, id string) (*Mention, error) {
+	return nil, fmt.Errorf("Transient failure")
+}
+
+func TestEnqueueKeepsMentionOnGetFailure(t *testing.T) {
+	s := NewMemoryStore()
+	ctx := context.Background()
+	m := New("https://example.com/reply", "https://bitworking.org/bar")
+	m.Moderation = MODERATION_APPROVED
+	assert.NoError(t, s.Put(ctx, m))
+
+	Init(flakyStore{Store: s})
+	defer Init(s)
+	assert.Error(t, Enqueue(ctx, New(m.Source, m.Target)))
+
+	got, err := s.Get(ctx, m.ID())
+	assert.NoError(t, err)
+	assert.Equal(t, MODERATION_APPROVED, got.Moderation)
+}
@@ -0,0 +1,76 @@
+package mention
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrNotFound is returned from Store.Get if there is no such Mention.
+var ErrNotFound = errors.New("No such mention")
+
+// Store is the storage backend for Mentions, WebMentionSent records, and
+// Thumbnails.
+type Store interface {
+	// Put writes the Mention, overwriting any Mention with the same Source and
+	// Target.
+	Put(ctx context.Context, m *Mention) error
+
+	// Get returns the Mention with the given id, as returned from
+	// Mention.ID(), or ErrNotFound if there isn't one.
+	Get(ctx context.Context, id string) (*Mention, error)
+
+	// GetByTarget returns the Mentions for the given target. If all is false
+	// then only verified and approved Mentions are returned.
+	GetByTarget(ctx context.Context, target string, all bool) ([]*Mention, error)
+
+	// GetTriage returns Mentions ordered by most recent TS first.
+	GetTriage(ctx context.Context, limit, offset int) ([]*MentionWithKey, error)
+
+	// GetQueued returns all the Mentions waiting for verification.
+	GetQueued(ctx context.Context) ([]*Mention, error)
+
+	// UpdateModeration changes the Moderation of the Mention with the given
+	// key, where key is the value found in MentionWithKey.Key.
+	UpdateModeration(ctx context.Context, key, moderation string) error
+
+	// Sent returns the time webmentions were last sent for the given source,
+	// and false if they have never been sent.
+	Sent(ctx context.Context, source string) (time.Time, bool)
+
+	// RecordSent records that webmentions were sent for the given source.
+	RecordSent(ctx context.Context, source string, updated time.Time) error
+
+	// PutThumbnail stores the PNG encoded thumbnail under the given id.
+	PutThumbnail(ctx context.Context, id string, png []byte) error
+
+	// GetThumbnail returns the PNG encoded thumbnail with the given id.
+	GetThumbnail(ctx context.Context, id string) ([]byte, error)
+}
+
+// namespaceKey is the context key for the namespace, see WithNamespace.
+type namespaceKey struct{}
+
+// WithNamespace returns a context under which Mentions, WebMentionSent
+// records, and Thumbnails are read and written in the given namespace,
+// keeping the mentions of different sites separate. The empty namespace is
+// the default one.
+func WithNamespace(ctx context.Context, namespace string) context.Context {
+	return context.WithValue(ctx, namespaceKey{}, namespace)
+}
+
+// Namespace returns the namespace set by WithNamespace, or "" if none was.
+// Store implementations must keep the data in each namespace separate.
+func Namespace(ctx context.Context) string {
+	ns, _ := ctx.Value(namespaceKey{}).(string)
+	return ns
+}
+
+// store is the Store used by all the functions in this package.
+var store Store
+
+// Init sets the Store to use. Must be called before any other function in
+// this package that reads or writes Mentions.
+func Init(s Store) {
+	store = s
+}
@@ -0,0 +1,139 @@
+package mention
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testStore exercises the Store s, which must be empty.
+func testStore(t *testing.T, s Store) {
+	ctx := context.Background()
+
+	now := time.Now()
+	assert.NoError(t, s.Put(ctx, &Mention{
+		Source:       "https://stackoverflow.com/foo",
+		Target:       "https://bitworking.org/bar",
+		TS:           now.Add(-2 * time.Minute),
+		Verification: VERIFY_VERIFIED,
+		Moderation:   MODERATION_APPROVED,
+	}))
+	assert.NoError(t, s.Put(ctx, &Mention{
+		Source:       "https://spam.com/foo",
+		Target:       "https://bitworking.org/bar",
+		TS:           now.Add(-time.Minute),
+		Verification: VERIFY_PENDING,
+		Moderation:   MODERATION_PENDING,
+	}))
+	assert.NoError(t, s.Put(ctx, &Mention{
+		Source:       "https://news.ycombinator.com/foo",
+		Target:       "https://bitworking.org/bar",
+		TS:           now,
+		Verification: VERIFY_VERIFIED,
+		Moderation:   MODERATION_APPROVED,
+	}))
+
+	m, err := s.GetByTarget(ctx, "https://bitworking.org/bar", false)
+	assert.NoError(t, err)
+	assert.Len(t, m, 2)
+
+	m, err = s.GetByTarget(ctx, "https://bitworking.org/bar", true)
+	assert.NoError(t, err)
+	assert.Len(t, m, 3)
+
+	spam := &Mention{
+		Source: "https://spam.com/foo",
+		Target: "https://bitworking.org/bar",
+	}
+	got, err := s.Get(ctx, spam.ID())
+	assert.NoError(t, err)
+	assert.Equal(t, VERIFY_PENDING, got.Verification)
+	_, err = s.Get(ctx, "not-a-valid-id")
+	assert.Equal(t, ErrNotFound, err)
+
+	m, err = s.GetQueued(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, m, 1)
+	assert.Equal(t, "https://spam.com/foo", m[0].Source)
+
+	triage, err := s.GetTriage(ctx, 2, 0)
+	assert.NoError(t, err)
+	assert.Len(t, triage, 2)
+	assert.Equal(t, "https://news.ycombinator.com/foo", triage[0].Source)
+	assert.Equal(t, "https://spam.com/foo", triage[1].Source)
+
+	triage, err = s.GetTriage(ctx, 2, 2)
+	assert.NoError(t, err)
+	assert.Len(t, triage, 1)
+	assert.Equal(t, "https://stackoverflow.com/foo", triage[0].Source)
+
+	assert.NoError(t, s.UpdateModeration(ctx, triage[0].Key, MODERATION_REJECTED))
+	m, err = s.GetByTarget(ctx, "https://bitworking.org/bar", false)
+	assert.NoError(t, err)
+	assert.Len(t, m, 1)
+
+	_, ok := s.Sent(ctx, "https://bitworking.org/news/1")
+	assert.False(t, ok)
+	assert.NoError(t, s.RecordSent(ctx, "https://bitworking.org/news/1", now))
+	ts, ok := s.Sent(ctx, "https://bitworking.org/news/1")
+	assert.True(t, ok)
+	assert.Equal(t, now.Unix(), ts.Unix())
+
+	_, err = s.GetThumbnail(ctx, "abc")
+	assert.Error(t, err)
+	assert.NoError(t, s.PutThumbnail(ctx, "abc", []byte("png")))
+	b, err := s.GetThumbnail(ctx, "abc")
+	assert.NoError(t, err)
+	assert.Equal(t, []byte("png"), b)
+
+	// Nothing is visible from another namespace, and writes there don't
+	// affect the default one.
+	other := WithNamespace(ctx, "other")
+	m, err = s.GetByTarget(other, "https://bitworking.org/bar", true)
+	assert.NoError(t, err)
+	assert.Len(t, m, 0)
+	_, err = s.Get(other, spam.ID())
+	assert.Error(t, err)
+	_, ok = s.Sent(other, "https://bitworking.org/news/1")
+	assert.False(t, ok)
+	_, err = s.GetThumbnail(other, "abc")
+	assert.Error(t, err)
+
+	assert.NoError(t, s.Put(other, &Mention{
+		Source:       "https://example.com/foo",
+		Target:       "https://example.org/bar",
+		TS:           now,
+		Verification: VERIFY_PENDING,
+		Moderation:   MODERATION_PENDING,
+	}))
+	m, err = s.GetQueued(other)
+	assert.NoError(t, err)
+	assert.Len(t, m, 1)
+	m, err = s.GetQueued(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, m, 1)
+	assert.Equal(t, "https://spam.com/foo", m[0].Source)
+	triage, err = s.GetTriage(other, 10, 0)
+	assert.NoError(t, err)
+	assert.Len(t, triage, 1)
+	assert.NoError(t, s.UpdateModeration(other, triage[0].Key, MODERATION_APPROVED))
+}
+
+func TestBoltStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mention")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	s, err := NewBoltStore(filepath.Join(dir, "test.db"))
+	assert.NoError(t, err)
+	testStore(t, s)
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, NewMemoryStore())
+}
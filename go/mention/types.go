@@ -0,0 +1,156 @@
+package mention
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"willnorris.com/go/microformats"
+)
+
+// Mention types, for Mention.Type.
+const (
+	REPLY_TYPE    = "reply"
+	LIKE_TYPE     = "like"
+	REPOST_TYPE   = "repost"
+	BOOKMARK_TYPE = "bookmark"
+	RSVP_TYPE     = "rsvp"
+	MENTION_TYPE  = "mention"
+)
+
+// typeProps are the h-entry properties that make a Mention of a given type
+// when they refer to the target, checked in order.
+var typeProps = []struct {
+	prop string
+	typ  string
+}{
+	{"like-of", LIKE_TYPE},
+	{"repost-of", REPOST_TYPE},
+	{"bookmark-of", BOOKMARK_TYPE},
+	{"in-reply-to", REPLY_TYPE},
+}
+
+// propURLs returns the URLs in the given property, which may be plain URLs or
+// embedded microformats such as an h-cite.
+func propURLs(it *microformats.Microformat, key string) []string {
+	ret := []string{}
+	for _, v := range it.Properties[key] {
+		switch t := v.(type) {
+		case string:
+			ret = append(ret, t)
+		case *microformats.Microformat:
+			if u := firstPropAsString(t, "url"); u != "" {
+				ret = append(ret, u)
+			} else if t.Value != "" {
+				ret = append(ret, t.Value)
+			}
+		}
+	}
+	return ret
+}
+
+// normalizeURL strips the parts of a URL that don't affect which page it
+// refers to, for comparing URLs.
+func normalizeURL(s string) string {
+	u, err := url.Parse(strings.TrimSpace(s))
+	if err != nil {
+		return s
+	}
+	u.Fragment = ""
+	u.Host = strings.ToLower(u.Host)
+	u.Path = strings.TrimSuffix(u.Path, "/")
+	return u.String()
+}
+
+func containsURL(urls []string, target string) bool {
+	target = normalizeURL(target)
+	for _, u := range urls {
+		if normalizeURL(u) == target {
+			return true
+		}
+	}
+	return false
+}
+
+// mentionType returns the type of Mention the h-entry it makes of target.
+func mentionType(it *microformats.Microformat, target string) string {
+	for _, tp := range typeProps {
+		if !containsURL(propURLs(it, tp.prop), target) {
+			continue
+		}
+		if tp.typ == REPLY_TYPE && firstPropAsString(it, "rsvp") != "" {
+			return RSVP_TYPE
+		}
+		return tp.typ
+	}
+	return MENTION_TYPE
+}
+
+// Thread is a reply along with the replies to it.
+type Thread struct {
+	*Mention
+	Replies []*Thread
+}
+
+// Threads arranges replies into threads, where a reply is a child of another
+// reply if it is also in reply to that reply's Source. Threads are ordered
+// oldest first.
+func Threads(replies []*Mention) []*Thread {
+	bySource := map[string]*Thread{}
+	threads := make([]*Thread, 0, len(replies))
+	for _, m := range replies {
+		t := &Thread{Mention: m}
+		threads = append(threads, t)
+		bySource[normalizeURL(m.Source)] = t
+	}
+	ret := []*Thread{}
+	for _, t := range threads {
+		var parent *Thread
+		for _, u := range t.InReplyTo {
+			if p, ok := bySource[normalizeURL(u)]; ok && p != t {
+				parent = p
+				break
+			}
+		}
+		if parent != nil && !isAncestor(t, parent) {
+			parent.Replies = append(parent.Replies, t)
+		} else {
+			ret = append(ret, t)
+		}
+	}
+	sortThreads(ret)
+	return ret
+}
+
+// isAncestor returns true if a is t or one of its descendants, which would
+// make a cycle if t became a child of a.
+func isAncestor(t, a *Thread) bool {
+	if t == a {
+		return true
+	}
+	for _, r := range t.Replies {
+		if isAncestor(r, a) {
+			return true
+		}
+	}
+	return false
+}
+
+func sortThreads(threads []*Thread) {
+	sort.SliceStable(threads, func(i, j int) bool {
+		return threads[i].sortTime().Before(threads[j].sortTime())
+	})
+	for _, t := range threads {
+		sortThreads(t.Replies)
+	}
+}
+
+// sortTime is when the Mention was published, or received if that isn't
+// known.
+func (m *Mention) sortTime() time.Time {
+	if !m.Published.IsZero() {
+		return m.Published
+	}
+	return m.TS
+}
@@ -0,0 +1,64 @@
+package mention
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"willnorris.com/go/microformats"
+)
+
+func TestMentionType(t *testing.T) {
+	const target = "https://bitworking.org/news/1"
+	entry := func(props map[string][]interface{}) *microformats.Microformat {
+		return &microformats.Microformat{
+			Type:       []string{"h-entry"},
+			Properties: props,
+		}
+	}
+	cite := &microformats.Microformat{
+		Type:       []string{"h-cite"},
+		Properties: map[string][]interface{}{"url": {target}},
+	}
+	for _, tc := range []struct {
+		name     string
+		entry    *microformats.Microformat
+		expected string
+	}{
+		{"reply", entry(map[string][]interface{}{"in-reply-to": {target}}), REPLY_TYPE},
+		{"reply with slash and fragment", entry(map[string][]interface{}{"in-reply-to": {target + "/#comments"}}), REPLY_TYPE},
+		{"reply to h-cite", entry(map[string][]interface{}{"in-reply-to": {cite}}), REPLY_TYPE},
+		{"reply elsewhere", entry(map[string][]interface{}{"in-reply-to": {"https://example.com/"}}), MENTION_TYPE},
+		{"like", entry(map[string][]interface{}{"like-of": {target}}), LIKE_TYPE},
+		{"repost", entry(map[string][]interface{}{"repost-of": {cite}}), REPOST_TYPE},
+		{"bookmark", entry(map[string][]interface{}{"bookmark-of": {target}}), BOOKMARK_TYPE},
+		{"rsvp", entry(map[string][]interface{}{"in-reply-to": {target}, "rsvp": {"yes"}}), RSVP_TYPE},
+		{"mention", entry(map[string][]interface{}{"name": {"Hello"}}), MENTION_TYPE},
+	} {
+		assert.Equal(t, tc.expected, mentionType(tc.entry, target), tc.name)
+	}
+}
+
+func TestThreads(t *testing.T) {
+	now := time.Now()
+	a := &Mention{Source: "https://a.com/1", Published: now.Add(-3 * time.Hour), InReplyTo: []string{"https://bitworking.org/news/1"}}
+	b := &Mention{Source: "https://b.com/1", Published: now.Add(-2 * time.Hour), InReplyTo: []string{"https://bitworking.org/news/1", "https://a.com/1"}}
+	c := &Mention{Source: "https://c.com/1", Published: now.Add(-1 * time.Hour), InReplyTo: []string{"https://b.com/1/"}}
+	d := &Mention{Source: "https://d.com/1", Published: now.Add(-4 * time.Hour), InReplyTo: []string{"https://bitworking.org/news/1"}}
+
+	threads := Threads([]*Mention{c, b, a, d})
+	assert.Len(t, threads, 2)
+	assert.Equal(t, d, threads[0].Mention)
+	assert.Equal(t, a, threads[1].Mention)
+	assert.Len(t, threads[1].Replies, 1)
+	assert.Equal(t, b, threads[1].Replies[0].Mention)
+	assert.Len(t, threads[1].Replies[0].Replies, 1)
+	assert.Equal(t, c, threads[1].Replies[0].Replies[0].Mention)
+
+	// Cycles don't lose replies.
+	e := &Mention{Source: "https://e.com/1", InReplyTo: []string{"https://f.com/1"}}
+	f := &Mention{Source: "https://f.com/1", InReplyTo: []string{"https://e.com/1"}}
+	threads = Threads([]*Mention{e, f})
+	assert.Len(t, threads, 1)
+	assert.Len(t, threads[0].Replies, 1)
+}
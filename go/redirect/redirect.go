@@ -0,0 +1,381 @@
+// Package redirect parses and applies redirect rules files.
+//
+// Each line of a rules file is a rule, blank lines and lines starting with #
+// are ignored. A rule is a source, followed by a destination and an optional
+// status code, or just the status code 410 for pages that are gone:
+//
+//	# Exact paths.
+//	/old/page /new/page
+//	/moved/temporarily /elsewhere 302
+//	/removed 410
+//
+//	# Prefixes end in *, a * at the end of the destination is replaced by
+//	# the rest of the path.
+//	/blog/* /news/* 308
+//	/drafts/* /
+//
+//	# Regular expressions start with ~, and $1 or ${name} in the destination
+//	# are replaced by the submatches.
+//	~^/(\d{4})/(\d{2})/([^/]+)\.html$ /news/$1/$2/$3
+//
+// The status defaults to 301 and must be one of 301, 302, 307, 308, or 410.
+// Exact rules are checked first, then prefixes, longest first, then regular
+// expressions in the order they appear. The query string of the request is
+// kept on the destination.
+package redirect
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Kinds of rules, for Rule.Kind.
+const (
+	EXACT  = "exact"
+	PREFIX = "prefix"
+	REGEX  = "regex"
+)
+
+// MAX_CHAIN is the longest chain of redirects followed when looking for
+// chains and loops.
+const MAX_CHAIN = 10
+
+var allowedStatus = map[int]bool{
+	http.StatusMovedPermanently:  true,
+	http.StatusFound:             true,
+	http.StatusTemporaryRedirect: true,
+	http.StatusPermanentRedirect: true,
+	http.StatusGone:              true,
+}
+
+// Rule is a single redirect rule.
+type Rule struct {
+	// Line is the line number of the rule in the file, starting at 1.
+	Line int
+
+	// Kind is one of EXACT, PREFIX, or REGEX.
+	Kind string
+
+	// Source is the path, prefix without the trailing *, or regular
+	// expression without the leading ~.
+	Source string
+
+	// Dest is the destination, empty for 410 Gone.
+	Dest string
+
+	// Status is the HTTP status code of the response.
+	Status int
+
+	re *regexp.Regexp
+}
+
+func (r *Rule) String() string {
+	return fmt.Sprintf("line %d: %s", r.Line, r.text())
+}
+
+// text returns the rule as it would appear in a rules file.
+func (r *Rule) text() string {
+	src := r.Source
+	switch r.Kind {
+	case PREFIX:
+		src += "*"
+	case REGEX:
+		src = "~" + src
+	}
+	if r.Status == http.StatusGone {
+		return fmt.Sprintf("%s %d", src, r.Status)
+	}
+	return fmt.Sprintf("%s %s %d", src, r.Dest, r.Status)
+}
+
+// dest returns the destination for path if the rule matches it.
+func (r *Rule) dest(path string) (string, bool) {
+	switch r.Kind {
+	case EXACT:
+		return r.Dest, path == r.Source
+	case PREFIX:
+		if !strings.HasPrefix(path, r.Source) {
+			return "", false
+		}
+		if strings.HasSuffix(r.Dest, "*") {
+			return strings.TrimSuffix(r.Dest, "*") + strings.TrimPrefix(path, r.Source), true
+		}
+		return r.Dest, true
+	case REGEX:
+		match := r.re.FindStringSubmatchIndex(path)
+		if match == nil {
+			return "", false
+		}
+		return string(r.re.ExpandString(nil, r.Dest, path, match)), true
+	}
+	return "", false
+}
+
+// Rules are all the rules from a rules file.
+type Rules struct {
+	// All the rules in the order they appear.
+	All []*Rule
+
+	exact  map[string]*Rule
+	prefix []*Rule
+	regex  []*Rule
+}
+
+// Len returns the number of rules.
+func (rs *Rules) Len() int {
+	if rs == nil {
+		return 0
+	}
+	return len(rs.All)
+}
+
+// parseRule parses a single non-blank, non-comment line.
+func parseRule(lineNum int, line string) (*Rule, error) {
+	fields := strings.Fields(line)
+	r := &Rule{
+		Line:   lineNum,
+		Kind:   EXACT,
+		Status: http.StatusMovedPermanently,
+	}
+	src := fields[0]
+	switch len(fields) {
+	case 2:
+		if status, err := strconv.Atoi(fields[1]); err == nil {
+			if status != http.StatusGone {
+				return nil, fmt.Errorf("line %d: Only 410 can be given without a destination.", lineNum)
+			}
+			r.Status = status
+		} else {
+			r.Dest = fields[1]
+		}
+	case 3:
+		r.Dest = fields[1]
+		status, err := strconv.Atoi(fields[2])
+		if err != nil || !allowedStatus[status] {
+			return nil, fmt.Errorf("line %d: Invalid status %q.", lineNum, fields[2])
+		}
+		if status == http.StatusGone {
+			return nil, fmt.Errorf("line %d: 410 can't have a destination.", lineNum)
+		}
+		r.Status = status
+	default:
+		return nil, fmt.Errorf("line %d: Expected a source, destination, and optional status.", lineNum)
+	}
+	switch {
+	case strings.HasPrefix(src, "~"):
+		r.Kind = REGEX
+		r.Source = src[1:]
+		re, err := regexp.Compile(r.Source)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: Invalid regular expression: %s", lineNum, err)
+		}
+		r.re = re
+	case strings.HasSuffix(src, "*"):
+		r.Kind = PREFIX
+		r.Source = strings.TrimSuffix(src, "*")
+	default:
+		r.Source = src
+	}
+	if r.Kind != REGEX && !strings.HasPrefix(r.Source, "/") {
+		return nil, fmt.Errorf("line %d: Source must be a path starting with /.", lineNum)
+	}
+	if r.Dest != "" {
+		if !strings.HasPrefix(r.Dest, "/") && !strings.HasPrefix(r.Dest, "http://") && !strings.HasPrefix(r.Dest, "https://") {
+			return nil, fmt.Errorf("line %d: Destination must be a path or an http or https URL.", lineNum)
+		}
+		if r.Kind != PREFIX && strings.HasSuffix(r.Dest, "*") {
+			return nil, fmt.Errorf("line %d: Only prefix rules can have a * in the destination.", lineNum)
+		}
+	}
+	return r, nil
+}
+
+// Parse parses a rules file. Lines that can't be parsed are skipped and
+// returned as errors, so a mistake in one rule doesn't stop the rest from
+// working.
+func Parse(r io.Reader) (*Rules, []error) {
+	rs := &Rules{
+		All:   []*Rule{},
+		exact: map[string]*Rule{},
+	}
+	errs := []error{}
+	scanner := bufio.NewScanner(r)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum += 1
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		rule, err := parseRule(lineNum, line)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		rs.All = append(rs.All, rule)
+		switch rule.Kind {
+		case EXACT:
+			// The first rule for a path wins, see Check.
+			if _, ok := rs.exact[rule.Source]; !ok {
+				rs.exact[rule.Source] = rule
+			}
+		case PREFIX:
+			rs.prefix = append(rs.prefix, rule)
+		case REGEX:
+			rs.regex = append(rs.regex, rule)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		errs = append(errs, fmt.Errorf("Failed to read rules: %s", err))
+	}
+	sort.SliceStable(rs.prefix, func(i, j int) bool {
+		return len(rs.prefix[i].Source) > len(rs.prefix[j].Source)
+	})
+	return rs, errs
+}
+
+// Load reads and parses the rules file at filename, see Parse.
+func Load(filename string) (*Rules, []error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, []error{fmt.Errorf("Failed to read redirects file: %s", err)}
+	}
+	defer f.Close()
+	return Parse(f)
+}
+
+// find returns the first rule that matches path, and the destination.
+func (rs *Rules) find(path string) (*Rule, string) {
+	if rs == nil {
+		return nil, ""
+	}
+	if r, ok := rs.exact[path]; ok {
+		return r, r.Dest
+	}
+	for _, r := range rs.prefix {
+		if dest, ok := r.dest(path); ok {
+			return r, dest
+		}
+	}
+	for _, r := range rs.regex {
+		if dest, ok := r.dest(path); ok {
+			return r, dest
+		}
+	}
+	return nil, ""
+}
+
+// Match returns the destination and status code for a request to u, and
+// false if no rule matches. The destination is empty for 410 Gone.
+func (rs *Rules) Match(u *url.URL) (string, int, bool) {
+	r, dest := rs.find(u.Path)
+	if r == nil {
+		return "", 0, false
+	}
+	if r.Status == http.StatusGone {
+		return "", r.Status, true
+	}
+	if u.RawQuery != "" {
+		if strings.Contains(dest, "?") {
+			dest += "&" + u.RawQuery
+		} else {
+			dest += "?" + u.RawQuery
+		}
+	}
+	return dest, r.Status, true
+}
+
+// Check returns the problems with the rules: rules with the same source,
+// where only the first is used, and redirects to a path that is redirected
+// again, or that ends up back where it started.
+func (rs *Rules) Check() []string {
+	ret := []string{}
+	seen := map[string]*Rule{}
+	for _, r := range rs.All {
+		key := r.Kind + " " + r.Source
+		if first, ok := seen[key]; ok {
+			ret = append(ret, fmt.Sprintf("Conflict: %s has the same source as %s, only the first is used.", r, first))
+			continue
+		}
+		seen[key] = r
+	}
+	for _, r := range rs.All {
+		if r.Status == http.StatusGone || r.Kind == REGEX || !strings.HasPrefix(r.Dest, "/") {
+			continue
+		}
+		if first := seen[r.Kind+" "+r.Source]; first != r {
+			continue
+		}
+		// Prefix rules are checked for the prefix itself.
+		dest, _ := r.dest(r.Source)
+		chain := []*Rule{r}
+		loop := false
+		for {
+			next, nextDest := rs.find(strings.SplitN(dest, "?", 2)[0])
+			if next == nil {
+				break
+			}
+			for _, c := range chain {
+				if c == next {
+					loop = true
+				}
+			}
+			chain = append(chain, next)
+			if loop || len(chain) > MAX_CHAIN || next.Status == http.StatusGone || !strings.HasPrefix(nextDest, "/") {
+				break
+			}
+			dest = nextDest
+		}
+		if loop {
+			ret = append(ret, fmt.Sprintf("Loop: %s", describeChain(chain)))
+		} else if len(chain) > 1 {
+			ret = append(ret, fmt.Sprintf("Chain: %s", describeChain(chain)))
+		}
+	}
+	return ret
+}
+
+// Diff returns the rules that are in b but not a, and those in a but not b,
+// ignoring line numbers. Either may be nil.
+func Diff(a, b *Rules) ([]string, []string) {
+	texts := func(rs *Rules) map[string]bool {
+		ret := map[string]bool{}
+		if rs != nil {
+			for _, r := range rs.All {
+				ret[r.text()] = true
+			}
+		}
+		return ret
+	}
+	inA, inB := texts(a), texts(b)
+	added, removed := []string{}, []string{}
+	for t := range inB {
+		if !inA[t] {
+			added = append(added, t)
+		}
+	}
+	for t := range inA {
+		if !inB[t] {
+			removed = append(removed, t)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	return added, removed
+}
+
+func describeChain(chain []*Rule) string {
+	parts := []string{}
+	for _, r := range chain {
+		parts = append(parts, "("+r.String()+")")
+	}
+	return strings.Join(parts, " -> ")
+}
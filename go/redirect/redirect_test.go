@@ -0,0 +1,136 @@
+package redirect
+
+import (
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const rules = `
+# Exact paths.
+/old/page /new/page
+/moved   /elsewhere 302
+
+/removed 410
+/external https://example.com/there 307
+
+# Prefixes.
+/blog/* /news/* 308
+/blog/2010/* /archive/2010
+/drafts/* /
+
+# Regular expressions.
+~^/(\d{4})/(\d{2})/(?P<slug>[^/]+)\.html$ /news/$1/$2/${slug}
+`
+
+func match(t *testing.T, rs *Rules, path string) (string, int, bool) {
+	u, err := url.Parse(path)
+	assert.NoError(t, err)
+	return rs.Match(u)
+}
+
+func TestMatch(t *testing.T) {
+	rs, errs := Parse(strings.NewReader(rules))
+	assert.Empty(t, errs)
+	assert.Equal(t, 8, rs.Len())
+
+	testCases := []struct {
+		path   string
+		dest   string
+		status int
+		ok     bool
+	}{
+		{"/old/page", "/new/page", 301, true},
+		{"/old/page?a=1&b=2", "/new/page?a=1&b=2", 301, true},
+		{"/old/page/", "", 0, false},
+		{"/moved", "/elsewhere", 302, true},
+		{"/removed", "", 410, true},
+		{"/removed?x=1", "", 410, true},
+		{"/external", "https://example.com/there", 307, true},
+		{"/blog/2017/post", "/news/2017/post", 308, true},
+		{"/blog/2010/post", "/archive/2010", 301, true},
+		{"/blog", "", 0, false},
+		{"/drafts/thing", "/", 301, true},
+		{"/2018/01/hello.html", "/news/2018/01/hello", 301, true},
+		{"/2018/01/hello.html?q=1", "/news/2018/01/hello?q=1", 301, true},
+		{"/2018/1/hello.html", "", 0, false},
+		{"/unknown", "", 0, false},
+	}
+	for _, tc := range testCases {
+		dest, status, ok := match(t, rs, tc.path)
+		assert.Equal(t, tc.ok, ok, tc.path)
+		assert.Equal(t, tc.dest, dest, tc.path)
+		assert.Equal(t, tc.status, status, tc.path)
+	}
+}
+
+func TestMatchQueryMerge(t *testing.T) {
+	rs, errs := Parse(strings.NewReader("/search /find?src=old\n"))
+	assert.Empty(t, errs)
+	dest, _, ok := match(t, rs, "/search?q=go")
+	assert.True(t, ok)
+	assert.Equal(t, "/find?src=old&q=go", dest)
+}
+
+func TestParseErrors(t *testing.T) {
+	rs, errs := Parse(strings.NewReader(`/ok /fine
+/too /many /fields 301
+/bad-status /there 200
+/gone-with-dest /there 410
+/no-dest 301
+relative /there
+/to relative
+~[ /broken
+/star /not-prefix*
+`))
+	assert.Equal(t, 1, rs.Len())
+	assert.Len(t, errs, 8)
+	assert.Contains(t, errs[0].Error(), "line 2:")
+	assert.Contains(t, errs[7].Error(), "line 9:")
+}
+
+func TestNilRules(t *testing.T) {
+	var rs *Rules
+	assert.Equal(t, 0, rs.Len())
+	_, _, ok := match(t, rs, "/anything")
+	assert.False(t, ok)
+}
+
+func TestCheck(t *testing.T) {
+	rs, errs := Parse(strings.NewReader(`/a /b
+/b /c 302
+/c 410
+/a /elsewhere
+/loop1 /loop2
+/loop2 /loop1
+/self/* /self/more/*
+/fine /https
+`))
+	assert.Empty(t, errs)
+	problems := rs.Check()
+	assert.Equal(t, []string{
+		"Conflict: line 4: /a /elsewhere 301 has the same source as line 1: /a /b 301, only the first is used.",
+		"Chain: (line 1: /a /b 301) -> (line 2: /b /c 302) -> (line 3: /c 410)",
+		"Chain: (line 2: /b /c 302) -> (line 3: /c 410)",
+		"Loop: (line 5: /loop1 /loop2 301) -> (line 6: /loop2 /loop1 301) -> (line 5: /loop1 /loop2 301)",
+		"Loop: (line 6: /loop2 /loop1 301) -> (line 5: /loop1 /loop2 301) -> (line 6: /loop2 /loop1 301)",
+		"Loop: (line 7: /self/* /self/more/* 301) -> (line 7: /self/* /self/more/* 301)",
+	}, problems)
+}
+
+func TestDiff(t *testing.T) {
+	a, errs := Parse(strings.NewReader("/a /b\n/c 410\n/d/* /e/*\n"))
+	assert.Empty(t, errs)
+	b, errs := Parse(strings.NewReader("# Moved things around.\n/d/* /e/*\n/a /b 302\n/c 410\n/f /g\n"))
+	assert.Empty(t, errs)
+
+	added, removed := Diff(a, b)
+	assert.Equal(t, []string{"/a /b 302", "/f /g 301"}, added)
+	assert.Equal(t, []string{"/a /b 301"}, removed)
+
+	added, removed = Diff(nil, a)
+	assert.Len(t, added, 3)
+	assert.Empty(t, removed)
+}
@@ -0,0 +1,32 @@
+// redirectcheck validates a redirect rules file, reporting rules that can't
+// be parsed, conflicting rules, and chains and loops of redirects.
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/jcgregorio/userve/go/redirect"
+)
+
+func main() {
+	if len(os.Args) != 2 {
+		log.Fatal("Usage: redirectcheck <redirect_file>")
+	}
+	rules, errs := redirect.Load(os.Args[1])
+	for _, err := range errs {
+		fmt.Printf("Error: %s\n", err)
+	}
+	if rules == nil {
+		os.Exit(1)
+	}
+	problems := rules.Check()
+	for _, p := range problems {
+		fmt.Println(p)
+	}
+	fmt.Printf("%d rules, %d errors, %d problems.\n", rules.Len(), len(errs), len(problems))
+	if len(errs) > 0 || len(problems) > 0 {
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,182 @@
+package referrers
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru"
+)
+
+// AGGREGATOR_SHARDS is the number of independently locked shards of an
+// Aggregator, so concurrent requests for different paths rarely wait on
+// each other.
+const AGGREGATOR_SHARDS = 16
+
+// MAX_PENDING is the most counts an Aggregator keeps for retrying when
+// writing them to the Store fails.
+const MAX_PENDING = 100000
+
+// bufferKey identifies the hits buffered for a path in a single hour.
+type bufferKey struct {
+	path string
+	hour int64
+}
+
+// shard is the part of an Aggregator that holds some of the paths.
+type shard struct {
+	// mutex protects cache and pending.
+	mutex sync.Mutex
+
+	// cache holds the hits by referrer, a map[string]int64, for each
+	// bufferKey.
+	cache *lru.Cache
+
+	// pending are counts evicted from cache, or that failed to be written,
+	// waiting to be written.
+	pending []*Count
+}
+
+// Aggregator counts hits by path and referrer in memory, safe for
+// concurrent use, until they are written to a Store with Flush. Only the
+// most recently used paths are kept, the others are moved aside to be
+// written with the next Flush.
+type Aggregator struct {
+	shards [AGGREGATOR_SHARDS]*shard
+	size   int
+}
+
+// NewAggregator returns an Aggregator that buffers up to about size paths
+// per hour.
+func NewAggregator(size int) (*Aggregator, error) {
+	a := &Aggregator{
+		size: size/AGGREGATOR_SHARDS + 1,
+	}
+	for i := range a.shards {
+		cache, err := lru.New(a.size)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to create referrer cache: %s", err)
+		}
+		a.shards[i] = &shard{
+			cache: cache,
+		}
+	}
+	return a, nil
+}
+
+// shardFor returns the shard that holds path.
+func (a *Aggregator) shardFor(path string) *shard {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(path))
+	return a.shards[h.Sum32()%AGGREGATOR_SHARDS]
+}
+
+// toCounts converts the hits buffered for key into hourly and daily counts.
+func toCounts(key bufferKey, hits map[string]int64) []*Count {
+	hour := time.Unix(key.hour, 0).UTC()
+	ret := []*Count{}
+	for referrer, n := range hits {
+		for _, g := range Granularities {
+			ret = append(ret, &Count{
+				Path:        key.path,
+				Referrer:    referrer,
+				Granularity: g,
+				Start:       Start(g, hour),
+				Hits:        n,
+			})
+		}
+	}
+	return ret
+}
+
+// Add counts a hit on path from referrer at time t.
+func (a *Aggregator) Add(path, referrer string, t time.Time) {
+	key := bufferKey{
+		path: path,
+		hour: Start(HOURLY, t).Unix(),
+	}
+	s := a.shardFor(path)
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	var hits map[string]int64
+	if v, ok := s.cache.Get(key); ok {
+		hits = v.(map[string]int64)
+	} else {
+		if s.cache.Len() >= a.size {
+			if k, v, ok := s.cache.RemoveOldest(); ok {
+				s.pending = append(s.pending, toCounts(k.(bufferKey), v.(map[string]int64))...)
+			}
+		}
+		hits = map[string]int64{}
+		s.cache.Add(key, hits)
+	}
+	hits[referrer]++
+}
+
+// counts returns the counts buffered in s, removing them if take is true.
+func (s *shard) counts(take bool) []*Count {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	ret := make([]*Count, 0, len(s.pending))
+	for _, c := range s.pending {
+		copied := *c
+		ret = append(ret, &copied)
+	}
+	for _, k := range s.cache.Keys() {
+		if v, ok := s.cache.Peek(k); ok {
+			ret = append(ret, toCounts(k.(bufferKey), v.(map[string]int64))...)
+		}
+	}
+	if take {
+		s.cache.Purge()
+		s.pending = nil
+	}
+	return ret
+}
+
+// Counts returns copies of all the counts not yet written to a Store.
+func (a *Aggregator) Counts() []*Count {
+	ret := []*Count{}
+	for _, s := range a.shards {
+		ret = append(ret, s.counts(false)...)
+	}
+	return ret
+}
+
+// Flush writes all the buffered counts to store. If that fails those not
+// written are kept to be written by the next Flush, unless there are already
+// MAX_PENDING waiting, in which case they are dropped.
+func (a *Aggregator) Flush(ctx context.Context, store Store) error {
+	counts := []*Count{}
+	for _, s := range a.shards {
+		counts = append(counts, s.counts(true)...)
+	}
+	if len(counts) == 0 {
+		return nil
+	}
+	err := store.Add(ctx, counts)
+	if err == nil {
+		return nil
+	}
+	if uerr, ok := err.(*UnwrittenError); ok {
+		counts = uerr.Unwritten
+	}
+	pending := 0
+	for _, s := range a.shards {
+		s.mutex.Lock()
+		pending += len(s.pending)
+		s.mutex.Unlock()
+	}
+	if pending+len(counts) > MAX_PENDING {
+		return fmt.Errorf("Dropped %d counts, too many are pending: %s", len(counts), err)
+	}
+	for _, c := range counts {
+		s := a.shardFor(c.Path)
+		s.mutex.Lock()
+		s.pending = append(s.pending, c)
+		s.mutex.Unlock()
+	}
+	return fmt.Errorf("Failed to write %d counts, will retry: %s", len(counts), err)
+}
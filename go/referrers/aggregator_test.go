@@ -0,0 +1,135 @@
+package referrers
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// failingStore is a Store whose Add fails.
+type failingStore struct {
+	Store
+}
+
+func (f failingStore) Add(ctx context.Context, counts []*Count) error {
+	return fmt.Errorf("Failed")
+}
+
+// partialStore is a Store whose Add only writes the first count.
+type partialStore struct {
+	Store
+}
+
+func (p partialStore) Add(ctx context.Context, counts []*Count) error {
+	if err := p.Store.Add(ctx, counts[:1]); err != nil {
+		return err
+	}
+	return &UnwrittenError{Unwritten: counts[1:], Err: fmt.Errorf("Failed")}
+}
+
+// total returns the sum of the hits of counts of the given granularity.
+func total(counts []*Count, granularity string) int64 {
+	var ret int64
+	for _, c := range counts {
+		if c.Granularity == granularity {
+			ret += c.Hits
+		}
+	}
+	return ret
+}
+
+func TestAggregator(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2018, 3, 4, 15, 16, 0, 0, time.UTC)
+	a, err := NewAggregator(AGGREGATOR_SHARDS)
+	assert.NoError(t, err)
+	a.Add("/a", "https://example.com/", now)
+	a.Add("/a", "https://example.com/", now.Add(time.Minute))
+	a.Add("/a", "∅", now.Add(time.Hour))
+	a.Add("/b", "∅", now)
+
+	counts := a.Counts()
+	assert.Equal(t, int64(4), total(counts, HOURLY))
+	assert.Equal(t, int64(4), total(counts, DAILY))
+	// Counts returns copies.
+	counts[0].Hits = 100
+	assert.Equal(t, int64(4), total(a.Counts(), HOURLY))
+
+	// Paths beyond the size of the cache are still counted.
+	for i := 0; i < 10*AGGREGATOR_SHARDS; i++ {
+		a.Add(fmt.Sprintf("/p%d", i), "∅", now)
+	}
+	assert.Equal(t, int64(4+10*AGGREGATOR_SHARDS), total(a.Counts(), HOURLY))
+
+	// Failed writes are retried.
+	assert.Error(t, a.Flush(ctx, failingStore{}))
+	assert.Equal(t, int64(4+10*AGGREGATOR_SHARDS), total(a.Counts(), HOURLY))
+
+	s := NewMemoryStore()
+	assert.NoError(t, a.Flush(ctx, s))
+	assert.Empty(t, a.Counts())
+	stored, err := s.Get(ctx, HOURLY, now.Add(-time.Hour), now.Add(2*time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4+10*AGGREGATOR_SHARDS), total(stored, HOURLY))
+	stored, err = s.Get(ctx, HOURLY, Start(HOURLY, now), Start(HOURLY, now).Add(time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3+10*AGGREGATOR_SHARDS), total(stored, HOURLY))
+	stored, err = s.Get(ctx, DAILY, Start(DAILY, now), Start(DAILY, now).Add(24*time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4+10*AGGREGATOR_SHARDS), total(stored, DAILY))
+}
+
+func TestAggregatorFlushPartial(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2018, 3, 4, 15, 16, 0, 0, time.UTC)
+	a, err := NewAggregator(AGGREGATOR_SHARDS)
+	assert.NoError(t, err)
+	a.Add("/a", "∅", now)
+	a.Add("/b", "∅", now)
+
+	// Only the counts that weren't written are retried.
+	s := NewMemoryStore()
+	assert.Error(t, a.Flush(ctx, partialStore{s}))
+	assert.Len(t, a.Counts(), 3)
+	assert.NoError(t, a.Flush(ctx, s))
+	assert.Empty(t, a.Counts())
+	stored, err := s.Get(ctx, HOURLY, Start(HOURLY, now), Start(HOURLY, now).Add(time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), total(stored, HOURLY))
+	stored, err = s.Get(ctx, DAILY, Start(DAILY, now), Start(DAILY, now).Add(24*time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), total(stored, DAILY))
+}
+
+func TestAggregatorConcurrent(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	a, err := NewAggregator(4 * AGGREGATOR_SHARDS)
+	assert.NoError(t, err)
+	s := NewMemoryStore()
+
+	const goroutines, hits = 20, 500
+	var wg sync.WaitGroup
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < hits; j++ {
+				a.Add(fmt.Sprintf("/p%d", j%100), fmt.Sprintf("https://r%d.example.com/", i%3), now)
+				if j%100 == 0 {
+					_ = a.Counts()
+					assert.NoError(t, a.Flush(ctx, s))
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	assert.NoError(t, a.Flush(ctx, s))
+	stored, err := s.Get(ctx, HOURLY, Start(HOURLY, now), Start(HOURLY, now).Add(time.Hour))
+	assert.NoError(t, err)
+	assert.Equal(t, int64(goroutines*hits), total(stored, HOURLY))
+}
@@ -0,0 +1,129 @@
+package referrers
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// DefaultBlocklist are well known referrer spam domains, always blocked in
+// addition to those in a blocklist file.
+var DefaultBlocklist = []string{
+	"7makemoneyonline.com",
+	"best-seo-offer.com",
+	"buttons-for-website.com",
+	"buttons-for-your-website.com",
+	"darodar.com",
+	"econom.co",
+	"get-free-traffic-now.com",
+	"hulfingtonpost.com",
+	"ilovevitaly.com",
+	"priceg.com",
+	"semalt.com",
+	"simple-share-buttons.com",
+	"social-buttons.com",
+}
+
+// Blocklist decides which referrers are spam and shouldn't be counted.
+//
+// A blocklist file has one pattern per line, blank lines and lines
+// starting with # are ignored. A pattern is either a domain, which blocks
+// referrers from it and all its subdomains, or a regular expression
+// prefixed with ~, which blocks referrers that it matches after they are
+// normalized, see Normalize. For example:
+//
+//	# Spam.
+//	free-traffic.example.com
+//	~^https?://[^/]*seo[^/]*/
+type Blocklist struct {
+	// Len is the number of patterns, not counting DefaultBlocklist.
+	Len int
+
+	domains  map[string]bool
+	patterns []*regexp.Regexp
+}
+
+// newBlocklist returns a Blocklist with just the DefaultBlocklist.
+func newBlocklist() *Blocklist {
+	b := &Blocklist{
+		domains: map[string]bool{},
+	}
+	for _, d := range DefaultBlocklist {
+		b.domains[d] = true
+	}
+	return b
+}
+
+// ParseBlocklist parses a blocklist file. Lines that can't be parsed are
+// skipped and returned as errors, so a mistake in one pattern doesn't stop
+// the rest from working.
+func ParseBlocklist(r io.Reader) (*Blocklist, []error) {
+	b := newBlocklist()
+	errs := []error{}
+	scanner := bufio.NewScanner(r)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum += 1
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "~") {
+			re, err := regexp.Compile(line[1:])
+			if err != nil {
+				errs = append(errs, fmt.Errorf("line %d: Invalid regular expression %q: %s", lineNum, line[1:], err))
+				continue
+			}
+			b.patterns = append(b.patterns, re)
+		} else {
+			if strings.ContainsAny(line, "/ \t:") {
+				errs = append(errs, fmt.Errorf("line %d: Not a domain: %q", lineNum, line))
+				continue
+			}
+			b.domains[strings.TrimPrefix(strings.ToLower(line), "www.")] = true
+		}
+		b.Len++
+	}
+	if err := scanner.Err(); err != nil {
+		errs = append(errs, fmt.Errorf("Failed to read blocklist: %s", err))
+	}
+	return b, errs
+}
+
+// LoadBlocklist reads and parses the blocklist file at filename, see
+// ParseBlocklist. If filename is empty only DefaultBlocklist is blocked.
+func LoadBlocklist(filename string) (*Blocklist, []error) {
+	if filename == "" {
+		return newBlocklist(), nil
+	}
+	f, err := os.Open(filename)
+	if err != nil {
+		return newBlocklist(), []error{fmt.Errorf("Failed to read blocklist file: %s", err)}
+	}
+	defer f.Close()
+	return ParseBlocklist(f)
+}
+
+// Blocked returns true if the referrer is spam. A nil Blocklist blocks only
+// DefaultBlocklist.
+func (b *Blocklist) Blocked(referrer string) bool {
+	if b == nil {
+		b = newBlocklist()
+	}
+	if referrer == "" || referrer == NO_REFERRER {
+		return false
+	}
+	if matchesDomain(b.domains, Domain(referrer)) {
+		return true
+	}
+	normalized := Normalize(referrer)
+	for _, re := range b.patterns {
+		if re.MatchString(normalized) {
+			return true
+		}
+	}
+	return false
+}
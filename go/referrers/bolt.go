@@ -0,0 +1,148 @@
+package referrers
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// BUCKET_TIME_FORMAT is the sortable form of Count.Start at the start of
+// BoltDB keys.
+const BUCKET_TIME_FORMAT = "2006-01-02T15"
+
+// boltBucket returns the name of the BoltDB bucket for the granularity.
+func boltBucket(granularity string) []byte {
+	return []byte("Referrers/" + granularity)
+}
+
+// boltStore implements Store using an embedded BoltDB file, shared with the
+// mentions. Each granularity has its own bucket, keyed by the start of the
+// bucket, path, and referrer, separated by NULs, so a time range is a range
+// of keys. The values are the hits as big endian uint64s.
+type boltStore struct {
+	db *bolt.DB
+}
+
+// NewBoltStore returns a Store backed by the BoltDB database db.
+func NewBoltStore(db *bolt.DB) (Store, error) {
+	err := db.Update(func(tx *bolt.Tx) error {
+		for _, g := range Granularities {
+			if _, err := tx.CreateBucketIfNotExists(boltBucket(g)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Failed to create buckets: %s", err)
+	}
+	return &boltStore{db: db}, nil
+}
+
+// timePrefix returns the start of the keys of the bucket that begins at t.
+func timePrefix(t time.Time) []byte {
+	return []byte(t.UTC().Format(BUCKET_TIME_FORMAT) + "\x00")
+}
+
+func boltKey(c *Count) []byte {
+	return append(timePrefix(c.Start), []byte(c.Path+"\x00"+c.Referrer)...)
+}
+
+func (b *boltStore) Add(ctx context.Context, counts []*Count) error {
+	for _, c := range counts {
+		if err := checkGranularity(c.Granularity); err != nil {
+			return err
+		}
+	}
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		for _, c := range counts {
+			bucket := tx.Bucket(boltBucket(c.Granularity))
+			key := boltKey(c)
+			var hits uint64
+			if v := bucket.Get(key); len(v) == 8 {
+				hits = binary.BigEndian.Uint64(v)
+			}
+			buf := make([]byte, 8)
+			binary.BigEndian.PutUint64(buf, hits+uint64(c.Hits))
+			if err := bucket.Put(key, buf); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("Failed writing referrer counts: %s", err)
+	}
+	return nil
+}
+
+func (b *boltStore) Get(ctx context.Context, granularity string, begin, end time.Time) ([]*Count, error) {
+	if err := checkGranularity(granularity); err != nil {
+		return nil, err
+	}
+	ret := []*Count{}
+	err := b.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket(boltBucket(granularity)).Cursor()
+		// Keys only have the time to the hour, so the exact range is checked
+		// against the parsed Start.
+		for k, v := c.Seek(timePrefix(begin)); k != nil; k, v = c.Next() {
+			parts := strings.SplitN(string(k), "\x00", 3)
+			if len(parts) != 3 || len(v) != 8 {
+				return fmt.Errorf("Invalid key %q", string(k))
+			}
+			start, err := time.Parse(BUCKET_TIME_FORMAT, parts[0])
+			if err != nil {
+				return fmt.Errorf("Invalid key %q: %s", string(k), err)
+			}
+			if !start.Before(end) {
+				break
+			}
+			if start.Before(begin) {
+				continue
+			}
+			ret = append(ret, &Count{
+				Path:        parts[1],
+				Referrer:    parts[2],
+				Granularity: granularity,
+				Start:       start,
+				Hits:        int64(binary.BigEndian.Uint64(v)),
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("Failed reading referrer counts: %s", err)
+	}
+	return ret, nil
+}
+
+func (b *boltStore) Prune(ctx context.Context, granularity string, before time.Time) error {
+	if err := checkGranularity(granularity); err != nil {
+		return err
+	}
+	// Keys only have the time to the hour, and every Start is on the hour,
+	// so those before the first hour not before the given time are pruned.
+	cutoff := before.UTC().Truncate(time.Hour)
+	if cutoff.Before(before) {
+		cutoff = cutoff.Add(time.Hour)
+	}
+	last := timePrefix(cutoff)
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		c := tx.Bucket(boltBucket(granularity)).Cursor()
+		for k, _ := c.First(); k != nil && bytes.Compare(k, last) < 0; k, _ = c.First() {
+			if err := c.Delete(); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("Failed pruning referrer counts: %s", err)
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+package referrers
+
+import (
+	"context"
+	"crypto/md5"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/datastore"
+	"github.com/jcgregorio/userve/go/dsbatch"
+	"go.skia.org/infra/go/ds"
+	"google.golang.org/api/iterator"
+)
+
+const (
+	REFERRERS_HOURLY ds.Kind = "ReferrersHourly"
+	REFERRERS_DAILY  ds.Kind = "ReferrersDaily"
+)
+
+// kinds maps granularities to their Datastore kinds. Keeping each in its own
+// kind means time range queries only need the built in index on Start.
+var kinds = map[string]ds.Kind{
+	HOURLY: REFERRERS_HOURLY,
+	DAILY:  REFERRERS_DAILY,
+}
+
+// datastoreStore implements Store using Google Cloud Datastore.
+type datastoreStore struct{}
+
+// NewDatastoreStore returns a Store backed by Google Cloud Datastore.
+//
+// ds.Init must be called before using the returned Store.
+func NewDatastoreStore() Store {
+	return &datastoreStore{}
+}
+
+// newKey returns the key of the entity for c, named by a hash of its path,
+// referrer, and start, since paths and referrers can be longer than key
+// names are allowed to be.
+func newKey(c *Count) *datastore.Key {
+	key := ds.NewKey(kinds[c.Granularity])
+	key.Name = fmt.Sprintf("%x", md5.Sum([]byte(fmt.Sprintf("%d\x00%s\x00%s", c.Start.Unix(), c.Path, c.Referrer))))
+	return key
+}
+
+func (d *datastoreStore) Add(ctx context.Context, counts []*Count) error {
+	for _, c := range counts {
+		if err := checkGranularity(c.Granularity); err != nil {
+			return err
+		}
+	}
+	// A transaction reads each entity once, so Counts for the same entity
+	// have to be added together first or all but the last would be lost.
+	counts = merge(counts)
+	keys := make([]*datastore.Key, len(counts))
+	for i, c := range counts {
+		keys[i] = newKey(c)
+	}
+	written, err := dsbatch.Update(ctx, len(counts), func(tx *datastore.Transaction, begin, end int) error {
+		stored := make([]Count, end-begin)
+		if err := dsbatch.GetMulti(tx, keys[begin:end], stored); err != nil {
+			return err
+		}
+		for i, c := range counts[begin:end] {
+			hits := stored[i].Hits
+			stored[i] = *c
+			stored[i].Start = c.Start.UTC()
+			stored[i].Hits += hits
+		}
+		_, err := tx.PutMulti(keys[begin:end], stored)
+		return err
+	})
+	if err != nil {
+		return &UnwrittenError{
+			Unwritten: counts[written:],
+			Err:       fmt.Errorf("Failed writing referrer counts: %s", err),
+		}
+	}
+	return nil
+}
+
+func (d *datastoreStore) Get(ctx context.Context, granularity string, begin, end time.Time) ([]*Count, error) {
+	if err := checkGranularity(granularity); err != nil {
+		return nil, err
+	}
+	ret := []*Count{}
+	q := ds.NewQuery(kinds[granularity]).
+		Filter("Start >=", begin.UTC()).
+		Filter("Start <", end.UTC()).
+		Order("Start")
+	it := ds.DS.Run(ctx, q)
+	for {
+		c := &Count{}
+		_, err := it.Next(c)
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return ret, fmt.Errorf("Failed while reading: %s", err)
+		}
+		c.Start = c.Start.UTC()
+		ret = append(ret, c)
+	}
+	return ret, nil
+}
+
+func (d *datastoreStore) Prune(ctx context.Context, granularity string, before time.Time) error {
+	if err := checkGranularity(granularity); err != nil {
+		return err
+	}
+	q := ds.NewQuery(kinds[granularity]).
+		Filter("Start <", before.UTC()).
+		KeysOnly()
+	keys, err := ds.DS.GetAll(ctx, q, nil)
+	if err != nil {
+		return fmt.Errorf("Failed to find referrer counts to prune: %s", err)
+	}
+	for len(keys) > 0 {
+		n := len(keys)
+		if n > dsbatch.MAX_BATCH {
+			n = dsbatch.MAX_BATCH
+		}
+		if err := ds.DS.DeleteMulti(ctx, keys[:n]); err != nil {
+			return fmt.Errorf("Failed pruning referrer counts: %s", err)
+		}
+		keys = keys[n:]
+	}
+	return nil
+}
@@ -0,0 +1,75 @@
+package referrers
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// memoryStore implements Store entirely in memory, for tests and for running
+// locally without any external services.
+type memoryStore struct {
+	mutex  sync.Mutex
+	counts map[countKey]int64
+}
+
+// NewMemoryStore returns a Store that keeps everything in memory.
+func NewMemoryStore() Store {
+	return &memoryStore{
+		counts: map[countKey]int64{},
+	}
+}
+
+func (s *memoryStore) Add(ctx context.Context, counts []*Count) error {
+	for _, c := range counts {
+		if err := checkGranularity(c.Granularity); err != nil {
+			return err
+		}
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, c := range counts {
+		s.counts[c.key()] += c.Hits
+	}
+	return nil
+}
+
+func (s *memoryStore) Get(ctx context.Context, granularity string, begin, end time.Time) ([]*Count, error) {
+	if err := checkGranularity(granularity); err != nil {
+		return nil, err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	ret := []*Count{}
+	for k, hits := range s.counts {
+		if k.granularity != granularity || k.start < begin.Unix() || k.start >= end.Unix() {
+			continue
+		}
+		ret = append(ret, &Count{
+			Path:        k.path,
+			Referrer:    k.referrer,
+			Granularity: k.granularity,
+			Start:       time.Unix(k.start, 0).UTC(),
+			Hits:        hits,
+		})
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].Start.Before(ret[j].Start)
+	})
+	return ret, nil
+}
+
+func (s *memoryStore) Prune(ctx context.Context, granularity string, before time.Time) error {
+	if err := checkGranularity(granularity); err != nil {
+		return err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for k := range s.counts {
+		if k.granularity == granularity && k.start < before.Unix() {
+			delete(s.counts, k)
+		}
+	}
+	return nil
+}
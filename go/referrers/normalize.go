@@ -0,0 +1,126 @@
+package referrers
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// Categories of referrers, see Category.
+const (
+	DIRECT_CATEGORY = "direct"
+	SEARCH_CATEGORY = "search"
+	SOCIAL_CATEGORY = "social"
+	OTHER_CATEGORY  = "other"
+)
+
+// keptParams are the query parameters that identify the page on sites where
+// the path alone doesn't, by domain as returned by Domain. All other query
+// parameters, such as utm_source and fbclid, are dropped.
+var keptParams = map[string][]string{
+	"news.ycombinator.com": {"id"},
+	"youtube.com":          {"v"},
+	"m.youtube.com":        {"v"},
+}
+
+// searchDomains and socialDomains are matched against the domain of a
+// referrer and the domains it is a subdomain of.
+var (
+	searchDomains = map[string]bool{
+		"bing.com":         true,
+		"duckduckgo.com":   true,
+		"search.yahoo.com": true,
+		"baidu.com":        true,
+		"ecosia.org":       true,
+		"kagi.com":         true,
+		"search.brave.com": true,
+		"startpage.com":    true,
+		"qwant.com":        true,
+	}
+	socialDomains = map[string]bool{
+		"facebook.com":         true,
+		"t.co":                 true,
+		"twitter.com":          true,
+		"x.com":                true,
+		"linkedin.com":         true,
+		"lnkd.in":              true,
+		"reddit.com":           true,
+		"news.ycombinator.com": true,
+		"lobste.rs":            true,
+		"bsky.app":             true,
+		"mastodon.social":      true,
+		"instagram.com":        true,
+		"youtube.com":          true,
+		"pinterest.com":        true,
+		"tumblr.com":           true,
+	}
+
+	// searchEngines matches search engines that use many country domains,
+	// such as google.co.uk.
+	searchEngines = regexp.MustCompile(`(^|\.)(google|yandex)(\.[a-z]{2,3}){1,2}$`)
+)
+
+// Normalize returns the referrer reduced to its origin and path, with the
+// host in lower case and without a default port. The fragment and tracking
+// parameters are dropped, keeping only the query parameters that identify
+// the page on a few well known sites. Referrers that aren't http or https
+// URLs are returned unchanged.
+func Normalize(referrer string) string {
+	u, err := url.Parse(strings.TrimSpace(referrer))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return referrer
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	host := strings.ToLower(u.Hostname())
+	if port := u.Port(); port != "" && !(u.Scheme == "http" && port == "80") && !(u.Scheme == "https" && port == "443") {
+		host += ":" + port
+	}
+	u.Host = host
+	u.User = nil
+	u.Fragment = ""
+	u.RawFragment = ""
+	if u.Path == "" {
+		u.Path = "/"
+	}
+	query := url.Values{}
+	for _, name := range keptParams[Domain(referrer)] {
+		if v, ok := u.Query()[name]; ok {
+			query[name] = v
+		}
+	}
+	u.RawQuery = query.Encode()
+	return u.String()
+}
+
+// matchesDomain returns true if domain is in domains, or is a subdomain of
+// one that is.
+func matchesDomain(domains map[string]bool, domain string) bool {
+	for d := domain; d != ""; {
+		if domains[d] {
+			return true
+		}
+		i := strings.Index(d, ".")
+		if i < 0 {
+			break
+		}
+		d = d[i+1:]
+	}
+	return false
+}
+
+// Category returns the category of a referrer, one of DIRECT_CATEGORY for
+// requests without one, SEARCH_CATEGORY, SOCIAL_CATEGORY, or
+// OTHER_CATEGORY.
+func Category(referrer string) string {
+	if referrer == "" || referrer == NO_REFERRER {
+		return DIRECT_CATEGORY
+	}
+	domain := Domain(referrer)
+	switch {
+	case searchEngines.MatchString(domain) || matchesDomain(searchDomains, domain):
+		return SEARCH_CATEGORY
+	case matchesDomain(socialDomains, domain):
+		return SOCIAL_CATEGORY
+	}
+	return OTHER_CATEGORY
+}
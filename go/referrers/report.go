@@ -0,0 +1,189 @@
+package referrers
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Series is the hits for a path, referrer domain, or everything, in each
+// bucket of a Report.
+type Series struct {
+	// Name is the path or referrer domain.
+	Name string
+
+	// Hits has the hits in each of Report.Buckets.
+	Hits []int64
+
+	// Total is the sum of Hits.
+	Total int64
+
+	// Previous is the total in the period of the same length just before the
+	// Report.
+	Previous int64
+
+	// Referrers are the hits by referrer, only for paths.
+	Referrers map[string]int64
+}
+
+// Change is how much Total has changed from Previous.
+func (s *Series) Change() int64 {
+	return s.Total - s.Previous
+}
+
+// Report summarizes the Counts of a time range.
+type Report struct {
+	Granularity string
+	Begin       time.Time
+	End         time.Time
+
+	// Buckets are the starts of the buckets in [Begin, End).
+	Buckets []time.Time
+
+	// All is the series for all paths.
+	All *Series
+
+	// Paths and Domains are the series of each path and referrer domain,
+	// most hits first.
+	Paths   []*Series
+	Domains []*Series
+
+	// Categories are the series of each referrer category, see Category.
+	Categories []*Series
+
+	// PathMovers and DomainMovers are the paths and referrer domains whose
+	// hits changed the most from the previous period, up or down, including
+	// those with no hits at all in this one.
+	PathMovers   []*Series
+	DomainMovers []*Series
+}
+
+// MAX_MOVERS is the most paths or domains in Report.PathMovers and
+// Report.DomainMovers.
+const MAX_MOVERS = 10
+
+// Domain returns the domain of a referrer, without any "www.", or the
+// referrer itself if it isn't a URL.
+func Domain(referrer string) string {
+	u, err := url.Parse(referrer)
+	if err != nil || u.Host == "" {
+		return referrer
+	}
+	return strings.TrimPrefix(strings.ToLower(u.Hostname()), "www.")
+}
+
+// Previous returns the period of the same length just before [begin, end).
+func Previous(begin, end time.Time) (time.Time, time.Time) {
+	return begin.Add(-end.Sub(begin)), begin
+}
+
+// NewReport summarizes counts, of the given granularity and in [begin, end),
+// along with previous, the counts in the period returned by Previous, so
+// that changes can be found.
+func NewReport(counts, previous []*Count, granularity string, begin, end time.Time) *Report {
+	step := Step(granularity)
+	begin = Start(granularity, begin)
+	r := &Report{
+		Granularity: granularity,
+		Begin:       begin,
+		End:         end,
+		Buckets:     []time.Time{},
+	}
+	for t := begin; t.Before(end); t = t.Add(step) {
+		r.Buckets = append(r.Buckets, t)
+	}
+	newSeries := func(name string) *Series {
+		return &Series{
+			Name: name,
+			Hits: make([]int64, len(r.Buckets)),
+		}
+	}
+	r.All = newSeries("")
+	paths := map[string]*Series{}
+	domains := map[string]*Series{}
+	categories := map[string]*Series{}
+	get := func(m map[string]*Series, name string) *Series {
+		s, ok := m[name]
+		if !ok {
+			s = newSeries(name)
+			m[name] = s
+		}
+		return s
+	}
+	for _, c := range counts {
+		i := int(c.Start.Sub(begin) / step)
+		if c.Granularity != granularity || i < 0 || i >= len(r.Buckets) {
+			continue
+		}
+		p := get(paths, c.Path)
+		if p.Referrers == nil {
+			p.Referrers = map[string]int64{}
+		}
+		p.Referrers[c.Referrer] += c.Hits
+		for _, s := range []*Series{r.All, p, get(domains, Domain(c.Referrer)), get(categories, Category(c.Referrer))} {
+			s.Hits[i] += c.Hits
+			s.Total += c.Hits
+		}
+	}
+	prevBegin, prevEnd := Previous(begin, end)
+	for _, c := range previous {
+		if c.Granularity != granularity || c.Start.Before(prevBegin) || !c.Start.Before(prevEnd) {
+			continue
+		}
+		for _, s := range []*Series{r.All, get(paths, c.Path), get(domains, Domain(c.Referrer)), get(categories, Category(c.Referrer))} {
+			s.Previous += c.Hits
+		}
+	}
+	r.Paths = sortedSeries(paths)
+	r.Domains = sortedSeries(domains)
+	r.Categories = sortedSeries(categories)
+	r.PathMovers = movers(paths, MAX_MOVERS)
+	r.DomainMovers = movers(domains, MAX_MOVERS)
+	return r
+}
+
+// sortedSeries returns the series in m that had hits in the report period,
+// most hits first.
+func sortedSeries(m map[string]*Series) []*Series {
+	ret := []*Series{}
+	for _, s := range m {
+		if s.Total > 0 {
+			ret = append(ret, s)
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Total != ret[j].Total {
+			return ret[i].Total > ret[j].Total
+		}
+		return ret[i].Name < ret[j].Name
+	})
+	return ret
+}
+
+// movers returns at most n of the series in m whose hits changed the most,
+// biggest change first.
+func movers(m map[string]*Series, n int) []*Series {
+	ret := []*Series{}
+	for _, s := range m {
+		if s.Change() != 0 {
+			ret = append(ret, s)
+		}
+	}
+	abs := func(x int64) int64 {
+		if x < 0 {
+			return -x
+		}
+		return x
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if abs(ret[i].Change()) != abs(ret[j].Change()) {
+			return abs(ret[i].Change()) > abs(ret[j].Change())
+		}
+		return ret[i].Name < ret[j].Name
+	})
+	if len(ret) > n {
+		ret = ret[:n]
+	}
+	return ret
+}
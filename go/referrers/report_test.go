@@ -0,0 +1,80 @@
+package referrers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDomain(t *testing.T) {
+	assert.Equal(t, "news.ycombinator.com", Domain("https://news.ycombinator.com/item?id=1"))
+	assert.Equal(t, "example.com", Domain("http://WWW.Example.com:8080/"))
+	assert.Equal(t, "∅", Domain("∅"))
+	assert.Equal(t, "android-app", Domain("android-app"))
+}
+
+func TestNewReport(t *testing.T) {
+	day := time.Date(2018, 3, 4, 0, 0, 0, 0, time.UTC)
+	begin, end := day, day.AddDate(0, 0, 3)
+	prevBegin, prevEnd := Previous(begin, end)
+	assert.Equal(t, day.AddDate(0, 0, -3), prevBegin)
+	assert.Equal(t, day, prevEnd)
+
+	counts := []*Count{
+		{Path: "/a", Referrer: "https://news.ycombinator.com/item?id=1", Granularity: DAILY, Start: day, Hits: 10},
+		{Path: "/a", Referrer: "https://news.ycombinator.com/item?id=2", Granularity: DAILY, Start: day.AddDate(0, 0, 2), Hits: 5},
+		{Path: "/b", Referrer: "https://www.example.com/", Granularity: DAILY, Start: day.AddDate(0, 0, 1), Hits: 3},
+		{Path: "/b", Referrer: "∅", Granularity: DAILY, Start: day.AddDate(0, 0, 1), Hits: 1},
+		// Outside of the range, or the wrong granularity, are ignored.
+		{Path: "/a", Referrer: "∅", Granularity: DAILY, Start: end, Hits: 100},
+		{Path: "/a", Referrer: "∅", Granularity: HOURLY, Start: day, Hits: 100},
+	}
+	previous := []*Count{
+		{Path: "/a", Referrer: "https://news.ycombinator.com/item?id=0", Granularity: DAILY, Start: prevBegin, Hits: 1},
+		{Path: "/b", Referrer: "https://example.com/", Granularity: DAILY, Start: prevBegin, Hits: 5},
+		{Path: "/c", Referrer: "https://lobste.rs/", Granularity: DAILY, Start: prevBegin.AddDate(0, 0, 1), Hits: 20},
+	}
+	r := NewReport(counts, previous, DAILY, begin, end)
+	assert.Len(t, r.Buckets, 3)
+	assert.Equal(t, []int64{10, 4, 5}, r.All.Hits)
+	assert.Equal(t, int64(19), r.All.Total)
+	assert.Equal(t, int64(26), r.All.Previous)
+
+	assert.Len(t, r.Paths, 2)
+	assert.Equal(t, "/a", r.Paths[0].Name)
+	assert.Equal(t, []int64{10, 0, 5}, r.Paths[0].Hits)
+	assert.Equal(t, int64(15), r.Paths[0].Total)
+	assert.Equal(t, int64(1), r.Paths[0].Previous)
+	assert.Equal(t, int64(10), r.Paths[0].Referrers["https://news.ycombinator.com/item?id=1"])
+	assert.Equal(t, "/b", r.Paths[1].Name)
+	assert.Equal(t, int64(-1), r.Paths[1].Change())
+
+	assert.Len(t, r.Domains, 3)
+	assert.Equal(t, "news.ycombinator.com", r.Domains[0].Name)
+	assert.Equal(t, int64(15), r.Domains[0].Total)
+	assert.Equal(t, "example.com", r.Domains[1].Name)
+	assert.Equal(t, int64(3), r.Domains[1].Total)
+	assert.Equal(t, int64(5), r.Domains[1].Previous)
+
+	assert.Len(t, r.Categories, 3)
+	assert.Equal(t, SOCIAL_CATEGORY, r.Categories[0].Name)
+	assert.Equal(t, int64(15), r.Categories[0].Total)
+	assert.Equal(t, int64(21), r.Categories[0].Previous)
+	assert.Equal(t, OTHER_CATEGORY, r.Categories[1].Name)
+	assert.Equal(t, []int64{0, 3, 0}, r.Categories[1].Hits)
+	assert.Equal(t, DIRECT_CATEGORY, r.Categories[2].Name)
+
+	// /c had no hits at all in this period, but is the biggest mover.
+	assert.Len(t, r.PathMovers, 3)
+	assert.Equal(t, "/c", r.PathMovers[0].Name)
+	assert.Equal(t, int64(-20), r.PathMovers[0].Change())
+	assert.Equal(t, "/a", r.PathMovers[1].Name)
+	assert.Equal(t, "/b", r.PathMovers[2].Name)
+	assert.Equal(t, "lobste.rs", r.DomainMovers[0].Name)
+
+	hourly := NewReport(nil, nil, HOURLY, begin, begin.Add(24*time.Hour))
+	assert.Len(t, hourly.Buckets, 24)
+	assert.Equal(t, make([]int64, 24), hourly.All.Hits)
+	assert.Empty(t, hourly.Paths)
+}
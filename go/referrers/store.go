@@ -0,0 +1,126 @@
+// Package referrers stores counts of the requests for each path by referrer,
+// in hourly and daily buckets.
+package referrers
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Granularities of the buckets counts are kept in.
+const (
+	HOURLY = "hourly"
+	DAILY  = "daily"
+)
+
+// NO_REFERRER is the referrer counted for requests without one.
+const NO_REFERRER = "∅"
+
+// Granularities are all the granularities, finest first.
+var Granularities = []string{HOURLY, DAILY}
+
+// Count is the number of requests for Path from Referrer in the bucket of the
+// given Granularity that begins at Start.
+type Count struct {
+	Path        string `datastore:",noindex"`
+	Referrer    string `datastore:",noindex"`
+	Granularity string `datastore:",noindex"`
+	Start       time.Time
+	Hits        int64 `datastore:",noindex"`
+}
+
+// key identifies the bucket a Count is in.
+func (c *Count) key() countKey {
+	return countKey{
+		path:        c.Path,
+		referrer:    c.Referrer,
+		granularity: c.Granularity,
+		start:       c.Start.Unix(),
+	}
+}
+
+// countKey is a Count without the Hits, usable as a map key.
+type countKey struct {
+	path        string
+	referrer    string
+	granularity string
+	start       int64
+}
+
+// Store is the storage backend for Counts.
+type Store interface {
+	// Add adds the Hits of each Count to those already stored for the same
+	// Path, Referrer, Granularity, and Start.
+	//
+	// If Add fails after some of the counts were written, the error is an
+	// *UnwrittenError, so that only the rest are added again.
+	Add(ctx context.Context, counts []*Count) error
+
+	// Get returns the stored Counts of the given granularity whose Start is
+	// in [begin, end).
+	Get(ctx context.Context, granularity string, begin, end time.Time) ([]*Count, error)
+
+	// Prune deletes the stored Counts of the given granularity whose Start is
+	// before the given time.
+	Prune(ctx context.Context, granularity string, before time.Time) error
+}
+
+// UnwrittenError is the error from Store.Add when only some of the counts
+// were written. Unwritten are the counts that weren't.
+type UnwrittenError struct {
+	Unwritten []*Count
+	Err       error
+}
+
+func (e *UnwrittenError) Error() string {
+	return e.Err.Error()
+}
+
+// Start returns the start of the bucket of the given granularity that t falls
+// in. Buckets are in UTC.
+func Start(granularity string, t time.Time) time.Time {
+	t = t.UTC()
+	if granularity == DAILY {
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+	}
+	return t.Truncate(time.Hour)
+}
+
+// Step returns the length of a bucket of the given granularity.
+func Step(granularity string) time.Duration {
+	if granularity == DAILY {
+		return 24 * time.Hour
+	}
+	return time.Hour
+}
+
+// checkGranularity returns an error if granularity isn't one of
+// Granularities.
+func checkGranularity(granularity string) error {
+	for _, g := range Granularities {
+		if g == granularity {
+			return nil
+		}
+	}
+	return fmt.Errorf("Unknown granularity: %q", granularity)
+}
+
+// merge returns counts with the Hits of those in the same bucket, for the
+// same Path and Referrer, added together, in the order each bucket first
+// appears. The returned Counts are copies.
+func merge(counts []*Count) []*Count {
+	ret := []*Count{}
+	merged := map[countKey]*Count{}
+	for _, c := range counts {
+		key := c.key()
+		if m, ok := merged[key]; ok {
+			m.Hits += c.Hits
+			continue
+		}
+		copied := *c
+		merged[key] = &copied
+		ret = append(ret, &copied)
+	}
+	return ret
+}
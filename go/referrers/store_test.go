@@ -0,0 +1,121 @@
+package referrers
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	bolt "go.etcd.io/bbolt"
+)
+
+// testStore exercises the Store s, which must be empty.
+func testStore(t *testing.T, s Store) {
+	ctx := context.Background()
+	day := time.Date(2018, 3, 4, 0, 0, 0, 0, time.UTC)
+	hour := func(h int) time.Time {
+		return day.Add(time.Duration(h) * time.Hour)
+	}
+
+	assert.NoError(t, s.Add(ctx, []*Count{
+		{Path: "/a", Referrer: "https://news.ycombinator.com/", Granularity: HOURLY, Start: hour(1), Hits: 2},
+		{Path: "/a", Referrer: "https://news.ycombinator.com/", Granularity: DAILY, Start: day, Hits: 2},
+		{Path: "/a", Referrer: "∅", Granularity: HOURLY, Start: hour(2), Hits: 1},
+		{Path: "/b", Referrer: "https://example.com/", Granularity: HOURLY, Start: hour(25), Hits: 4},
+	}))
+	// Adding again adds to the stored counts, including several for the
+	// same bucket at once.
+	assert.NoError(t, s.Add(ctx, []*Count{
+		{Path: "/a", Referrer: "https://news.ycombinator.com/", Granularity: HOURLY, Start: hour(1), Hits: 1},
+		{Path: "/a", Referrer: "https://news.ycombinator.com/", Granularity: HOURLY, Start: hour(1), Hits: 2},
+	}))
+	assert.Error(t, s.Add(ctx, []*Count{
+		{Path: "/a", Referrer: "∅", Granularity: "weekly", Start: day, Hits: 1},
+	}))
+
+	counts, err := s.Get(ctx, HOURLY, day, day.Add(24*time.Hour))
+	assert.NoError(t, err)
+	assert.Len(t, counts, 2)
+	assert.Equal(t, "/a", counts[0].Path)
+	assert.Equal(t, "https://news.ycombinator.com/", counts[0].Referrer)
+	assert.Equal(t, int64(5), counts[0].Hits)
+	assert.True(t, hour(1).Equal(counts[0].Start))
+	assert.Equal(t, "∅", counts[1].Referrer)
+
+	counts, err = s.Get(ctx, HOURLY, hour(2), hour(26))
+	assert.NoError(t, err)
+	assert.Len(t, counts, 2)
+	assert.Equal(t, "/b", counts[1].Path)
+
+	// Only Counts that start in [begin, end) are returned, even if begin
+	// and end aren't on the hour.
+	counts, err = s.Get(ctx, HOURLY, hour(1).Add(30*time.Minute), hour(2).Add(30*time.Minute))
+	assert.NoError(t, err)
+	assert.Len(t, counts, 1)
+	assert.True(t, hour(2).Equal(counts[0].Start))
+
+	counts, err = s.Get(ctx, DAILY, day, day.Add(24*time.Hour))
+	assert.NoError(t, err)
+	assert.Len(t, counts, 1)
+	assert.Equal(t, int64(2), counts[0].Hits)
+	assert.Equal(t, DAILY, counts[0].Granularity)
+
+	assert.NoError(t, s.Prune(ctx, HOURLY, hour(2)))
+	counts, err = s.Get(ctx, HOURLY, day, hour(48))
+	assert.NoError(t, err)
+	assert.Len(t, counts, 2)
+	assert.Equal(t, "∅", counts[0].Referrer)
+	counts, err = s.Get(ctx, DAILY, day, day.Add(24*time.Hour))
+	assert.NoError(t, err)
+	assert.Len(t, counts, 1)
+
+	assert.NoError(t, s.Prune(ctx, HOURLY, hour(2).Add(time.Minute)))
+	counts, err = s.Get(ctx, HOURLY, day, hour(48))
+	assert.NoError(t, err)
+	assert.Len(t, counts, 1)
+	assert.Equal(t, "/b", counts[0].Path)
+}
+
+func TestBoltStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "referrers")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	db, err := bolt.Open(filepath.Join(dir, "test.db"), 0600, nil)
+	assert.NoError(t, err)
+	defer db.Close()
+	s, err := NewBoltStore(db)
+	assert.NoError(t, err)
+	testStore(t, s)
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, NewMemoryStore())
+}
+
+func TestMerge(t *testing.T) {
+	day := time.Date(2018, 3, 4, 0, 0, 0, 0, time.UTC)
+	counts := []*Count{
+		{Path: "/a", Referrer: "∅", Granularity: DAILY, Start: day, Hits: 1},
+		{Path: "/b", Referrer: "∅", Granularity: DAILY, Start: day, Hits: 2},
+		{Path: "/a", Referrer: "∅", Granularity: HOURLY, Start: day, Hits: 4},
+		{Path: "/a", Referrer: "∅", Granularity: DAILY, Start: day, Hits: 8},
+	}
+	merged := merge(counts)
+	assert.Len(t, merged, 3)
+	assert.Equal(t, "/a", merged[0].Path)
+	assert.Equal(t, int64(9), merged[0].Hits)
+	assert.Equal(t, int64(2), merged[1].Hits)
+	assert.Equal(t, int64(4), merged[2].Hits)
+	// The counts passed in aren't changed.
+	assert.Equal(t, int64(1), counts[0].Hits)
+}
+
+func TestStart(t *testing.T) {
+	ts := time.Date(2018, 3, 4, 15, 16, 17, 0, time.FixedZone("EST", -5*3600))
+	assert.Equal(t, time.Date(2018, 3, 4, 20, 0, 0, 0, time.UTC), Start(HOURLY, ts))
+	assert.Equal(t, time.Date(2018, 3, 4, 0, 0, 0, 0, time.UTC), Start(DAILY, ts))
+}
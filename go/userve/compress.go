@@ -0,0 +1,233 @@
+package main
+
+import (
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/skia-dev/glog"
+)
+
+// Content codings, in order of preference.
+const (
+	BROTLI_ENCODING = "br"
+	GZIP_ENCODING   = "gzip"
+)
+
+// COMPRESS_MIN_SIZE is the smallest response, if the length is known, that
+// is compressed.
+const COMPRESS_MIN_SIZE = 256
+
+// encodingSuffixes are the file name suffixes of precompressed files, keyed
+// by content coding.
+var encodingSuffixes = map[string]string{
+	BROTLI_ENCODING: ".br",
+	GZIP_ENCODING:   ".gz",
+}
+
+// incompressibleTypes are media types that are already compressed, so
+// compressing them again only costs time.
+var incompressibleTypes = map[string]bool{
+	"application/gzip":   true,
+	"application/x-gzip": true,
+	"application/zip":    true,
+	"application/pdf":    true,
+	"font/woff":          true,
+	"font/woff2":         true,
+}
+
+// compressible returns true if responses with the given Content-Type are
+// worth compressing.
+func compressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	if incompressibleTypes[mediaType] {
+		return false
+	}
+	switch {
+	case mediaType == "image/svg+xml":
+		return true
+	case strings.HasPrefix(mediaType, "image/"),
+		strings.HasPrefix(mediaType, "audio/"),
+		strings.HasPrefix(mediaType, "video/"):
+		return false
+	}
+	return true
+}
+
+// acceptedEncodings returns the content codings we support that r accepts,
+// in our order of preference.
+func acceptedEncodings(r *http.Request) []string {
+	accepted := map[string]bool{}
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		fields := strings.Split(part, ";")
+		coding := strings.ToLower(strings.TrimSpace(fields[0]))
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if v, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		if q > 0 {
+			accepted[coding] = true
+		}
+	}
+	ret := []string{}
+	for _, enc := range []string{BROTLI_ENCODING, GZIP_ENCODING} {
+		if accepted[enc] {
+			ret = append(ret, enc)
+		}
+	}
+	return ret
+}
+
+// stripETagSuffix removes the "-<coding>" that compressHandler adds to ETags
+// from every ETag in the header value, returning true if it found any.
+func stripETagSuffix(value, coding string) (string, bool) {
+	suffix := "-" + coding + "\""
+	found := false
+	parts := strings.Split(value, ",")
+	for i, p := range parts {
+		trimmed := strings.TrimSpace(p)
+		if strings.HasSuffix(trimmed, suffix) {
+			parts[i] = strings.TrimSuffix(trimmed, suffix) + "\""
+			found = true
+		}
+	}
+	return strings.Join(parts, ","), found
+}
+
+// compressWriter compresses the response, if it is worth it, once the
+// headers are known.
+type compressWriter struct {
+	http.ResponseWriter
+	r *http.Request
+
+	// coding is the content coding to use, if any.
+	coding string
+
+	// matched is the coding suffix on the ETag the client sent in
+	// If-None-Match, if any.
+	matched string
+
+	wroteHeader bool
+	encoder     io.WriteCloser
+}
+
+// etagWithSuffix adds the coding to a strong ETag, since the compressed
+// response is a different representation than the uncompressed one.
+func etagWithSuffix(etag, coding string) string {
+	if strings.HasPrefix(etag, "W/") || !strings.HasSuffix(etag, "\"") {
+		return etag
+	}
+	return strings.TrimSuffix(etag, "\"") + "-" + coding + "\""
+}
+
+func (c *compressWriter) WriteHeader(status int) {
+	if c.wroteHeader {
+		return
+	}
+	c.wroteHeader = true
+	h := c.Header()
+	if status == http.StatusNotModified {
+		// Echo back the ETag the client has, with the suffix.
+		if c.matched != "" {
+			h.Set("ETag", etagWithSuffix(h.Get("ETag"), c.matched))
+		}
+		c.ResponseWriter.WriteHeader(status)
+		return
+	}
+	if enc := h.Get("Content-Encoding"); enc != "" {
+		// Already compressed, such as a precompressed file.
+		h.Set("ETag", etagWithSuffix(h.Get("ETag"), enc))
+		c.ResponseWriter.WriteHeader(status)
+		return
+	}
+	size, err := strconv.Atoi(h.Get("Content-Length"))
+	small := err == nil && size < COMPRESS_MIN_SIZE
+	if c.coding == "" || status == http.StatusNoContent || status == http.StatusPartialContent || status < 200 || small || !compressible(h.Get("Content-Type")) {
+		c.ResponseWriter.WriteHeader(status)
+		return
+	}
+	h.Set("Content-Encoding", c.coding)
+	h.Del("Content-Length")
+	h.Del("Accept-Ranges")
+	h.Set("ETag", etagWithSuffix(h.Get("ETag"), c.coding))
+	if c.r.Method != "HEAD" {
+		switch c.coding {
+		case BROTLI_ENCODING:
+			c.encoder = brotli.NewWriterLevel(c.ResponseWriter, brotli.DefaultCompression)
+		case GZIP_ENCODING:
+			c.encoder = gzip.NewWriter(c.ResponseWriter)
+		}
+	}
+	c.ResponseWriter.WriteHeader(status)
+}
+
+func (c *compressWriter) Write(b []byte) (int, error) {
+	if !c.wroteHeader {
+		if c.Header().Get("Content-Type") == "" {
+			c.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		c.WriteHeader(http.StatusOK)
+	}
+	if c.encoder != nil {
+		return c.encoder.Write(b)
+	}
+	return c.ResponseWriter.Write(b)
+}
+
+// Close flushes the compressed stream, if any.
+func (c *compressWriter) Close() error {
+	if c.encoder != nil {
+		return c.encoder.Close()
+	}
+	return nil
+}
+
+// compressHandler compresses responses with Brotli or gzip, depending on the
+// Accept-Encoding of the request, unless the response is already
+// compressed, has a media type that is already compressed, such as PNG or
+// JPEG, or is too small to bother with.
+//
+// The coding is appended to strong ETags, as in "abc-gzip", and removed
+// again from If-None-Match and If-Match, so conditional requests work for
+// both representations.
+func compressHandler(h http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		cw := &compressWriter{
+			ResponseWriter: w,
+			r:              r,
+		}
+		encodings := acceptedEncodings(r)
+		if len(encodings) > 0 {
+			cw.coding = encodings[0]
+		}
+		r = r.Clone(r.Context())
+		for _, coding := range encodings {
+			for _, name := range []string{"If-None-Match", "If-Match"} {
+				if v := r.Header.Get(name); v != "" {
+					stripped, found := stripETagSuffix(v, coding)
+					r.Header.Set(name, stripped)
+					if found && name == "If-None-Match" && cw.matched == "" {
+						cw.matched = coding
+					}
+				}
+			}
+		}
+		h.ServeHTTP(cw, r)
+		if err := cw.Close(); err != nil {
+			glog.Warningf("Failed to finish compressing response: %s", err)
+		}
+	}
+}
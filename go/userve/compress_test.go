@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/andybalholm/brotli"
+	"github.com/stretchr/testify/assert"
+)
+
+// bigCSS is a stylesheet larger than COMPRESS_MIN_SIZE.
+var bigCSS = strings.Repeat("body { color: red; }\n", 100)
+
+// getWith requests path from h with the given headers.
+func getWith(h http.Handler, path string, headers map[string]string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("GET", "https://example.com"+path, nil)
+	for k, v := range headers {
+		r.Header.Set(k, v)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestAcceptedEncodings(t *testing.T) {
+	for header, want := range map[string][]string{
+		"":                         {},
+		"gzip":                     {GZIP_ENCODING},
+		"gzip, deflate, br":        {BROTLI_ENCODING, GZIP_ENCODING},
+		"GZIP;q=0.5, BR;q=0.1":     {BROTLI_ENCODING, GZIP_ENCODING},
+		"br;q=0, gzip":             {GZIP_ENCODING},
+		"br;q=0.0, gzip;q=0":       {},
+		"identity, deflate":        {},
+		"br ; q=0 , gzip ; q=1.0":  {GZIP_ENCODING},
+		"gzip;q=bogus, br;q=0.000": {GZIP_ENCODING},
+	} {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Accept-Encoding", header)
+		assert.Equal(t, want, acceptedEncodings(r), header)
+	}
+}
+
+func TestCompressible(t *testing.T) {
+	for _, contentType := range []string{
+		"text/html; charset=utf-8",
+		"text/css",
+		"application/javascript",
+		"application/json",
+		"application/atom+xml",
+		"image/svg+xml",
+	} {
+		assert.True(t, compressible(contentType), contentType)
+	}
+	for _, contentType := range []string{
+		"image/png",
+		"image/jpeg",
+		"image/webp",
+		"video/mp4",
+		"font/woff",
+		"font/woff2",
+		"application/zip",
+		"application/gzip",
+		"",
+		"not a type;;",
+	} {
+		assert.False(t, compressible(contentType), contentType)
+	}
+}
+
+func TestETagSuffix(t *testing.T) {
+	assert.Equal(t, `"abc-gzip"`, etagWithSuffix(`"abc"`, GZIP_ENCODING))
+	assert.Equal(t, `"abc-br"`, etagWithSuffix(`"abc"`, BROTLI_ENCODING))
+	// Weak ETags are left alone.
+	assert.Equal(t, `W/"abc"`, etagWithSuffix(`W/"abc"`, GZIP_ENCODING))
+
+	stripped, found := stripETagSuffix(`"abc-gzip"`, GZIP_ENCODING)
+	assert.True(t, found)
+	assert.Equal(t, `"abc"`, stripped)
+	stripped, found = stripETagSuffix(`"abc-br", "def-gzip"`, GZIP_ENCODING)
+	assert.True(t, found)
+	assert.Equal(t, `"abc-br","def"`, stripped)
+	_, found = stripETagSuffix(`"abc"`, GZIP_ENCODING)
+	assert.False(t, found)
+}
+
+func TestServePrecompressed(t *testing.T) {
+	_, root, cleanup := testSite(t)
+	defer cleanup()
+	write := func(name, contents string, modTime time.Time) {
+		filename := filepath.Join(root, name)
+		assert.NoError(t, ioutil.WriteFile(filename, []byte(contents), 0644))
+		assert.NoError(t, os.Chtimes(filename, modTime, modTime))
+	}
+	now := time.Now()
+	write("fresh.css", bigCSS, now.Add(-time.Hour))
+	write("fresh.css.gz", "gzipped", now)
+	write("fresh.css.br", "brotlied", now)
+	write("stale.css", bigCSS, now)
+	write("stale.css.gz", "gzipped", now.Add(-time.Hour))
+	h := testFileServer(t, root, "")
+
+	w := getWith(h, "/fresh.css", map[string]string{"Accept-Encoding": "gzip, br"})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, BROTLI_ENCODING, w.Header().Get("Content-Encoding"))
+	assert.Equal(t, "text/css; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Equal(t, "brotlied", w.Body.String())
+
+	w = getWith(h, "/fresh.css", map[string]string{"Accept-Encoding": "gzip"})
+	assert.Equal(t, GZIP_ENCODING, w.Header().Get("Content-Encoding"))
+	assert.Equal(t, "gzipped", w.Body.String())
+
+	w = getWith(h, "/fresh.css", nil)
+	assert.Equal(t, "", w.Header().Get("Content-Encoding"))
+	assert.Equal(t, bigCSS, w.Body.String())
+
+	// A sibling older than the file isn't served.
+	w = getWith(h, "/stale.css", map[string]string{"Accept-Encoding": "gzip"})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "", w.Header().Get("Content-Encoding"))
+	assert.Equal(t, bigCSS, w.Body.String())
+
+	// Nor is one for a range request.
+	w = getWith(h, "/fresh.css", map[string]string{"Accept-Encoding": "gzip", "Range": "bytes=0-3"})
+	assert.Equal(t, http.StatusPartialContent, w.Code)
+	assert.Equal(t, "", w.Header().Get("Content-Encoding"))
+	assert.Equal(t, bigCSS[:4], w.Body.String())
+}
+
+func TestCompressHandler(t *testing.T) {
+	_, root, cleanup := testSite(t)
+	defer cleanup()
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(root, "big.css"), []byte(bigCSS), 0644))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(root, "big.png"), []byte(bigCSS), 0644))
+	h := compressHandler(testFileServer(t, root, ""))
+
+	w := getWith(h, "/big.css", map[string]string{"Accept-Encoding": "gzip"})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, GZIP_ENCODING, w.Header().Get("Content-Encoding"))
+	assert.Equal(t, "Accept-Encoding", w.Header().Get("Vary"))
+	assert.Equal(t, "", w.Header().Get("Content-Length"))
+	gz, err := gzip.NewReader(w.Body)
+	assert.NoError(t, err)
+	b, err := ioutil.ReadAll(gz)
+	assert.NoError(t, err)
+	assert.Equal(t, bigCSS, string(b))
+
+	// The ETag has the coding added, and removed again for If-None-Match.
+	etag := w.Header().Get("ETag")
+	assert.True(t, strings.HasSuffix(etag, `-gzip"`), etag)
+	w = getWith(h, "/big.css", map[string]string{"Accept-Encoding": "gzip", "If-None-Match": etag})
+	assert.Equal(t, http.StatusNotModified, w.Code)
+	assert.Equal(t, etag, w.Header().Get("ETag"))
+	assert.Equal(t, 0, w.Body.Len())
+
+	w = getWith(h, "/big.css", map[string]string{"Accept-Encoding": "br"})
+	assert.Equal(t, BROTLI_ENCODING, w.Header().Get("Content-Encoding"))
+	assert.True(t, strings.HasSuffix(w.Header().Get("ETag"), `-br"`))
+	b, err = ioutil.ReadAll(brotli.NewReader(bytes.NewReader(w.Body.Bytes())))
+	assert.NoError(t, err)
+	assert.Equal(t, bigCSS, string(b))
+
+	// Not compressed: small, already compressed types, ranges, and without
+	// Accept-Encoding.
+	for _, test := range []struct {
+		path    string
+		headers map[string]string
+		status  int
+	}{
+		{"/css/main.css", map[string]string{"Accept-Encoding": "gzip"}, http.StatusOK},
+		{"/big.png", map[string]string{"Accept-Encoding": "gzip"}, http.StatusOK},
+		{"/big.css", map[string]string{"Accept-Encoding": "gzip", "Range": "bytes=0-299"}, http.StatusPartialContent},
+		{"/big.css", map[string]string{"Accept-Encoding": "gzip;q=0"}, http.StatusOK},
+	} {
+		w := getWith(h, test.path, test.headers)
+		assert.Equal(t, test.status, w.Code, test.path)
+		assert.Equal(t, "", w.Header().Get("Content-Encoding"), test.path)
+		assert.False(t, strings.HasSuffix(w.Header().Get("ETag"), `-gzip"`), test.path)
+	}
+}
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"bytes"
+	"html/template"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/jcgregorio/userve/go/redirect"
+	"github.com/skia-dev/glog"
+)
+
+// MAX_SUGGESTIONS is the most paths suggested on an error page.
+const MAX_SUGGESTIONS = 5
+
+// MAX_SUGGEST_PATH is the longest path, in bytes, that suggestions are made
+// for, since finding them takes time in proportion to its length.
+const MAX_SUGGEST_PATH = 256
+
+// MAX_CANDIDATES is the most names the missing path is compared to when
+// making suggestions.
+const MAX_CANDIDATES = 1000
+
+// ERROR_CACHE_CONTROL is the Cache-Control of error pages, so a page that
+// was missing shows up soon after it is added.
+const ERROR_CACHE_CONTROL = "no-cache"
+
+// errorContext is the data error page templates are executed with.
+type errorContext struct {
+	Status      int
+	StatusText  string
+	Path        string
+	Suggestions []string
+}
+
+// defaultErrorTemplate is used when the site doesn't have its own page for a
+// status.
+var defaultErrorTemplate = template.Must(template.New("error").Parse(`<!DOCTYPE html>
+<html>
+<head>
+	<meta charset="utf-8">
+	<title>{{ .Status }} {{ .StatusText }}</title>
+</head>
+<body>
+	<h1>{{ .Status }} {{ .StatusText }}</h1>
+	{{ if .Suggestions }}
+	<p>Did you mean:</p>
+	<ul>
+		{{ range .Suggestions }}
+		<li><a href="{{ . }}">{{ . }}</a></li>
+		{{ end }}
+	</ul>
+	{{ end }}
+</body>
+</html>`))
+
+// errorTemplate is a parsed error page, valid while the file has the same
+// modification time.
+type errorTemplate struct {
+	modTime time.Time
+	t       *template.Template
+}
+
+var (
+	errorTemplatesMutex sync.Mutex
+	errorTemplates      = map[string]errorTemplate{}
+)
+
+// loadErrorTemplate returns the error page at filename, parsed as a
+// template, or nil if there isn't one.
+func loadErrorTemplate(filename string) *template.Template {
+	fi, err := os.Stat(filename)
+	if err != nil || fi.IsDir() {
+		return nil
+	}
+	errorTemplatesMutex.Lock()
+	e, ok := errorTemplates[filename]
+	errorTemplatesMutex.Unlock()
+	if ok && e.modTime.Equal(fi.ModTime()) {
+		return e.t
+	}
+	b, err := ioutil.ReadFile(filename)
+	if err != nil {
+		glog.Warningf("Failed to read error page %q: %s", filename, err)
+		return nil
+	}
+	t, err := template.New(path.Base(filename)).Parse(string(b))
+	if err != nil {
+		glog.Warningf("Failed to parse error page %q: %s", filename, err)
+		return nil
+	}
+	errorTemplatesMutex.Lock()
+	errorTemplates[filename] = errorTemplate{
+		modTime: fi.ModTime(),
+		t:       t,
+	}
+	errorTemplatesMutex.Unlock()
+	return t
+}
+
+// serveError replies with the site's error page for status, or the default
+// one, listing the paths that the request may have meant to reach.
+func (f *fileHandler) serveError(w http.ResponseWriter, r *http.Request, status int) {
+	ctx := errorContext{
+		Status:     status,
+		StatusText: http.StatusText(status),
+		Path:       r.URL.Path,
+	}
+	if status == http.StatusNotFound {
+		ctx.Suggestions = f.suggestions(r.URL.Path)
+	}
+	var buf bytes.Buffer
+	executed := false
+	if page, ok := f.site.ErrorPages[status]; ok {
+		if t := loadErrorTemplate(path.Join(f.dir, page)); t != nil {
+			if err := t.Execute(&buf, ctx); err != nil {
+				glog.Warningf("Failed to execute error page %q: %s", page, err)
+				buf.Reset()
+			} else {
+				executed = true
+			}
+		}
+	}
+	if !executed {
+		if err := defaultErrorTemplate.Execute(&buf, ctx); err != nil {
+			glog.Errorf("Failed to execute default error page: %s", err)
+		}
+	}
+	h := w.Header()
+	h.Del("ETag")
+	h.Del("Last-Modified")
+	h.Set("Cache-Control", ERROR_CACHE_CONTROL)
+	h.Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if r.Method != "HEAD" {
+		if _, err := w.Write(buf.Bytes()); err != nil {
+			glog.Warningf("Failed to write error page: %s", err)
+		}
+	}
+}
+
+// suggestion is a path that may be the one meant, and how far it is from
+// the one asked for.
+type suggestion struct {
+	path     string
+	distance int
+}
+
+// suggestions returns the paths closest to the missing urlPath, from the
+// exact redirect rules and from the directories it would be in, with any
+// mistyped directories along the way corrected.
+func (f *fileHandler) suggestions(urlPath string) []string {
+	urlPath = path.Clean("/" + urlPath)
+	if len(urlPath) > MAX_SUGGEST_PATH {
+		return []string{}
+	}
+	candidates := 0
+	near := func(s, name string) (int, bool) {
+		if candidates >= MAX_CANDIDATES {
+			return 0, false
+		}
+		candidates++
+		return typoDistance(s, name)
+	}
+	found := map[string]int{}
+	add := func(p string, d int) {
+		if p == urlPath || p == urlPath+"/" {
+			return
+		}
+		if old, ok := found[p]; !ok || d < old {
+			found[p] = d
+		}
+	}
+
+	// Walk down the directories, fixing any that are mistyped.
+	segments := strings.Split(strings.Trim(urlPath, "/"), "/")
+	dir := "/"
+	last := segments[len(segments)-1]
+	distance := 0
+	for _, seg := range segments[:len(segments)-1] {
+		if fi, err := os.Stat(path.Join(f.dir, dir, seg)); err == nil && fi.IsDir() {
+			dir = path.Join(dir, seg)
+			continue
+		}
+		best, d := "", -1
+		for _, e := range f.entries(dir) {
+			if !strings.HasSuffix(e, "/") {
+				continue
+			}
+			if ed, ok := near(seg, strings.TrimSuffix(e, "/")); ok && (d == -1 || ed < d) {
+				best, d = e, ed
+			}
+		}
+		if best == "" {
+			// Suggest from the directory we got to.
+			last = seg
+			break
+		}
+		dir = path.Join(dir, best)
+		distance += d
+	}
+	last = strings.TrimSuffix(last, ".html")
+	for _, e := range f.entries(dir) {
+		if d, ok := near(last, strings.TrimSuffix(e, "/")); ok {
+			add(path.Join(dir, e)+trailingSlash(e), distance+d)
+		}
+	}
+
+	if f.redirects != nil {
+		for _, rule := range f.redirects.All {
+			if rule.Kind != redirect.EXACT || rule.Status == http.StatusGone {
+				continue
+			}
+			if d, ok := near(urlPath, rule.Source); ok {
+				add(rule.Source, d)
+			}
+		}
+	}
+
+	all := []suggestion{}
+	for p, d := range found {
+		all = append(all, suggestion{path: p, distance: d})
+	}
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].distance != all[j].distance {
+			return all[i].distance < all[j].distance
+		}
+		return all[i].path < all[j].path
+	})
+	ret := []string{}
+	for i := 0; i < len(all) && i < MAX_SUGGESTIONS; i++ {
+		ret = append(ret, all[i].path)
+	}
+	return ret
+}
+
+// entries returns the names of the files and directories in dir, relative
+// to the site Source, as they appear in URLs: directories end in /, the
+// .html extension is dropped, and private files, error pages, and
+// precompressed copies are left out.
+func (f *fileHandler) entries(dir string) []string {
+	infos, err := ioutil.ReadDir(path.Join(f.dir, dir))
+	if err != nil {
+		return nil
+	}
+	errorPages := map[string]bool{}
+	for _, page := range f.site.ErrorPages {
+		errorPages[path.Join("/", page)] = true
+	}
+	ret := []string{}
+	for _, fi := range infos {
+		name := fi.Name()
+		if f.site.IsPrivate(path.Join(dir, name)) || errorPages[path.Join(dir, name)] || name == "index.html" {
+			continue
+		}
+		if fi.IsDir() {
+			ret = append(ret, name+"/")
+			continue
+		}
+		if isPrecompressed(name) {
+			continue
+		}
+		ret = append(ret, strings.TrimSuffix(name, ".html"))
+	}
+	return ret
+}
+
+// isPrecompressed returns true if name is a compressed copy of another
+// file, such as foo.css.gz.
+func isPrecompressed(name string) bool {
+	for _, suffix := range encodingSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// trailingSlash returns "/" if the entry name is a directory.
+func trailingSlash(name string) string {
+	if strings.HasSuffix(name, "/") {
+		return "/"
+	}
+	return ""
+}
+
+// closeEnough returns true if a name d edits away from s is likely to be a
+// typo of s.
+func closeEnough(s string, d int) bool {
+	max := len(s) / 3
+	if max < 2 {
+		max = 2
+	}
+	return d <= max
+}
+
+// typoDistance returns the edit distance between s and name, and true if name
+// is likely to be a typo of s. Names that differ too much in length to be
+// close enough aren't compared at all.
+func typoDistance(s, name string) (int, bool) {
+	diff := utf8.RuneCountInString(s) - utf8.RuneCountInString(name)
+	if diff < 0 {
+		diff = -diff
+	}
+	if !closeEnough(s, diff) {
+		return 0, false
+	}
+	d := editDistance(s, name)
+	return d, closeEnough(s, d)
+}
+
+// editDistance returns the Levenshtein distance between a and b, ignoring
+// case.
+func editDistance(a, b string) int {
+	ra, rb := []rune(strings.ToLower(a)), []rune(strings.ToLower(b))
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// errorSite creates a site with its own error pages in a temporary
+// directory, returning the directory and a function to clean up.
+func errorSite(t *testing.T) (string, func()) {
+	root, err := ioutil.TempDir("", "userve")
+	assert.NoError(t, err)
+	for name, contents := range map[string]string{
+		"redirects":              "/old /about 301\n/gone 410\n/news/archive /news/2018/ 301\n",
+		"404.html":               `<p>Missing {{ .Path }}</p>{{ range .Suggestions }}<a href="{{ . }}">{{ . }}</a>{{ end }}`,
+		"410.html":               `<p>{{ .Status }} {{ .Path }} is gone</p>`,
+		"500.html":               `{{ .Broken`,
+		"index.html":             "home",
+		"about.html":             "about",
+		"news/2018/post.html":    "post",
+		"news/2018/post.html.gz": "gzipped",
+		"news/2018/party.html":   "party",
+		"news/drafts/post.html":  "draft",
+		"news/drafty.bak":        "backup",
+	} {
+		filename := filepath.Join(root, name)
+		assert.NoError(t, os.MkdirAll(filepath.Dir(filename), 0755))
+		assert.NoError(t, ioutil.WriteFile(filename, []byte(contents), 0644))
+	}
+	return root, func() {
+		_ = os.RemoveAll(root)
+	}
+}
+
+func TestErrorPages(t *testing.T) {
+	root, cleanup := errorSite(t)
+	defer cleanup()
+	h := testFileServer(t, root, "")
+
+	w := get(h, "/missing")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, "<p>Missing /missing</p>", w.Body.String())
+	assert.Equal(t, ERROR_CACHE_CONTROL, w.Header().Get("Cache-Control"))
+	assert.Equal(t, "text/html; charset=utf-8", w.Header().Get("Content-Type"))
+	assert.Equal(t, "", w.Header().Get("ETag"))
+
+	w = get(h, "/gone")
+	assert.Equal(t, http.StatusGone, w.Code)
+	assert.Equal(t, "<p>410 /gone is gone</p>", w.Body.String())
+
+	// A page that fails to parse falls back to the default.
+	w = httptest.NewRecorder()
+	h.(*fileHandler).serveError(w, httptest.NewRequest("GET", "/boom", nil), http.StatusInternalServerError)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+	assert.Contains(t, w.Body.String(), "<h1>500 Internal Server Error</h1>")
+
+	// The error pages themselves are served as they are.
+	w = get(h, "/404.html")
+	assert.Equal(t, http.StatusOK, w.Code)
+
+	// HEAD gets the status without the page.
+	r := httptest.NewRequest("HEAD", "https://example.com/missing", nil)
+	w = httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Equal(t, 0, w.Body.Len())
+}
+
+func TestDefaultErrorPage(t *testing.T) {
+	// testSite has no error pages of its own.
+	_, root, cleanup := testSite(t)
+	defer cleanup()
+	h := testFileServer(t, root, "")
+
+	w := get(h, "/abuot.html")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.Contains(t, w.Body.String(), "<title>404 Not Found</title>")
+	assert.Contains(t, w.Body.String(), "Did you mean:")
+	assert.Contains(t, w.Body.String(), `<a href="/about">/about</a>`)
+
+	w = get(h, "/nothing-like-it")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.NotContains(t, w.Body.String(), "Did you mean:")
+}
+
+func TestSuggestions(t *testing.T) {
+	root, cleanup := errorSite(t)
+	defer cleanup()
+	f := testFileServer(t, root, "").(*fileHandler)
+
+	for _, test := range []struct {
+		path string
+		want []string
+	}{
+		// Mistyped files, with or without .html.
+		{"/abuot", []string{"/about"}},
+		{"/About.html", []string{"/about"}},
+		// Mistyped directories are corrected on the way down.
+		{"/news/2019/post", []string{"/news/2018/post"}},
+		{"/nwes/2018/party.html", []string{"/news/2018/party"}},
+		{"/news/2018/pots", []string{"/news/2018/post"}},
+		// Directories are suggested with a trailing slash.
+		{"/news/2017", []string{"/news/2018/"}},
+		// Exact redirect sources, but not those that are gone.
+		{"/olf", []string{"/old"}},
+		{"/news/archiv", []string{"/news/archive"}},
+		{"/gon", []string{}},
+		// Private files and error pages are never suggested.
+		{"/news/draft", []string{}},
+		{"/news/drafty", []string{}},
+		{"/40", []string{}},
+		{"/completely/different", []string{}},
+	} {
+		assert.Equal(t, test.want, f.suggestions(test.path), test.path)
+	}
+
+	// At most MAX_SUGGESTIONS, closest first.
+	for _, name := range []string{"a1", "a2", "a3", "a4", "a5", "a6", "ab"} {
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(root, name+".html"), []byte(name), 0644))
+	}
+	got := f.suggestions("/abc")
+	assert.Len(t, got, MAX_SUGGESTIONS)
+	assert.Equal(t, "/ab", got[0])
+
+	// Paths too long to be worth the time get no suggestions.
+	assert.Equal(t, []string{}, f.suggestions("/"+strings.Repeat("a", MAX_SUGGEST_PATH)))
+}
+
+func TestTypoDistance(t *testing.T) {
+	for _, test := range []struct {
+		s, name string
+		want    int
+		ok      bool
+	}{
+		{"post", "pots", 2, true},
+		{"post", "posts", 1, true},
+		{"post", "p", 0, false},
+		{"post", "a-much-longer-name", 0, false},
+		{"café", "cafe", 1, true},
+	} {
+		d, ok := typoDistance(test.s, test.name)
+		assert.Equal(t, test.want, d, test.name)
+		assert.Equal(t, test.ok, ok, test.name)
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	for _, test := range []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"", "abc", 3},
+		{"post", "post", 0},
+		{"Post", "pOST", 0},
+		{"post", "pots", 2},
+		{"post", "posts", 1},
+		{"kitten", "sitting", 3},
+		{"2018", "2019", 1},
+		{"café", "cafe", 1},
+	} {
+		assert.Equal(t, test.want, editDistance(test.a, test.b), test.a+" "+test.b)
+		assert.Equal(t, test.want, editDistance(test.b, test.a), test.b+" "+test.a)
+	}
+}
+
+func TestCloseEnough(t *testing.T) {
+	for _, test := range []struct {
+		s    string
+		d    int
+		want bool
+	}{
+		// Short names allow two edits.
+		{"ab", 2, true},
+		{"ab", 3, false},
+		{"post", 2, true},
+		{"post", 3, false},
+		// Longer ones a third of their length.
+		{"interesting", 3, true},
+		{"interesting", 4, false},
+		{"a-much-longer-name", 6, true},
+		{"a-much-longer-name", 7, false},
+	} {
+		assert.Equal(t, test.want, closeEnough(test.s, test.d), test.s)
+	}
+}
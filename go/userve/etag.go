@@ -0,0 +1,55 @@
+package main
+
+import (
+	"crypto/md5"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"go.skia.org/infra/go/util"
+)
+
+// etagEntry is a cached ETag, valid while the file has the same size and
+// modification time.
+type etagEntry struct {
+	size    int64
+	modTime time.Time
+	etag    string
+}
+
+var (
+	etagMutex sync.Mutex
+	etags     = map[string]etagEntry{}
+)
+
+// contentETag returns a strong ETag for the file at filename, a hash of its
+// contents, which only changes when the contents do. Unlike the
+// Last-Modified time it survives rebuilding or copying the site.
+func contentETag(filename string, fi os.FileInfo) (string, error) {
+	etagMutex.Lock()
+	e, ok := etags[filename]
+	etagMutex.Unlock()
+	if ok && e.size == fi.Size() && e.modTime.Equal(fi.ModTime()) {
+		return e.etag, nil
+	}
+	f, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer util.Close(f)
+	h := md5.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	etag := fmt.Sprintf("\"%x\"", h.Sum(nil))
+	etagMutex.Lock()
+	etags[filename] = etagEntry{
+		size:    fi.Size(),
+		modTime: fi.ModTime(),
+		etag:    etag,
+	}
+	etagMutex.Unlock()
+	return etag, nil
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/jcgregorio/userve/go/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestContentETag(t *testing.T) {
+	dir, err := ioutil.TempDir("", "userve")
+	assert.NoError(t, err)
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	filename := filepath.Join(dir, "a.css")
+	etagOf := func() string {
+		fi, err := os.Stat(filename)
+		assert.NoError(t, err)
+		etag, err := contentETag(filename, fi)
+		assert.NoError(t, err)
+		return etag
+	}
+	assert.NoError(t, ioutil.WriteFile(filename, []byte("body{}"), 0644))
+	etag := etagOf()
+	assert.Regexp(t, regexp.MustCompile(`^"[0-9a-f]{32}"$`), etag)
+	assert.Equal(t, etag, etagOf())
+
+	// Only the contents matter, not the modification time.
+	later := time.Now().Add(time.Hour)
+	assert.NoError(t, os.Chtimes(filename, later, later))
+	assert.Equal(t, etag, etagOf())
+	other := filepath.Join(dir, "b.css")
+	assert.NoError(t, ioutil.WriteFile(other, []byte("body{}"), 0644))
+	fi, err := os.Stat(other)
+	assert.NoError(t, err)
+	otherETag, err := contentETag(other, fi)
+	assert.NoError(t, err)
+	assert.Equal(t, etag, otherETag)
+
+	// Changing the contents changes the ETag.
+	assert.NoError(t, ioutil.WriteFile(filename, []byte("body{color:red}"), 0644))
+	assert.NotEqual(t, etag, etagOf())
+}
+
+func TestFileServerCacheHeaders(t *testing.T) {
+	_, root, cleanup := testSite(t)
+	defer cleanup()
+	h := testFileServer(t, root, `
+cache:
+  - pattern: /css/*
+    cache_control: public, max-age=86400
+  - pattern: "*.html"
+    cache_control: no-cache
+`)
+
+	for path, cacheControl := range map[string]string{
+		"/css/main.css":        "public, max-age=86400",
+		"/about.html":          "no-cache",
+		"/news/2018/post":      "no-cache",
+		"/":                    "no-cache",
+		"/images/logo.png":     config.DEFAULT_CACHE_CONTROL,
+		"/feed/index.atom":     config.DEFAULT_CACHE_CONTROL,
+		"/news/2018/post.json": config.DEFAULT_CACHE_CONTROL,
+	} {
+		w := get(h, path)
+		assert.Equal(t, http.StatusOK, w.Code, path)
+		assert.Equal(t, cacheControl, w.Header().Get("Cache-Control"), path)
+		assert.NotEqual(t, "", w.Header().Get("ETag"), path)
+	}
+
+	w := get(h, "/css/main.css")
+	etag := w.Header().Get("ETag")
+	ifNoneMatch := func(value string) int {
+		return getWith(h, "/css/main.css", map[string]string{"If-None-Match": value}).Code
+	}
+	assert.Equal(t, http.StatusNotModified, ifNoneMatch(etag))
+	assert.Equal(t, http.StatusNotModified, ifNoneMatch(`"other", `+etag))
+	assert.Equal(t, http.StatusOK, ifNoneMatch(`"other"`))
+
+	// Rewriting the file with the same contents keeps the ETag, new contents
+	// change it.
+	filename := filepath.Join(root, "css/main.css")
+	later := time.Now().Add(time.Hour)
+	assert.NoError(t, os.Chtimes(filename, later, later))
+	assert.Equal(t, http.StatusNotModified, ifNoneMatch(etag))
+	assert.NoError(t, ioutil.WriteFile(filename, []byte("body{color:red}"), 0644))
+	assert.Equal(t, http.StatusOK, ifNoneMatch(etag))
+	assert.NotEqual(t, etag, get(h, "/css/main.css").Header().Get("ETag"))
+}
@@ -1,52 +1,163 @@
 package main
 
 import (
+	"mime"
 	"net/http"
 	"os"
 	"path"
+	"path/filepath"
 	"strings"
 
+	"github.com/jcgregorio/userve/go/config"
+	"github.com/jcgregorio/userve/go/redirect"
 	"github.com/skia-dev/glog"
+	"go.skia.org/infra/go/util"
 )
 
 // Duplicate the code for http.FileServer, but add in the ability to serve HTML
 // files w/o having the .html in the extension in the URL.
 type fileHandler struct {
 	dir       string
-	redirects map[string]string
+	site      *config.Site
+	redirects *redirect.Rules
 }
 
 // FileServer returns a handler that serves HTTP requests
-// with the contents of the file system rooted at dir.
+// with the contents of the file system rooted at the Source of site.
 //
 // As a special case, the returned file server redirects any request
 // ending in "/index.html" to the same path, without the final
 // "index.html".
-func FileServer(dir string, redirects map[string]string) http.Handler {
+//
+// Files are served with a content hash ETag and the Cache-Control from the
+// cache policies of the site.
+//
+// Paths with ".." are refused, and private files, see config.Site.Private,
+// and files reached through symlinks out of Source, unless
+// config.Site.AllowExternalSymlinks is set, are served as not found.
+func FileServer(site *config.Site, redirects *redirect.Rules) http.Handler {
 	return &fileHandler{
-		dir:       dir,
+		dir:       site.Source,
+		site:      site,
 		redirects: redirects,
 	}
 }
 
 func (f *fileHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	glog.Infof("Path: %q", r.URL.Path)
-	if newpath, ok := f.redirects[r.URL.Path]; ok {
-		glog.Infof("redirect: %q", newpath)
-		http.Redirect(w, r, newpath, 301)
+	if newpath, status, ok := f.redirects.Match(r.URL); ok {
+		glog.Infof("redirect: %d %q", status, newpath)
+		if status == http.StatusGone {
+			f.serveError(w, r, status)
+			return
+		}
+		http.Redirect(w, r, newpath, status)
+		return
+	}
+	if status := f.refuseURLPath(r.URL.Path); status != 0 {
+		f.serveError(w, r, status)
 		return
 	}
 	upath := path.Join(f.dir, r.URL.Path)
-	if finfo, err := os.Stat(upath); err == nil && finfo.IsDir() {
-		index := strings.TrimSuffix(upath, "/") + "/index.atom"
-		if _, err := os.Stat(index); err == nil {
-			upath = index
-			w.Header().Set("Content-Type", "application/atom+xml")
+	finfo, err := os.Stat(upath)
+	if err != nil || finfo.IsDir() {
+		// Extensionless URLs are served from whichever of post.html,
+		// post.atom, etc. best matches the Accept header, and directories
+		// from index.html, index.atom, or index.json.
+		var filename, contentType string
+		if !strings.HasSuffix(r.URL.Path, "/") {
+			filename, contentType = negotiate(r, upath, variants)
+		}
+		if filename == "" && err == nil {
+			if !strings.HasSuffix(r.URL.Path, "/") {
+				// Relative links in the index need the trailing slash.
+				target := path.Base(r.URL.Path) + "/"
+				if r.URL.RawQuery != "" {
+					target += "?" + r.URL.RawQuery
+				}
+				http.Redirect(w, r, target, http.StatusMovedPermanently)
+				return
+			}
+			filename, contentType = negotiate(r, path.Join(upath, "index"), indexVariants)
+		}
+		w.Header().Add("Vary", "Accept")
+		if filename != "" {
+			upath = filename
+			w.Header().Set("Content-Type", contentType)
 		}
 	}
-	if _, err := os.Stat(upath + ".html"); err == nil {
-		upath += ".html"
+	upath = path.Clean(upath)
+	if finfo, err := os.Stat(upath); err != nil {
+		if os.IsPermission(err) {
+			glog.Errorf("Failed to stat %q: %s", upath, err)
+			f.serveError(w, r, http.StatusInternalServerError)
+		} else {
+			f.serveError(w, r, http.StatusNotFound)
+		}
+		return
+	} else if finfo.IsDir() || !f.allowedFile(upath) {
+		// Directories without an index aren't listed, since that would
+		// show private files.
+		f.serveError(w, r, http.StatusNotFound)
+		return
+	}
+	f.setCacheHeaders(w, r, upath)
+	if f.servePrecompressed(w, r, upath) {
+		return
+	}
+
+	http.ServeFile(w, r, upath)
+}
+
+// setCacheHeaders sets the ETag and Cache-Control for the file at upath.
+func (f *fileHandler) setCacheHeaders(w http.ResponseWriter, r *http.Request, upath string) {
+	finfo, err := os.Stat(upath)
+	if err != nil {
+		return
 	}
+	etag, err := contentETag(upath, finfo)
+	if err != nil {
+		glog.Warningf("Failed to compute ETag: %s", err)
+	} else {
+		w.Header().Set("ETag", etag)
+	}
+	w.Header().Set("Cache-Control", f.site.CacheControl(strings.TrimPrefix(upath, path.Clean(f.dir))))
+}
 
-	http.ServeFile(w, r, path.Clean(upath))
+// servePrecompressed serves a compressed sibling of the file at upath, such
+// as foo.css.br or foo.css.gz, if there is one in an encoding that r
+// accepts, and returns true if it did. The ETag stays that of the
+// uncompressed file, compressHandler adds the encoding to it.
+func (f *fileHandler) servePrecompressed(w http.ResponseWriter, r *http.Request, upath string) bool {
+	if r.Header.Get("Range") != "" {
+		return false
+	}
+	contentType := w.Header().Get("Content-Type")
+	if contentType == "" {
+		contentType = mime.TypeByExtension(filepath.Ext(upath))
+	}
+	if contentType == "" {
+		return false
+	}
+	for _, coding := range acceptedEncodings(r) {
+		finfo, err := os.Stat(upath + encodingSuffixes[coding])
+		if err != nil || finfo.IsDir() || !f.allowedFile(upath+encodingSuffixes[coding]) {
+			continue
+		}
+		orig, err := os.Stat(upath)
+		if err != nil || orig.IsDir() || finfo.ModTime().Before(orig.ModTime()) {
+			// Don't serve a stale copy.
+			return false
+		}
+		file, err := os.Open(upath + encodingSuffixes[coding])
+		if err != nil {
+			continue
+		}
+		defer util.Close(file)
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Encoding", coding)
+		http.ServeContent(w, r, upath, orig.ModTime(), file)
+		return true
+	}
+	return false
 }
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/jcgregorio/userve/go/config"
+	"github.com/jcgregorio/userve/go/redirect"
+	"github.com/stretchr/testify/assert"
+)
+
+// testSite creates a site in a temporary directory, with a file outside of
+// it, returning the site directory and a function to clean up.
+func testSite(t *testing.T) (string, string, func()) {
+	dir, err := ioutil.TempDir("", "userve")
+	assert.NoError(t, err)
+	root := filepath.Join(dir, "site")
+	files := map[string]string{
+		"site/index.html":             "home",
+		"site/about.html":             "about",
+		"site/.env":                   "secret",
+		"site/.git/config":            "secret",
+		"site/.well-known/webfinger":  "finger",
+		"site/drafts/post.html":       "draft",
+		"site/news/drafts/post.html":  "draft",
+		"site/notes.bak":              "backup",
+		"site/redirects":              "/old /about 301",
+		"site/empty/.keep":            "",
+		"outside/passwd":              "secret",
+		"outside/public/shared.html":  "shared",
+		"site/news/2018/post.html":    "post",
+		"site/news/2018/post.json":    "{}",
+		"site/css/main.css":           "body{}",
+		"site/css/.secret/theme.css":  "body{}",
+		"site/images/.hidden.png":     "png",
+		"site/images/logo.png":        "png",
+		"site/feed/index.atom":        "<feed/>",
+		"site/private-in-root.txt":    "private",
+		"site/drafts-are-public.html": "public",
+	}
+	for name, contents := range files {
+		filename := filepath.Join(dir, name)
+		assert.NoError(t, os.MkdirAll(filepath.Dir(filename), 0755))
+		assert.NoError(t, ioutil.WriteFile(filename, []byte(contents), 0644))
+	}
+	assert.NoError(t, os.Symlink(filepath.Join(dir, "outside/passwd"), filepath.Join(root, "passwd")))
+	assert.NoError(t, os.Symlink(filepath.Join(dir, "outside/public"), filepath.Join(root, "shared")))
+	assert.NoError(t, os.Symlink(filepath.Join(root, "about.html"), filepath.Join(root, "about-us.html")))
+	assert.NoError(t, os.Symlink(filepath.Join(root, ".env"), filepath.Join(root, "env.txt")))
+	return dir, root, func() {
+		_ = os.RemoveAll(dir)
+	}
+}
+
+func testFileServer(t *testing.T, root, extra string) http.Handler {
+	c, err := config.Parse([]byte(`
+origins: [https://example.com]
+source: ` + root + `
+redirect_file: ` + filepath.Join(root, "redirects") + `
+private:
+  - drafts/
+  - "*.bak"
+  - /private-in-root.txt
+` + extra))
+	assert.NoError(t, err)
+	rules, errs := redirect.Load(c.Sites[0].RedirectFile)
+	assert.Empty(t, errs)
+	return FileServer(c.Sites[0], rules)
+}
+
+func get(h http.Handler, path string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest("GET", "https://example.com/", nil)
+	r.URL.Path = path
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestFileServerServesFiles(t *testing.T) {
+	_, root, cleanup := testSite(t)
+	defer cleanup()
+	h := testFileServer(t, root, "")
+
+	for path, body := range map[string]string{
+		"/":                      "home",
+		"/about":                 "about",
+		"/about-us":              "about",
+		"/.well-known/webfinger": "finger",
+		"/css/main.css":          "body{}",
+		"/news/2018/post":        "post",
+		"/feed/":                 "<feed/>",
+		"/drafts-are-public":     "public",
+		"/images/logo.png":       "png",
+		"/news/2018/post.json":   "{}",
+		"/news/2018/./post":      "post",
+		"/news//2018/post":       "post",
+	} {
+		w := get(h, path)
+		assert.Equal(t, http.StatusOK, w.Code, path)
+		assert.Equal(t, body, w.Body.String(), path)
+	}
+	w := get(h, "/old")
+	assert.Equal(t, http.StatusMovedPermanently, w.Code)
+}
+
+func TestFileServerRefusesTraversal(t *testing.T) {
+	_, root, cleanup := testSite(t)
+	defer cleanup()
+	h := testFileServer(t, root, "")
+
+	for _, path := range []string{
+		"/../outside/passwd",
+		"/../../../../etc/passwd",
+		"/css/../../outside/passwd",
+		"/..",
+		"/news/2018/../2018/post",
+		"/..\\outside\\passwd",
+		"/about\x00.html",
+	} {
+		w := get(h, path)
+		assert.Equal(t, http.StatusBadRequest, w.Code, path)
+		assert.NotContains(t, w.Body.String(), "secret", path)
+	}
+}
+
+func TestFileServerHidesPrivateFiles(t *testing.T) {
+	_, root, cleanup := testSite(t)
+	defer cleanup()
+	h := testFileServer(t, root, "")
+
+	for _, path := range []string{
+		// Dotfiles and directories.
+		"/.env",
+		"/.git/config",
+		"/.git/",
+		"/css/.secret/theme.css",
+		"/images/.hidden.png",
+		// Private globs.
+		"/drafts/post",
+		"/drafts/post.html",
+		"/drafts/",
+		"/news/drafts/post",
+		"/notes.bak",
+		"/private-in-root.txt",
+		// The redirect file.
+		"/redirects",
+		// A symlink inside the root to a dotfile.
+		"/env.txt",
+		// A directory without an index.
+		"/empty/",
+	} {
+		w := get(h, path)
+		assert.Equal(t, http.StatusNotFound, w.Code, path)
+		assert.NotContains(t, w.Body.String(), "secret", path)
+		assert.NotContains(t, w.Body.String(), "draft", path)
+	}
+
+	// Private files aren't suggested either.
+	w := get(h, "/.en")
+	assert.Equal(t, http.StatusNotFound, w.Code)
+	assert.NotContains(t, w.Body.String(), ".env")
+	w = get(h, "/draft")
+	assert.NotContains(t, w.Body.String(), "href=\"/drafts")
+}
+
+func TestFileServerSymlinks(t *testing.T) {
+	_, root, cleanup := testSite(t)
+	defer cleanup()
+
+	// Symlinks out of the root are refused by default.
+	h := testFileServer(t, root, "")
+	assert.Equal(t, http.StatusNotFound, get(h, "/passwd").Code)
+	assert.Equal(t, http.StatusNotFound, get(h, "/shared/shared").Code)
+	assert.Equal(t, http.StatusOK, get(h, "/about-us").Code)
+
+	// Unless allowed.
+	h = testFileServer(t, root, "allow_external_symlinks: true\n")
+	w := get(h, "/shared/shared")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "shared", w.Body.String())
+	assert.Equal(t, http.StatusOK, get(h, "/passwd").Code)
+
+	// A root that is itself a symlink is fine.
+	link := root + "-current"
+	assert.NoError(t, os.Symlink(root, link))
+	h = testFileServer(t, link, "")
+	w = get(h, "/about")
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "about", w.Body.String())
+	assert.Equal(t, http.StatusNotFound, get(h, "/passwd").Code)
+}
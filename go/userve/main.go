@@ -1,35 +1,40 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"flag"
 	"fmt"
 	"html/template"
-	"io/ioutil"
 	"net/http"
 	"os"
 	"path"
 	"strconv"
-	"strings"
 	"time"
 
 	units "github.com/docker/go-units"
-	"github.com/fiorix/go-web/autogzip"
 	"github.com/gorilla/mux"
-	lru "github.com/hashicorp/golang-lru"
+	"github.com/jcgregorio/userve/go/analytics"
+	"github.com/jcgregorio/userve/go/config"
 	"github.com/jcgregorio/userve/go/mention"
+	"github.com/jcgregorio/userve/go/referrers"
 	"github.com/skia-dev/glog"
+	bolt "go.etcd.io/bbolt"
 	"go.skia.org/infra/go/ds"
-	"go.skia.org/infra/go/httputils"
 	"rsc.io/letsencrypt"
 )
 
 // flags
 var (
-	port         = flag.String("port", ":8000", "HTTP service address (e.g., ':8000')")
-	sources      = flag.String("source", "", "The directory with the static resources to serve.")
-	local        = flag.Bool("local", false, "Running locally, not on the server. If false this runs letsencrypt.")
-	redirectFile = flag.String("redirect_file", "", "File of redirects, source and destination URL paths.")
+	port           = flag.String("port", ":8000", "HTTP service address (e.g., ':8000')")
+	sources        = flag.String("source", "", "The directory with the static resources to serve. Overrides the config, and only allowed with a single site.")
+	local          = flag.Bool("local", false, "Running locally, not on the server. If false this runs letsencrypt.")
+	redirectFile   = flag.String("redirect_file", "", "File of redirect rules, see the redirect package. Overrides the config, and only allowed with a single site.")
+	configFile     = flag.String("config", "", "The YAML config file. Defaults to the config for bitworking.org.")
+	storage        = flag.String("storage", "", "Where to store mentions, one of 'datastore', 'bolt', or 'memory'. Overrides the config. Defaults to 'memory' if -local, otherwise 'datastore'.")
+	storageFile    = flag.String("storage_file", "", "The database file to use when -storage=bolt. Overrides the config.")
+	reloadInterval = flag.Duration("reload_interval", 30*time.Second, "How often to check the config and redirect files for changes.")
+	migrate        = flag.Bool("migrate", false, "Migrate stored mentions from the legacy State to Verification and Moderation for every site, then exit.")
 )
 
 var (
@@ -52,10 +57,14 @@ var (
 			}
 			return s
 		},
+		// sanitized passes HTML through the mention sanitizer again, since
+		// stored mentions may predate it or not have been written by it, and
+		// marks the result as safe.
+		"sanitized": func(s string) template.HTML {
+			return template.HTML(mention.SanitizeHTML(s))
+		},
 	}).Parse(`
-	<section id=webmention>
-	<h3>WebMentions</h3>
-	{{ range . }}
+	{{ define "author" }}
 	    <span class="wm-author">
 				{{ if .AuthorURL }}
 					{{ if .Thumbnail }}
@@ -71,6 +80,9 @@ var (
 				{{ end }}
 			</span>
 			<time datetime="{{ .Published | rfc3999 }}">{{ .Published | humanTime }}</time>
+	{{ end }}
+	{{ define "mention" }}
+			{{ template "author" . }}
 			<a class="wm-content" href="{{ .Source }}" rel=nofollow>
 				{{ if .Title }}
 					{{ .Title | trunc }}
@@ -79,6 +91,64 @@ var (
 				{{ end }}
 			</a>
 	{{ end }}
+	{{ define "facepile" }}
+			{{ range . }}
+				<a href="{{ if .AuthorURL }}{{ .AuthorURL }}{{ else }}{{ .Source }}{{ end }}" rel=nofollow class="wm-face" title="{{ .Author }}">
+					{{ if .Thumbnail }}
+						<img src="/u/thumbnail/{{ .Thumbnail }}" alt="{{ .Author }}"/>
+					{{ else }}
+						{{ .Author }}
+					{{ end }}
+				</a>
+			{{ end }}
+	{{ end }}
+	{{ define "reply" }}
+			{{ template "author" . }}
+			<div class="wm-body">
+				{{ if .ContentHTML }}
+					{{ .ContentHTML | sanitized }}
+				{{ else if .Content }}
+					<p>{{ .Content }}</p>
+				{{ else }}
+					<a href="{{ .Source }}" rel=nofollow>{{ if .Title }}{{ .Title | trunc }}{{ else }}{{ .Source | trunc }}{{ end }}</a>
+				{{ end }}
+			</div>
+			{{ if .Truncated }}
+				<a class="wm-more" href="{{ .Source }}" rel=nofollow>Read more</a>
+			{{ end }}
+	{{ end }}
+	{{ define "thread" }}
+		<ul class="wm-replies">
+		{{ range . }}
+			<li class="wm-reply">
+				{{ template "reply" .Mention }}
+				{{ if .Replies }}{{ template "thread" .Replies }}{{ end }}
+			</li>
+		{{ end }}
+		</ul>
+	{{ end }}
+	<section id=webmention>
+	<h3>WebMentions</h3>
+	{{ if .Likes }}
+		<div class="wm-facepile wm-likes">
+			<h4>{{ len .Likes }} Likes</h4>
+			{{ template "facepile" .Likes }}
+		</div>
+	{{ end }}
+	{{ if .Reposts }}
+		<div class="wm-facepile wm-reposts">
+			<h4>{{ len .Reposts }} Reposts</h4>
+			{{ template "facepile" .Reposts }}
+		</div>
+	{{ end }}
+	{{ if .Replies }}
+		{{ template "thread" .Replies }}
+	{{ end }}
+	{{ range .Mentions }}
+		<div class="wm-mention wm-{{ .Type }}">
+			{{ template "mention" . }}
+		</div>
+	{{ end }}
 	</section>
 `))
 
@@ -95,7 +165,7 @@ var (
 			}
 			return " • " + units.HumanDuration(time.Now().Sub(t)) + " ago"
 		},
-	}).Parse(fmt.Sprintf(`<!DOCTYPE html>
+	}).Parse(`<!DOCTYPE html>
 <html>
 <head>
     <title></title>
@@ -103,13 +173,13 @@ var (
     <meta http-equiv="X-UA-Compatible" content="IE=egde,chrome=1">
     <meta name="viewport" content="width=device-width, initial-scale=1.0">
     <meta name="google-signin-scope" content="profile email">
-    <meta name="google-signin-client_id" content="%s">
+    <meta name="google-signin-client_id" content="{{ .ClientID }}">
     <script src="https://apis.google.com/js/platform.js" async defer></script>
 		<style type="text/css" media="screen">
 		  #webmentions {
 				display: grid;
 				padding: 1em;
-				grid-template-columns: 5em 10em 1fr;
+				grid-template-columns: 6em 6em 10em 1fr;
 				grid-column-gap: 10px;
 				grid-row-gap: 6px;
 			}
@@ -128,14 +198,16 @@ var (
   <div id=webmentions>
   {{range .Mentions }}
 		<select name="text" data-key="{{ .Key }}">
-			<option value="good" {{if eq .State "good" }}selected{{ end }} >Good</option>
-			<option value="spam" {{if eq .State "spam" }}selected{{ end }} >Spam</option>
-			<option value="untriaged" {{if eq .State "untriaged" }}selected{{ end }} >Untriaged</option>
+			<option value="approved" {{if eq .Moderation "approved" }}selected{{ end }} >Approved</option>
+			<option value="rejected" {{if eq .Moderation "rejected" }}selected{{ end }} >Rejected</option>
+			<option value="pending" {{if eq .Moderation "pending" }}selected{{ end }} >Pending</option>
 		</select>
+		<span class="verification-{{ .Verification }}">{{ if .Dead }}dead{{ else }}{{ .Verification }}{{ end }}</span>
 		<span>{{ .TS | humanTime }}</span>
 		<div>
 		  <div>Source: <a href="{{ .Source }}">{{ .Source | trunc }}</a></div>
 			<div>Target: <a href="{{ .Target }}">{{ .Target | trunc }}</a></div>
+			{{ if .Error }}<div>Error: {{ .Error }}{{ if .Attempts }} ({{ .Attempts }} attempts){{ end }}</div>{{ end }}
 		</div>
   {{end}}
   </div>
@@ -160,38 +232,21 @@ var (
 	 });
 	</script>
 </body>
-</html>`, CLIENT_ID)))
+</html>`))
 )
 
-func makeStaticHandler() func(http.ResponseWriter, *http.Request) {
-	redir := map[string]string{}
-	if *redirectFile != "" {
-		b, err := ioutil.ReadFile(*redirectFile)
-		if err != nil {
-			glog.Errorf("Failed to read redirects file: %s", err)
-		}
-		lines := strings.Split(string(b), "\n")
-		for _, line := range lines {
-			parts := strings.Split(line, " ")
-			if len(parts) != 2 {
-				glog.Errorf("Failed to get redirect with just two parts: %v", parts)
-				continue
-			}
-			redir[parts[0]] = parts[1]
-		}
-	}
-	glog.Infof("Launching with %d redirects.", len(redir))
-	fileServer := FileServer(*sources, redir)
-	return autogzip.HandleFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Add("Cache-Control", "max-age=300")
-		w.Header().Add("Link", "<https://bitworking.org/u/webmention>; rel=\"webmention\"")
+func makeStaticHandler(s *site) http.HandlerFunc {
+	glog.Infof("Launching %s with %d redirects.", s.Name, s.redirects.Len())
+	fileServer := FileServer(s.Site, s.redirects)
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Link", fmt.Sprintf("<%s>; rel=\"webmention\"", s.WebmentionEndpoint))
 		fileServer.ServeHTTP(w, r)
-	})
+	}
 }
 
 func LoggingRequestResponse(h http.Handler) http.HandlerFunc {
 	f := func(w http.ResponseWriter, r *http.Request) {
-		incRef(r.URL.Path, r.Referer())
+		incRef(siteFromRequest(r).Site, r.URL.Path, r.Referer())
 		h.ServeHTTP(w, r)
 	}
 	return f
@@ -206,13 +261,19 @@ func updateTriageHandler(w http.ResponseWriter, r *http.Request) {
 	isAdmin := *local || isAdmin(r)
 	if !isAdmin {
 		http.Error(w, "Unauthorized", 401)
+		return
 	}
 	var u UpdateMention
 	if err := json.NewDecoder(r.Body).Decode(&u); err != nil {
 		glog.Errorf("Failed to decode update: %s", err)
 		http.Error(w, "Bad JSON", 400)
+		return
+	}
+	if u.Value != mention.MODERATION_APPROVED && u.Value != mention.MODERATION_REJECTED && u.Value != mention.MODERATION_PENDING {
+		http.Error(w, "Unknown moderation value", 400)
+		return
 	}
-	if err := mention.UpdateState(r.Context(), u.Key, u.Value); err != nil {
+	if err := mention.UpdateModeration(r.Context(), u.Key, u.Value); err != nil {
 		glog.Errorf("Failed to write update: %s", err)
 		http.Error(w, "Failed to write", 400)
 	}
@@ -233,6 +294,34 @@ func thumbnailHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// mentionsContext is the context for mentionsTemplate, with the mentions
+// split up by how they are displayed.
+type mentionsContext struct {
+	Likes    []*mention.Mention
+	Reposts  []*mention.Mention
+	Replies  []*mention.Thread
+	Mentions []*mention.Mention
+}
+
+func newMentionsContext(mentions []*mention.Mention) *mentionsContext {
+	ret := &mentionsContext{}
+	replies := []*mention.Mention{}
+	for _, m := range mentions {
+		switch m.Type {
+		case mention.LIKE_TYPE:
+			ret.Likes = append(ret.Likes, m)
+		case mention.REPOST_TYPE:
+			ret.Reposts = append(ret.Reposts, m)
+		case mention.REPLY_TYPE:
+			replies = append(replies, m)
+		default:
+			ret.Mentions = append(ret.Mentions, m)
+		}
+	}
+	ret.Replies = mention.Threads(replies)
+	return ret
+}
+
 // mentionsHandler returns HTML describing all the good Webmentions for the given URL.
 func mentionsHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "text/html")
@@ -240,7 +329,7 @@ func mentionsHandler(w http.ResponseWriter, r *http.Request) {
 	if len(m) == 0 {
 		return
 	}
-	if err := mentionsTemplate.Execute(w, m); err != nil {
+	if err := mentionsTemplate.Execute(w, newMentionsContext(m)); err != nil {
 		glog.Errorf("Failed to expand template: %s", err)
 	}
 }
@@ -248,47 +337,90 @@ func mentionsHandler(w http.ResponseWriter, r *http.Request) {
 // webmentionHandler handles incoming Webmentions.
 func webmentionHandler(w http.ResponseWriter, r *http.Request) {
 	m := mention.New(r.FormValue("source"), r.FormValue("target"))
-	if err := m.FastValidate(); err != nil {
+	if err := m.FastValidate(siteFromRequest(r).TargetHosts); err != nil {
 		glog.Infof("Invalid request: %s", err)
 		http.Error(w, fmt.Sprintf("Invalid request: %s", err), 400)
 		return
 	}
-	if err := mention.Put(r.Context(), m); err != nil {
+	if err := mention.Enqueue(r.Context(), m); err != nil {
 		glog.Errorf("Failed to enqueue mention: %s", err)
 		http.Error(w, fmt.Sprintf("Failed to enqueue mention"), 400)
 		return
 	}
-	w.WriteHeader(http.StatusAccepted)
+	w.Header().Set("Location", "/u/webmention/status/"+m.ID())
+	w.WriteHeader(http.StatusCreated)
+}
+
+// webmentionStatus is the JSON response from webmentionStatusHandler.
+type webmentionStatus struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// webmentionStatusHandler reports the status of a Webmention, the URL of
+// which is returned in the Location header from webmentionHandler.
+func webmentionStatusHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	m, err := mention.Get(r.Context(), mux.Vars(r)["id"])
+	if err != nil {
+		glog.Infof("Failed to find mention: %s", err)
+		http.Error(w, "Mention not found", 404)
+		return
+	}
+	status := webmentionStatus{
+		Source: m.Source,
+		Target: m.Target,
+		Status: m.Status(),
+	}
+	if status.Status == mention.REJECTED_STATUS {
+		status.Error = m.Error
+	}
+	if err := json.NewEncoder(w).Encode(status); err != nil {
+		glog.Errorf("Failed to write status: %s", err)
+	}
 }
 
 func StartMentionRoutine(c *http.Client) {
 	for _ = range time.Tick(time.Minute) {
-		mention.VerifyQueuedMentions(c)
+		for _, site := range getConfig().Sites {
+			mention.VerifyQueuedMentions(mention.WithNamespace(context.Background(), site.Namespace), c)
+		}
 	}
 }
 
+// StartAtomMonitor sends Webmentions for the entries of the Atom feed of
+// each site whenever it changes.
 func StartAtomMonitor(c *http.Client) {
-	lastModified := time.Time{}
-	filename := path.Join(*sources, "news", "feed", "index.atom")
+	// Keyed by namespace and feed, so a site that is reconfigured with a new
+	// feed or namespace is processed again.
+	lastModified := map[string]time.Time{}
 	for _ = range time.Tick(time.Minute) {
-		glog.Info("Checking Atom Feed")
-		st, err := os.Stat(filename)
-		if err != nil {
-			glog.Errorf("Failed to stat Atom feed: %s", err)
-			continue
-		}
-		if st.ModTime().After(lastModified) {
-			lastModified = st.ModTime()
-			if err := mention.ProcessAtomFeed(c, filename); err != nil {
-				glog.Errorf("Failed to process Atom feed: %s", err)
+		for _, site := range getConfig().Sites {
+			glog.Infof("Checking Atom Feed for %s", site.Name)
+			filename := path.Join(site.Source, site.Feed)
+			key := site.Namespace + " " + filename
+			st, err := os.Stat(filename)
+			if err != nil {
+				glog.Errorf("Failed to stat Atom feed: %s", err)
+				continue
+			}
+			if st.ModTime().After(lastModified[key]) {
+				lastModified[key] = st.ModTime()
+				ctx := mention.WithNamespace(context.Background(), site.Namespace)
+				if err := mention.ProcessAtomFeed(ctx, c, filename); err != nil {
+					glog.Errorf("Failed to process Atom feed: %s", err)
+				}
+			} else {
+				glog.Info("Atom Feed Unmodified.")
 			}
-		} else {
-			glog.Info("Atom Feed Unmodified.")
 		}
 	}
 }
 
 type triageContext struct {
+	ClientID string
 	IsAdmin  bool
 	Mentions []*mention.MentionWithKey
 	Offset   int64
@@ -297,7 +429,9 @@ type triageContext struct {
 // triageHandler displays the triage page for Webmentions.
 func triageHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "text/html")
-	context := &triageContext{}
+	context := &triageContext{
+		ClientID: getConfig().Admin.ClientID,
+	}
 	isAdmin := *local || isAdmin(r)
 	if isAdmin {
 		limitText := r.FormValue("limit")
@@ -319,6 +453,7 @@ func triageHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 		context = &triageContext{
+			ClientID: getConfig().Admin.ClientID,
 			IsAdmin:  isAdmin,
 			Mentions: mention.GetTriage(r.Context(), int(limit), int(offset)),
 			Offset:   offset + limit,
@@ -332,45 +467,96 @@ func triageHandler(w http.ResponseWriter, r *http.Request) {
 func main() {
 	flag.Parse()
 	defer glog.Flush()
-	var err error
-	if *sources == "" {
-		*sources, err = os.Getwd()
+	cfg, err := loadConfig()
+	if err != nil {
+		glog.Fatalf("Failed to load config: %s", err)
+	}
+	refs, err = referrers.NewAggregator(REF_CACHE_SIZE)
+	if err != nil {
+		glog.Fatalf("Failed to initialize referrer counts: %s", err)
+	}
+
+	switch cfg.Storage.Type {
+	case config.DATASTORE_STORAGE:
+		if cfg.Storage.Project == "" {
+			glog.Fatalf("A storage project is required for Datastore.")
+		}
+		if err := ds.Init(cfg.Storage.Project, cfg.Storage.Namespace); err != nil {
+			glog.Fatalf("Failed to initialize Datastore: %s", err)
+		}
+		mention.Init(mention.NewDatastoreStore())
+		refStore = referrers.NewDatastoreStore()
+		viewStore = analytics.NewDatastoreStore()
+	case config.BOLT_STORAGE:
+		db, err := bolt.Open(cfg.Storage.File, 0600, &bolt.Options{Timeout: time.Second})
 		if err != nil {
-			glog.Fatalf("Can't find working directory: %s", err)
+			glog.Fatalf("Failed to open %q: %s", cfg.Storage.File, err)
 		}
-
+		s, err := mention.NewBoltStoreFromDB(db)
+		if err != nil {
+			glog.Fatalf("Failed to open mention storage: %s", err)
+		}
+		mention.Init(s)
+		refStore, err = referrers.NewBoltStore(db)
+		if err != nil {
+			glog.Fatalf("Failed to open referrer storage: %s", err)
+		}
+		viewStore, err = analytics.NewBoltStore(db)
+		if err != nil {
+			glog.Fatalf("Failed to open view storage: %s", err)
+		}
+	case config.MEMORY_STORAGE:
+		mention.Init(mention.NewMemoryStore())
+		refStore = referrers.NewMemoryStore()
+		viewStore = analytics.NewMemoryStore()
+	default:
+		glog.Fatalf("Unknown storage type: %q", cfg.Storage.Type)
 	}
-	cache, err = lru.New(5000)
-	if err != nil {
-		glog.Fatalf("Failed to initialize log cache: %s", err)
+	if *migrate {
+		for _, site := range cfg.Sites {
+			n, err := mention.Migrate(mention.WithNamespace(context.Background(), site.Namespace))
+			if err != nil {
+				glog.Fatalf("Failed to migrate mentions for %s: %s", site.Name, err)
+			}
+			glog.Infof("Migrated %d mentions for %s.", n, site.Name)
+		}
+		return
 	}
+	state.Store(newServerState(cfg))
+	go StartReloader()
+	go StartFlusher()
 
-	ds.Init("heroic-muse-88515", "blog")
-	c := httputils.NewTimeoutClient()
+	// Sources, photos, and webmention endpoints are all URLs supplied by
+	// others, so only fetch them with the hardened client.
+	c := mention.NewSafeClient()
 	go StartMentionRoutine(c)
 	go StartAtomMonitor(c)
 
 	r := mux.NewRouter()
 	u := r.PathPrefix("/u").Subrouter()
 	u.HandleFunc("/ref", refHandler)
+	u.HandleFunc("/views", viewsHandler)
 	u.HandleFunc("/webmention", webmentionHandler).Methods("POST")
+	u.HandleFunc("/webmention/status/{id:[a-f0-9]+}", webmentionStatusHandler).Methods("GET")
 	u.HandleFunc("/mentions", mentionsHandler)
 	u.HandleFunc("/triage", triageHandler)
 	u.HandleFunc("/updateMention", updateTriageHandler)
 	u.HandleFunc("/thumbnail/{id:[a-z0-9]+}", thumbnailHandler)
 
-	r.PathPrefix("/").HandlerFunc(makeStaticHandler())
-	http.HandleFunc("/", LoggingRequestResponse(r))
+	r.PathPrefix("/").HandlerFunc(countViews(staticHandler))
+	http.HandleFunc("/", siteHandler(LoggingRequestResponse(compressHandler(r))))
 
 	// TODO Also do login and handle comments.
 
 	if *local {
 		glog.Fatal(http.ListenAndServe(*port, nil))
 	} else {
-		var m letsencrypt.Manager
-		if err := m.CacheFile("/home/jcgregorio/letsencrypt.cache"); err != nil {
+		m := &letsencrypt.Manager{}
+		if err := m.CacheFile(cfg.TLS.CacheFile); err != nil {
 			glog.Fatal(err)
 		}
+		m.SetHosts(cfg.TLS.Hosts)
+		setTLSManager(m)
 		glog.Fatal(m.Serve())
 	}
 }
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/jcgregorio/userve/go/config"
+	"github.com/jcgregorio/userve/go/mention"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUpdateTriageHandler(t *testing.T) {
+	mention.Init(mention.NewMemoryStore())
+	ctx := context.Background()
+	assert.NoError(t, mention.Put(ctx, mention.New("https://example.org/reply", "https://example.com/post")))
+	key := mention.GetTriage(ctx, 10, 0)[0].Key
+	oldLocal := *local
+	defer func() {
+		*local = oldLocal
+	}()
+
+	update := func(body string) int {
+		w := httptest.NewRecorder()
+		updateTriageHandler(w, httptest.NewRequest("POST", "/u/updateMention", strings.NewReader(body)))
+		return w.Code
+	}
+	approve := `{"key": "` + key + `", "value": "approved"}`
+
+	// Without an id_token cookie the update is refused and not made.
+	*local = false
+	assert.Equal(t, http.StatusUnauthorized, update(approve))
+	assert.Equal(t, mention.MODERATION_PENDING, mention.GetTriage(ctx, 10, 0)[0].Moderation)
+
+	*local = true
+	assert.Equal(t, http.StatusBadRequest, update(`{"key": `))
+	assert.Equal(t, http.StatusBadRequest, update(`{"key": "`+key+`", "value": "spam"}`))
+	assert.Equal(t, mention.MODERATION_PENDING, mention.GetTriage(ctx, 10, 0)[0].Moderation)
+	assert.Equal(t, http.StatusOK, update(approve))
+	assert.Equal(t, mention.MODERATION_APPROVED, mention.GetTriage(ctx, 10, 0)[0].Moderation)
+}
+
+func TestMentionsHandlerSanitizes(t *testing.T) {
+	mention.Init(mention.NewMemoryStore())
+	ctx := context.Background()
+	m := mention.New("https://example.org/reply", "https://example.com/post")
+	m.Type = mention.REPLY_TYPE
+	m.Verification = mention.VERIFY_VERIFIED
+	m.Moderation = mention.MODERATION_APPROVED
+	// As stored before content was sanitized, or by something else.
+	m.ContentHTML = `<p onclick="evil()">Nice post<script>alert(1)</script></p>`
+	assert.NoError(t, mention.Put(ctx, m))
+
+	r := httptest.NewRequest("GET", "/u/mentions", nil)
+	r.Header.Set("Referer", m.Target)
+	w := httptest.NewRecorder()
+	mentionsHandler(w, r)
+	assert.Contains(t, w.Body.String(), "<p>Nice post</p>")
+	assert.NotContains(t, w.Body.String(), "script")
+	assert.NotContains(t, w.Body.String(), "onclick")
+}
+
+func TestWebmentionHandlers(t *testing.T) {
+	cfg, err := config.Parse([]byte(`
+sites:
+  - name: example
+    origins: [https://example.com]
+    source: /tmp
+`))
+	assert.NoError(t, err)
+	state.Store(newServerState(cfg))
+	mention.Init(mention.NewMemoryStore())
+
+	r := mux.NewRouter()
+	r.HandleFunc("/u/webmention", webmentionHandler).Methods("POST")
+	r.HandleFunc("/u/webmention/status/{id:[a-f0-9]+}", webmentionStatusHandler).Methods("GET")
+	h := siteHandler(r)
+
+	form := url.Values{
+		"source": {"https://example.org/reply"},
+		"target": {"https://example.com/post"},
+	}
+	req := httptest.NewRequest("POST", "/u/webmention", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Host = "example.com"
+	w := httptest.NewRecorder()
+	h(w, req)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	m := mention.New("https://example.org/reply", "https://example.com/post")
+	location := "/u/webmention/status/" + m.ID()
+	assert.Equal(t, location, w.Header().Get("Location"))
+
+	req = httptest.NewRequest("GET", location, nil)
+	req.Host = "example.com"
+	w = httptest.NewRecorder()
+	h(w, req)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	var status webmentionStatus
+	assert.NoError(t, json.NewDecoder(w.Body).Decode(&status))
+	assert.Equal(t, webmentionStatus{
+		Source: "https://example.org/reply",
+		Target: "https://example.com/post",
+		Status: mention.QUEUED_STATUS,
+	}, status)
+
+	req = httptest.NewRequest("GET", "/u/webmention/status/abc123", nil)
+	req.Host = "example.com"
+	w = httptest.NewRecorder()
+	h(w, req)
+	assert.Equal(t, http.StatusNotFound, w.Code)
+}
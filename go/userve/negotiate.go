@@ -0,0 +1,110 @@
+package main
+
+import (
+	"mime"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// variant is one of the files an extensionless URL can be served from.
+type variant struct {
+	ext         string
+	contentType string
+}
+
+// variants are the files tried for an extensionless URL such as /post, in
+// order of preference when the client doesn't prefer one over another.
+var variants = []variant{
+	{ext: ".html", contentType: "text/html; charset=utf-8"},
+	{ext: ".atom", contentType: "application/atom+xml"},
+	{ext: ".json", contentType: "application/json"},
+	{ext: ".md", contentType: "text/markdown; charset=utf-8"},
+}
+
+// indexVariants are the files tried for a directory, after "index".
+var indexVariants = variants[:3]
+
+// mediaRange is a single media range from an Accept header.
+type mediaRange struct {
+	mediaType string
+	q         float64
+}
+
+// parseAccept parses an Accept header, an empty one accepts anything.
+func parseAccept(accept string) []mediaRange {
+	if strings.TrimSpace(accept) == "" {
+		return []mediaRange{{mediaType: "*/*", q: 1}}
+	}
+	ret := []mediaRange{}
+	for _, part := range strings.Split(accept, ",") {
+		fields := strings.Split(part, ";")
+		mr := mediaRange{
+			mediaType: strings.ToLower(strings.TrimSpace(fields[0])),
+			q:         1,
+		}
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if v, err := strconv.ParseFloat(param[2:], 64); err == nil {
+					mr.q = v
+				}
+			}
+		}
+		ret = append(ret, mr)
+	}
+	return ret
+}
+
+// quality returns how much the client accepts contentType, from the most
+// specific media range that matches it.
+func quality(ranges []mediaRange, contentType string) float64 {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return 0
+	}
+	major := strings.Split(mediaType, "/")[0]
+	q, specificity := 0.0, -1
+	for _, mr := range ranges {
+		s := -1
+		switch mr.mediaType {
+		case mediaType:
+			s = 2
+		case major + "/*":
+			s = 1
+		case "*/*":
+			s = 0
+		}
+		if s > specificity {
+			q, specificity = mr.q, s
+		}
+	}
+	return q
+}
+
+// negotiate picks the file to serve for base, one of base plus the
+// extension of each of vs, by the Accept header of r. It returns the
+// file and its Content-Type, or "" if none of them exist.
+//
+// If the client accepts none of the files the first that exists is served
+// anyway, since a page it didn't ask for is more useful than a 406.
+func negotiate(r *http.Request, base string, vs []variant) (string, string) {
+	ranges := parseAccept(r.Header.Get("Accept"))
+	var best *variant
+	bestQ := 0.0
+	for i, v := range vs {
+		fi, err := os.Stat(base + v.ext)
+		if err != nil || fi.IsDir() {
+			continue
+		}
+		q := quality(ranges, v.contentType)
+		if best == nil || q > bestQ {
+			best, bestQ = &vs[i], q
+		}
+	}
+	if best == nil {
+		return "", ""
+	}
+	return base + best.ext, best.contentType
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// negotiateSite creates a site with several variants of some files in a
+// temporary directory, returning the directory and a function to clean up.
+func negotiateSite(t *testing.T) (string, func()) {
+	root, err := ioutil.TempDir("", "userve")
+	assert.NoError(t, err)
+	for name, contents := range map[string]string{
+		"redirects":           "",
+		"post.html":           "html",
+		"post.atom":           "atom",
+		"post.json":           "json",
+		"post.md":             "md",
+		"notes.md":            "md",
+		"all/index.html":      "html index",
+		"all/index.atom":      "atom index",
+		"all/index.json":      "json index",
+		"feed/index.atom":     "atom index",
+		"feed/index.json":     "json index",
+		"data/index.json":     "json index",
+		"empty/nothing.txt":   "",
+		"markdown/index.md":   "md index",
+		"index.html":          "home",
+		"all/child/index.htm": "",
+	} {
+		filename := filepath.Join(root, name)
+		assert.NoError(t, os.MkdirAll(filepath.Dir(filename), 0755))
+		assert.NoError(t, ioutil.WriteFile(filename, []byte(contents), 0644))
+	}
+	return root, func() {
+		_ = os.RemoveAll(root)
+	}
+}
+
+func TestParseAccept(t *testing.T) {
+	assert.Equal(t, []mediaRange{{"*/*", 1}}, parseAccept(""))
+	assert.Equal(t, []mediaRange{
+		{"text/html", 1},
+		{"application/atom+xml", 0.5},
+		{"*/*", 0.1},
+		{"text/markdown", 1},
+	}, parseAccept("Text/HTML, application/atom+xml;q=0.5, */* ; q=0.1, text/markdown;q=x"))
+}
+
+func TestQuality(t *testing.T) {
+	ranges := parseAccept("text/*;q=0.5, text/html;q=0.8, application/json;q=0, */*;q=0.1")
+	for contentType, want := range map[string]float64{
+		// The most specific range wins, whatever its order or q.
+		"text/html; charset=utf-8":     0.8,
+		"text/markdown; charset=utf-8": 0.5,
+		"application/json":             0,
+		"application/atom+xml":         0.1,
+		"not a type;;":                 0,
+	} {
+		assert.Equal(t, want, quality(ranges, contentType), contentType)
+	}
+	// Nothing matches.
+	assert.Equal(t, 0.0, quality(parseAccept("text/html"), "application/json"))
+}
+
+func TestNegotiate(t *testing.T) {
+	root, cleanup := negotiateSite(t)
+	defer cleanup()
+	negotiateWith := func(accept, base string, vs []variant) (string, string) {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("Accept", accept)
+		filename, contentType := negotiate(r, filepath.Join(root, base), vs)
+		if filename == "" {
+			return "", ""
+		}
+		return filepath.Base(filename), contentType
+	}
+	for _, test := range []struct {
+		accept, base string
+		want         string
+	}{
+		{"", "post", "post.html"},
+		{"text/html,application/xhtml+xml,*/*;q=0.8", "post", "post.html"},
+		{"application/atom+xml", "post", "post.atom"},
+		{"application/json, text/html;q=0.5", "post", "post.json"},
+		{"text/markdown", "post", "post.md"},
+		{"text/*", "post", "post.html"},
+		// Nothing acceptable exists, so the first that does is served.
+		{"image/png", "post", "post.html"},
+		{"text/html", "notes", "notes.md"},
+		{"", "missing", ""},
+	} {
+		filename, _ := negotiateWith(test.accept, test.base, variants)
+		assert.Equal(t, test.want, filename, test.accept+" "+test.base)
+	}
+	_, contentType := negotiateWith("application/atom+xml", "post", variants)
+	assert.Equal(t, "application/atom+xml", contentType)
+}
+
+func TestFileServerNegotiates(t *testing.T) {
+	root, cleanup := negotiateSite(t)
+	defer cleanup()
+	h := testFileServer(t, root, "")
+	getAccept := func(path, accept string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "https://example.com"+path, nil)
+		r.Header.Set("Accept", accept)
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+		return w
+	}
+
+	for _, test := range []struct {
+		path, accept string
+		status       int
+		body         string
+		contentType  string
+	}{
+		{"/post", "", http.StatusOK, "html", "text/html; charset=utf-8"},
+		{"/post", "application/atom+xml", http.StatusOK, "atom", "application/atom+xml"},
+		{"/post", "application/json", http.StatusOK, "json", "application/json"},
+		{"/notes", "", http.StatusOK, "md", "text/markdown; charset=utf-8"},
+		// Directories fall back from index.html to index.atom to index.json.
+		{"/all/", "", http.StatusOK, "html index", "text/html; charset=utf-8"},
+		{"/all/", "application/json", http.StatusOK, "json index", "application/json"},
+		{"/feed/", "", http.StatusOK, "atom index", "application/atom+xml"},
+		{"/data/", "text/html", http.StatusOK, "json index", "application/json"},
+		// Markdown isn't an index variant, and directories aren't listed.
+		{"/markdown/", "", http.StatusNotFound, "", ""},
+		{"/empty/", "", http.StatusNotFound, "", ""},
+		{"/missing", "", http.StatusNotFound, "", ""},
+	} {
+		w := getAccept(test.path, test.accept)
+		assert.Equal(t, test.status, w.Code, test.path+" "+test.accept)
+		assert.Equal(t, "Accept", w.Header().Get("Vary"), test.path)
+		if test.status == http.StatusOK {
+			assert.Equal(t, test.body, w.Body.String(), test.path+" "+test.accept)
+			assert.Equal(t, test.contentType, w.Header().Get("Content-Type"), test.path+" "+test.accept)
+		}
+	}
+
+	// Files with an extension aren't negotiated.
+	w := getAccept("/post.json", "text/html")
+	assert.Equal(t, "json", w.Body.String())
+	assert.Equal(t, "", w.Header().Get("Vary"))
+
+	// Directories without the trailing slash are redirected to it.
+	w = getAccept("/all", "")
+	assert.Equal(t, http.StatusMovedPermanently, w.Code)
+	assert.Equal(t, "/all/", w.Header().Get("Location"))
+	w = getAccept("/all/child?x=1", "")
+	assert.Equal(t, http.StatusMovedPermanently, w.Code)
+	assert.Equal(t, "/all/child/?x=1", w.Header().Get("Location"))
+}
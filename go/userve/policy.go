@@ -0,0 +1,81 @@
+package main
+
+import (
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/skia-dev/glog"
+)
+
+// refuseURLPath returns the status to reply with if urlPath must not be
+// served, or 0 if it may be. Paths that try to climb out of the root are
+// bad requests, while private ones, see config.Site.Private, are treated
+// as if they don't exist.
+func (f *fileHandler) refuseURLPath(urlPath string) int {
+	if strings.Contains(urlPath, "\x00") || strings.Contains(urlPath, "\\") {
+		return http.StatusBadRequest
+	}
+	for _, seg := range strings.Split(urlPath, "/") {
+		if seg == ".." {
+			return http.StatusBadRequest
+		}
+	}
+	if f.site.IsPrivate(urlPath) {
+		return http.StatusNotFound
+	}
+	return 0
+}
+
+// within returns true if filename is root or inside it.
+func within(root, filename string) bool {
+	rel, err := filepath.Rel(root, filename)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, "../")
+}
+
+// allowedFile returns true if the existing file at filename, inside the
+// site Source, may be served. It must not be private, or be the config or
+// redirect file, and any symlinks along the way must stay inside Source
+// unless config.Site.AllowExternalSymlinks is set.
+func (f *fileHandler) allowedFile(filename string) bool {
+	root, err := filepath.Abs(f.dir)
+	if err != nil {
+		glog.Errorf("Failed to find the root of %q: %s", f.dir, err)
+		return false
+	}
+	abs, err := filepath.Abs(filename)
+	if err != nil || !within(root, abs) {
+		return false
+	}
+	for _, private := range []string{*configFile, f.site.RedirectFile} {
+		if private == "" {
+			continue
+		}
+		if p, err := filepath.Abs(private); err == nil && p == abs {
+			return false
+		}
+	}
+	if f.site.IsPrivate(filepath.ToSlash(strings.TrimPrefix(abs, root))) {
+		return false
+	}
+
+	// Root itself may be a symlink, such as to the current release of the
+	// site, so compare the resolved paths.
+	realRoot, err := filepath.EvalSymlinks(root)
+	if err != nil {
+		glog.Errorf("Failed to resolve the root %q: %s", root, err)
+		return false
+	}
+	real, err := filepath.EvalSymlinks(abs)
+	if err != nil {
+		return false
+	}
+	if !within(realRoot, real) {
+		if !f.site.AllowExternalSymlinks {
+			glog.Warningf("Refusing to follow symlink %q out of the root to %q", abs, real)
+			return false
+		}
+		return true
+	}
+	return !f.site.IsPrivate(filepath.ToSlash(strings.TrimPrefix(real, realRoot)))
+}
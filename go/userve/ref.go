@@ -1,31 +1,61 @@
 package main
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"html/template"
 	"net/http"
-	"sort"
-	"strings"
+	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
 	"time"
 
-	lru "github.com/hashicorp/golang-lru"
+	"github.com/jcgregorio/userve/go/config"
+	"github.com/jcgregorio/userve/go/referrers"
 	"github.com/skia-dev/glog"
 	"go.skia.org/infra/go/httputils"
 )
 
-type cacheEntry map[string]int
-
 const (
-	CLIENT_ID = "952643138919-jh0117ivtbqkc9njoh91csm7s465c4na.apps.googleusercontent.com"
+	// REF_CACHE_SIZE is about the most paths, per hour, buffered before the
+	// least recently used ones are set aside to be written out.
+	REF_CACHE_SIZE = 5000
+
+	// REF_FLUSH_INTERVAL is how often buffered counts are written to
+	// refStore.
+	REF_FLUSH_INTERVAL = time.Minute
+
+	// HOURLY_RETENTION is how long hourly counts are kept. Daily counts are
+	// kept forever.
+	HOURLY_RETENTION = 30 * 24 * time.Hour
+
+	// MAX_REF_DAYS is the most days of history shown on /u/ref.
+	MAX_REF_DAYS = 3650
+
+	// MAX_HOURLY_REF_DAYS is the most days shown on /u/ref by the hour.
+	MAX_HOURLY_REF_DAYS = int(HOURLY_RETENTION / (24 * time.Hour))
+
+	// DEFAULT_REF_DAYS is the number of days shown on /u/ref by default.
+	DEFAULT_REF_DAYS = 7
+
+	// REF_DATE_FORMAT is the format of the from and to dates of /u/ref.
+	REF_DATE_FORMAT = "2006-01-02"
+)
 
-	NO_REFERRER = "∅"
+var (
+	// refs buffers the referrer counts of recent requests until they are
+	// written to refStore.
+	refs *referrers.Aggregator
+
+	// refStore is where referrer counts are kept.
+	refStore referrers.Store
 )
 
 var (
-	cache       *lru.Cache
 	refTemplate *template.Template
-	refSource   = fmt.Sprintf(`<!DOCTYPE html>
+	refSource   = `<!DOCTYPE html>
 <html>
 <head>
     <title></title>
@@ -33,10 +63,11 @@ var (
     <meta http-equiv="X-UA-Compatible" content="IE=egde,chrome=1">
     <meta name="viewport" content="width=device-width, initial-scale=1.0">
     <meta name="google-signin-scope" content="profile email">
-    <meta name="google-signin-client_id" content="%s">
+    <meta name="google-signin-client_id" content="{{ .ClientID }}">
     <script src="https://apis.google.com/js/platform.js" async defer></script>
 </head>
 <body>
+  <nav>Referrers | <a href="/u/views">Views</a></nav>
   <div class="g-signin2" data-onsuccess="onSignIn" data-theme="dark"></div>
     <script>
       function onSignIn(googleUser) {
@@ -46,56 +77,146 @@ var (
         }
       };
     </script>
-  <dl>
-  {{range .Summary}}
-    <details>
-      <summary>{{.Total}} - <a href="{{.Path}}">{{.Path}}</a></summary>
-      <ul>
-        {{range $url, $count :=   .Referrers}}
-        <li>{{$count}} <a href="{{$url}}">{{$url}}</a> </li>
-        {{end}}
-      </ul>
-    </details>
+  <form method="get">
+    From <input type="date" name="from" value="{{.From}}">
+    to <input type="date" name="to" value="{{.To}}">
+    <select name="granularity">
+      {{range .Granularities}}
+      <option {{if $.Report}}{{if eq . $.Report.Granularity}}selected{{end}}{{end}}>{{.}}</option>
+      {{end}}
+    </select>
+    <input type="submit" value="Show">
+    or the last
+    {{range .Ranges}}
+      <a href="?days={{.}}">{{if eq . 1}}day{{else}}{{.}} days{{end}}</a>
+    {{end}}
+  </form>
+  {{with .Report}}
+  <h2>{{.All.Total}} requests <small>{{signed .All.Change}} on the previous period</small></h2>
+  {{sparkline .All.Hits 600 80}}
+
+  <h3>Top movers</h3>
+  <table>
+    <tr><th>Path</th><th>Previous</th><th>Now</th><th>Change</th></tr>
+    {{range .PathMovers}}
+    <tr><td><a href="{{.Name}}">{{.Name}}</a></td><td>{{.Previous}}</td><td>{{.Total}}</td><td>{{signed .Change}}</td></tr>
+    {{end}}
+  </table>
+  <table>
+    <tr><th>Referrer domain</th><th>Previous</th><th>Now</th><th>Change</th></tr>
+    {{range .DomainMovers}}
+    <tr><td>{{.Name}}</td><td>{{.Previous}}</td><td>{{.Total}}</td><td>{{signed .Change}}</td></tr>
+    {{end}}
+  </table>
+
+  <h3>Paths</h3>
+  <table>
+    {{range .Paths}}
+    <tr>
+      <td>{{sparkline .Hits 120 20}}</td>
+      <td>{{.Total}}</td>
+      <td>
+        <details>
+          <summary><a href="{{.Name}}">{{.Name}}</a></summary>
+          <ul>
+            {{range $url, $count := .Referrers}}
+            <li>{{$count}} <a href="{{$url}}">{{$url}}</a> </li>
+            {{end}}
+          </ul>
+        </details>
+      </td>
+    </tr>
+    {{end}}
+  </table>
+
+  <h3>Referrer categories</h3>
+  <table>
+    {{range .Categories}}
+    <tr><td>{{sparkline .Hits 120 20}}</td><td>{{.Total}}</td><td>{{.Name}}</td><td>{{signed .Change}}</td></tr>
+    {{end}}
+  </table>
+
+  <h3>Referrer domains</h3>
+  <table>
+    {{range .Domains}}
+    <tr><td>{{sparkline .Hits 120 20}}</td><td>{{.Total}}</td><td>{{.Name}}</td></tr>
+    {{end}}
+  </table>
   {{end}}
-  </dl>
 </body>
 </html>
-`, CLIENT_ID)
+`
 	client *http.Client
 )
 
-func incRef(path, referrer string) {
+// sitePath returns the path counted for a request to site, which includes
+// the origin when there's more than one site to keep their paths apart.
+func sitePath(site *config.Site, path string) string {
+	if len(getConfig().Sites) > 1 {
+		return site.Origins[0] + path
+	}
+	return path
+}
+
+func incRef(site *config.Site, path, referrer string) {
 	glog.Infof("Request: %s %s", path, referrer)
-	if strings.HasPrefix(referrer, "https://bitworking.org") {
+	if site.IsOwnURL(referrer) {
 		return
 	}
-	var entry cacheEntry
-	ientry, ok := cache.Get(path)
-	if !ok {
-		entry = cacheEntry{}
-		cache.Add(path, entry)
-	} else {
-		entry, ok = ientry.(cacheEntry)
-		if !ok {
-			glog.Error("Wrong thing in cache?: %v", ientry)
-			return
-		}
+	path = sitePath(site, path)
+	if referrer == "" {
+		referrer = referrers.NO_REFERRER
+	} else if state.Load().(*serverState).blocklist.Blocked(referrer) {
+		glog.Infof("Not counting spam referrer: %s", referrer)
+		return
 	}
-	if referrer != "" {
-		entry[referrer] = entry[referrer] + 1
-	} else {
-		entry[NO_REFERRER] = entry[NO_REFERRER] + 1
+	refs.Add(path, referrers.Normalize(referrer), time.Now())
+}
+
+// flushRefs writes the buffered counts to refStore.
+func flushRefs(ctx context.Context) {
+	if err := refs.Flush(ctx, refStore); err != nil {
+		glog.Errorf("Failed to write referrer counts: %s", err)
+	}
+}
+
+// StartFlusher periodically writes the buffered referrer and view counts to
+// refStore and viewStore, and prunes old hourly referrer counts. On SIGINT or
+// SIGTERM it writes them one last time and exits, so they survive restarts.
+func StartFlusher() {
+	ctx := context.Background()
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	flush := time.Tick(REF_FLUSH_INTERVAL)
+	prune := time.Tick(24 * time.Hour)
+	for {
+		select {
+		case <-flush:
+			flushRefs(ctx)
+			flushViews(ctx)
+		case <-prune:
+			before := time.Now().Add(-HOURLY_RETENTION)
+			if err := refStore.Prune(ctx, referrers.HOURLY, before); err != nil {
+				glog.Errorf("Failed to prune hourly referrer counts: %s", err)
+			}
+		case s := <-sig:
+			glog.Infof("Got %s, writing referrer and view counts before exiting.", s)
+			flushRefs(ctx)
+			flushViews(ctx)
+			glog.Flush()
+			os.Exit(0)
+		}
 	}
 }
 
 func init() {
-	refTemplate = template.Must(template.New("ref").Parse(refSource))
+	refTemplate = template.Must(template.New("ref").Funcs(template.FuncMap{
+		"sparkline": sparkline,
+		"signed": func(n int64) string {
+			return fmt.Sprintf("%+d", n)
+		},
+	}).Parse(refSource))
 	client = httputils.NewTimeoutClient()
-	go func() {
-		for _ = range time.Tick(time.Hour * 24) {
-			cache.Purge()
-		}
-	}()
 }
 
 type Claims struct {
@@ -122,64 +243,132 @@ func isAdmin(r *http.Request) bool {
 		return false
 	}
 	// Check if aud is correct.
-	if claims.Aud != CLIENT_ID {
+	if claims.Aud != getConfig().Admin.ClientID {
 		return false
 	}
 
-	return claims.Mail == "joe.gregorio@gmail.com"
+	return getConfig().IsAdmin(claims.Mail)
 }
 
-type refSummary struct {
-	Path      string
-	Total     int
-	Referrers map[string]int
+type refPageContext struct {
+	ClientID      string
+	IsAdmin       bool
+	From          string
+	To            string
+	Granularities []string
+	Ranges        []int
+	Report        *referrers.Report
 }
 
-type refPageContext struct {
-	IsAdmin bool
-	Summary []*refSummary
+// dayRange returns the days to show on /u/ref or /u/views, as [begin, end),
+// from either the from and to dates, inclusive, or the number of days up to
+// today.
+func dayRange(r *http.Request, now time.Time) (time.Time, time.Time, error) {
+	today := referrers.Start(referrers.DAILY, now)
+	from, to := today.AddDate(0, 0, 1-DEFAULT_REF_DAYS), today
+	if d := r.FormValue("days"); d != "" {
+		days, err := strconv.Atoi(d)
+		if err != nil || days < 1 {
+			return time.Time{}, time.Time{}, fmt.Errorf("Invalid number of days: %q", d)
+		}
+		from = today.AddDate(0, 0, 1-days)
+	} else {
+		var err error
+		if f := r.FormValue("from"); f != "" {
+			if from, err = time.Parse(REF_DATE_FORMAT, f); err != nil {
+				return time.Time{}, time.Time{}, fmt.Errorf("Invalid from date: %q", f)
+			}
+		}
+		if t := r.FormValue("to"); t != "" {
+			if to, err = time.Parse(REF_DATE_FORMAT, t); err != nil {
+				return time.Time{}, time.Time{}, fmt.Errorf("Invalid to date: %q", t)
+			}
+		}
+	}
+	end := to.AddDate(0, 0, 1)
+	if !from.Before(end) {
+		return time.Time{}, time.Time{}, fmt.Errorf("The from date must not be after the to date.")
+	}
+	if int(end.Sub(from)/(24*time.Hour)) > MAX_REF_DAYS {
+		return time.Time{}, time.Time{}, fmt.Errorf("At most %d days can be shown.", MAX_REF_DAYS)
+	}
+	return from, end, nil
 }
 
-type refSummarySlice []*refSummary
+// refRange returns the granularity and time range to show on /u/ref, see
+// dayRange, and the granularity, which defaults to hourly for up to two days
+// and daily otherwise.
+func refRange(r *http.Request, now time.Time) (string, time.Time, time.Time, error) {
+	from, end, err := dayRange(r, now)
+	if err != nil {
+		return "", time.Time{}, time.Time{}, err
+	}
+	days := int(end.Sub(from) / (24 * time.Hour))
+	granularity := r.FormValue("granularity")
+	switch granularity {
+	case "":
+		granularity = referrers.DAILY
+		if days <= 2 {
+			granularity = referrers.HOURLY
+		}
+	case referrers.DAILY:
+	case referrers.HOURLY:
+		if days > MAX_HOURLY_REF_DAYS {
+			return "", time.Time{}, time.Time{}, fmt.Errorf("At most %d days can be shown by the hour.", MAX_HOURLY_REF_DAYS)
+		}
+	default:
+		return "", time.Time{}, time.Time{}, fmt.Errorf("Unknown granularity: %q", granularity)
+	}
+	return granularity, from, end, nil
+}
 
-func (p refSummarySlice) Len() int           { return len(p) }
-func (p refSummarySlice) Less(i, j int) bool { return p[i].Total > p[j].Total }
-func (p refSummarySlice) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+// refCounts returns the counts, stored and buffered, of the given
+// granularity in [begin, end).
+func refCounts(ctx context.Context, granularity string, begin, end time.Time) ([]*referrers.Count, error) {
+	counts, err := refStore.Get(ctx, granularity, begin, end)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range refs.Counts() {
+		if c.Granularity == granularity && !c.Start.Before(begin) && c.Start.Before(end) {
+			counts = append(counts, c)
+		}
+	}
+	return counts, nil
+}
 
 func refHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "text/html")
-	ikeys := cache.Keys()
-	summary := []*refSummary{}
-	isAdmin := *local || isAdmin(r)
-	if isAdmin {
-		for _, ik := range ikeys {
-			key := ik.(string)
-			irefs, ok := cache.Get(ik)
-			if !ok {
-				glog.Error("No cache hit?: %v", irefs)
-				continue
-			}
-			refs, ok := irefs.(cacheEntry)
-			if !ok {
-				glog.Error("Wrong thing in cache?: %v", irefs)
-				continue
-			}
-			total := 0
-			for _, n := range refs {
-				total += n
-			}
-			summary = append(summary, &refSummary{
-				Path:      key,
-				Total:     total,
-				Referrers: refs,
-			})
+	granularity, begin, end, err := refRange(r, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	pageContext := refPageContext{
+		ClientID:      getConfig().Admin.ClientID,
+		IsAdmin:       *local || isAdmin(r),
+		From:          begin.Format(REF_DATE_FORMAT),
+		To:            end.AddDate(0, 0, -1).Format(REF_DATE_FORMAT),
+		Granularities: referrers.Granularities,
+		Ranges:        []int{1, 7, 30, 365},
+	}
+	if pageContext.IsAdmin {
+		counts, err := refCounts(r.Context(), granularity, begin, end)
+		if err != nil {
+			glog.Errorf("Failed to read referrer counts: %s", err)
+			http.Error(w, "Failed to read referrer counts.", http.StatusInternalServerError)
+			return
+		}
+		prevBegin, prevEnd := referrers.Previous(begin, end)
+		previous, err := refCounts(r.Context(), granularity, prevBegin, prevEnd)
+		if err != nil {
+			glog.Errorf("Failed to read referrer counts: %s", err)
+			http.Error(w, "Failed to read referrer counts.", http.StatusInternalServerError)
+			return
 		}
+		pageContext.Report = referrers.NewReport(counts, previous, granularity, begin, end)
 	}
-	sort.Sort(refSummarySlice(summary))
-	if err := refTemplate.Execute(w, refPageContext{
-		IsAdmin: isAdmin,
-		Summary: summary,
-	}); err != nil {
+	if err := refTemplate.Execute(w, pageContext); err != nil {
 		glog.Errorf("Failed to render ref template: %s", err)
 	}
 }
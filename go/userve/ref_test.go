@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jcgregorio/userve/go/config"
+	"github.com/jcgregorio/userve/go/referrers"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRefRange(t *testing.T) {
+	now := time.Date(2018, 3, 4, 15, 0, 0, 0, time.UTC)
+	today := time.Date(2018, 3, 4, 0, 0, 0, 0, time.UTC)
+	tomorrow := today.AddDate(0, 0, 1)
+	refRangeOf := func(query string) (string, time.Time, time.Time, error) {
+		return refRange(httptest.NewRequest("GET", "/u/ref?"+query, nil), now)
+	}
+
+	g, begin, end, err := refRangeOf("")
+	assert.NoError(t, err)
+	assert.Equal(t, referrers.DAILY, g)
+	assert.Equal(t, today.AddDate(0, 0, -6), begin)
+	assert.Equal(t, tomorrow, end)
+
+	g, begin, end, err = refRangeOf("days=1")
+	assert.NoError(t, err)
+	assert.Equal(t, referrers.HOURLY, g)
+	assert.Equal(t, today, begin)
+	assert.Equal(t, tomorrow, end)
+
+	g, begin, end, err = refRangeOf("from=2018-01-01&to=2018-01-30&granularity=hourly")
+	assert.NoError(t, err)
+	assert.Equal(t, referrers.HOURLY, g)
+	assert.Equal(t, time.Date(2018, 1, 1, 0, 0, 0, 0, time.UTC), begin)
+	assert.Equal(t, time.Date(2018, 1, 31, 0, 0, 0, 0, time.UTC), end)
+
+	g, begin, _, err = refRangeOf("from=2018-03-01")
+	assert.NoError(t, err)
+	assert.Equal(t, referrers.DAILY, g)
+	assert.Equal(t, time.Date(2018, 3, 1, 0, 0, 0, 0, time.UTC), begin)
+
+	for _, bad := range []string{
+		"days=0",
+		"days=x",
+		"from=March",
+		"to=2018-3-4",
+		"from=2018-03-05&to=2018-03-04",
+		"from=2018-01-01&granularity=hourly",
+		"granularity=weekly",
+		"days=100000",
+	} {
+		_, _, _, err := refRangeOf(bad)
+		assert.Error(t, err, bad)
+	}
+}
+
+func TestSparkline(t *testing.T) {
+	svg := string(sparkline([]int64{0, 5, 10}, 102, 12))
+	assert.True(t, strings.HasPrefix(svg, "<svg "))
+	assert.Contains(t, svg, `points="1.0,11.0 51.0,6.0 101.0,1.0"`)
+
+	// No hits is a flat line along the bottom.
+	assert.Contains(t, string(sparkline([]int64{0, 0}, 12, 12)), `points="1.0,11.0 11.0,11.0"`)
+	assert.Contains(t, string(sparkline([]int64{3}, 12, 12)), `points="1.0,1.0"`)
+}
+
+// TestRefConcurrent counts referrers from many requests at once while the
+// ref page is shown and counts are flushed, run with -race to find data
+// races.
+func TestRefConcurrent(t *testing.T) {
+	cfg, err := config.Parse([]byte("origins: [https://example.com]\nsource: /nonexistent\n"))
+	assert.NoError(t, err)
+	state.Store(newServerState(cfg))
+	refs, err = referrers.NewAggregator(REF_CACHE_SIZE)
+	assert.NoError(t, err)
+	refStore = referrers.NewMemoryStore()
+	oldLocal := *local
+	*local = true
+	defer func() {
+		*local = oldLocal
+	}()
+
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	logging := siteHandler(LoggingRequestResponse(ok))
+	ref := siteHandler(http.HandlerFunc(refHandler))
+
+	const goroutines, requests = 10, 200
+	var wg sync.WaitGroup
+	for i := 0; i < goroutines; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < requests; j++ {
+				r := httptest.NewRequest("GET", fmt.Sprintf("https://example.com/p%d", j%20), nil)
+				if i%2 == 0 {
+					r.Header.Set("Referer", fmt.Sprintf("https://r%d.example.org/", j%5))
+				}
+				logging(httptest.NewRecorder(), r)
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < requests/20; j++ {
+				w := httptest.NewRecorder()
+				ref(w, httptest.NewRequest("GET", "https://example.com/u/ref?days=1", nil))
+				assert.Equal(t, http.StatusOK, w.Code)
+				if j%2 == 0 {
+					flushRefs(context.Background())
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	flushRefs(context.Background())
+	now := time.Now()
+	counts, err := refStore.Get(context.Background(), referrers.DAILY, referrers.Start(referrers.DAILY, now).Add(-24*time.Hour), now.Add(24*time.Hour))
+	assert.NoError(t, err)
+	var total int64
+	for _, c := range counts {
+		total += c.Hits
+	}
+	assert.Equal(t, int64(goroutines*requests), total)
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/jcgregorio/userve/go/config"
+	"github.com/jcgregorio/userve/go/redirect"
+	"github.com/skia-dev/glog"
+	"rsc.io/letsencrypt"
+)
+
+var (
+	// reloadMutex serializes reloads.
+	reloadMutex sync.Mutex
+
+	// tlsManager is the letsencrypt Manager, if running with TLS, so the
+	// hosts can be updated on reload.
+	tlsManager *letsencrypt.Manager
+)
+
+func setTLSManager(m *letsencrypt.Manager) {
+	reloadMutex.Lock()
+	defer reloadMutex.Unlock()
+	tlsManager = m
+}
+
+// loadConfig loads the config file, or the default config if there isn't
+// one, and applies the flags that override it.
+func loadConfig() (*config.Config, error) {
+	var cfg *config.Config
+	if *configFile != "" {
+		var err error
+		cfg, err = config.Load(*configFile)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		cfg = config.Default()
+	}
+	if len(cfg.Sites) == 1 {
+		site := cfg.Sites[0]
+		if *sources != "" {
+			site.Source = *sources
+		}
+		if *redirectFile != "" {
+			site.RedirectFile = *redirectFile
+		}
+		if site.Source == "" {
+			wd, err := os.Getwd()
+			if err != nil {
+				return nil, fmt.Errorf("Can't find working directory: %s", err)
+			}
+			site.Source = wd
+		}
+	} else if *sources != "" || *redirectFile != "" {
+		return nil, fmt.Errorf("-source and -redirect_file can't be used with more than one site.")
+	}
+	if *storage != "" {
+		cfg.Storage.Type = *storage
+	}
+	if *storageFile != "" {
+		cfg.Storage.File = *storageFile
+	}
+	if cfg.Storage.Type == "" {
+		cfg.Storage.Type = config.DATASTORE_STORAGE
+		if *local {
+			cfg.Storage.Type = config.MEMORY_STORAGE
+		}
+	}
+	return cfg, nil
+}
+
+// reload loads the config, redirect, referrer blocklist, and GeoIP files
+// again and swaps them in, logging what changed. If the config can't be
+// loaded the current one is kept.
+func reload(reason string) {
+	reloadMutex.Lock()
+	defer reloadMutex.Unlock()
+	glog.Infof("Reloading: %s", reason)
+	cfg, err := loadConfig()
+	if err != nil {
+		glog.Errorf("Failed to reload config, keeping the current one: %s", err)
+		return
+	}
+	old := state.Load().(*serverState)
+	st := newServerState(cfg)
+	logChanges(old, st)
+	state.Store(st)
+	if tlsManager != nil {
+		tlsManager.SetHosts(cfg.TLS.Hosts)
+	}
+}
+
+// logChanges logs the differences between the old and current state.
+func logChanges(old, cur *serverState) {
+	oldSites := map[string]*site{}
+	for _, s := range old.sites {
+		oldSites[s.Name] = s
+	}
+	for _, cs := range cur.cfg.Sites {
+		s := cur.sites[cs]
+		o, ok := oldSites[s.Name]
+		if !ok {
+			glog.Infof("Site %s added.", s.Name)
+			continue
+		}
+		delete(oldSites, s.Name)
+		for _, change := range fieldChanges(*o.Site, *s.Site) {
+			glog.Infof("Site %s: %s", s.Name, change)
+		}
+		added, removed := redirect.Diff(o.redirects, s.redirects)
+		for _, r := range added {
+			glog.Infof("Site %s: Added redirect %s", s.Name, r)
+		}
+		for _, r := range removed {
+			glog.Infof("Site %s: Removed redirect %s", s.Name, r)
+		}
+	}
+	removed := []string{}
+	for name := range oldSites {
+		removed = append(removed, name)
+	}
+	sort.Strings(removed)
+	for _, name := range removed {
+		glog.Infof("Site %s removed.", name)
+	}
+	for _, change := range fieldChanges(old.cfg.Admin, cur.cfg.Admin) {
+		glog.Infof("Admin: %s", change)
+	}
+	for _, change := range fieldChanges(old.cfg.TLS, cur.cfg.TLS) {
+		glog.Infof("TLS: %s", change)
+	}
+	if old.cfg.TLS.CacheFile != cur.cfg.TLS.CacheFile {
+		glog.Warning("TLS cache_file changes only take effect after a restart.")
+	}
+	if old.cfg.ReferrerBlocklist != cur.cfg.ReferrerBlocklist || old.blocklist.Len != cur.blocklist.Len {
+		glog.Infof("Referrer blocklist %q has %d patterns.", cur.cfg.ReferrerBlocklist, cur.blocklist.Len)
+	}
+	if old.cfg.GeoIPDatabase != cur.cfg.GeoIPDatabase {
+		glog.Infof("GeoIP database changed from %q to %q.", old.cfg.GeoIPDatabase, cur.cfg.GeoIPDatabase)
+	}
+	for _, change := range fieldChanges(old.cfg.Storage, cur.cfg.Storage) {
+		glog.Warningf("Storage: %s, only takes effect after a restart.", change)
+	}
+}
+
+// fieldChanges describes the fields that differ between two structs of the
+// same type.
+func fieldChanges(a, b interface{}) []string {
+	ret := []string{}
+	va, vb := reflect.ValueOf(a), reflect.ValueOf(b)
+	for i := 0; i < va.NumField(); i++ {
+		if va.Type().Field(i).PkgPath != "" {
+			// Unexported fields are derived from exported ones.
+			continue
+		}
+		fa, fb := va.Field(i).Interface(), vb.Field(i).Interface()
+		if !reflect.DeepEqual(fa, fb) {
+			ret = append(ret, fmt.Sprintf("%s changed from %v to %v", va.Type().Field(i).Name, fa, fb))
+		}
+	}
+	return ret
+}
+
+// watchedFiles returns the modification times of the config, redirect,
+// referrer blocklist, and GeoIP files, with the zero time for files that
+// can't be read.
+func watchedFiles() map[string]time.Time {
+	files := []string{}
+	if *configFile != "" {
+		files = append(files, *configFile)
+	}
+	cfg := getConfig()
+	for _, s := range cfg.Sites {
+		if s.RedirectFile != "" {
+			files = append(files, s.RedirectFile)
+		}
+	}
+	if cfg.ReferrerBlocklist != "" {
+		files = append(files, cfg.ReferrerBlocklist)
+	}
+	if cfg.GeoIPDatabase != "" {
+		files = append(files, cfg.GeoIPDatabase)
+	}
+	ret := map[string]time.Time{}
+	for _, f := range files {
+		if st, err := os.Stat(f); err == nil {
+			ret[f] = st.ModTime()
+		} else {
+			ret[f] = time.Time{}
+		}
+	}
+	return ret
+}
+
+// StartReloader reloads the config, redirect, referrer blocklist, and GeoIP
+// files when any of them are modified, checking every -reload_interval, or
+// when sent SIGHUP.
+func StartReloader() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	watch(hup, time.Tick(*reloadInterval), nil)
+}
+
+// watch reloads on each signal from hup, and on each tick if any of the
+// watched files were modified since the last check, until done is closed.
+func watch(hup <-chan os.Signal, tick <-chan time.Time, done <-chan struct{}) {
+	modified := watchedFiles()
+	for {
+		select {
+		case <-done:
+			return
+		case <-hup:
+			reload("SIGHUP")
+		case <-tick:
+			changed := []string{}
+			for f, ts := range watchedFiles() {
+				if last, ok := modified[f]; ok && !last.Equal(ts) {
+					changed = append(changed, f)
+				}
+			}
+			if len(changed) == 0 {
+				continue
+			}
+			sort.Strings(changed)
+			reload(fmt.Sprintf("Modified %s", strings.Join(changed, ", ")))
+		}
+		modified = watchedFiles()
+	}
+}
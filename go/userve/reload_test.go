@@ -0,0 +1,160 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// reloadSite writes a config file for a site in a temporary directory, with
+// a redirect file, sets -config to it, and loads it, returning the
+// directory and a function to clean up.
+func reloadSite(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "userve")
+	assert.NoError(t, err)
+	root := filepath.Join(dir, "site")
+	assert.NoError(t, os.MkdirAll(root, 0755))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(root, "about.html"), []byte("about"), 0644))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "redirects"), []byte("/old /about 301\n"), 0644))
+	writeConfig(t, dir, "")
+	oldConfigFile := *configFile
+	*configFile = filepath.Join(dir, "config.yaml")
+	cfg, err := loadConfig()
+	assert.NoError(t, err)
+	state.Store(newServerState(cfg))
+	return dir, func() {
+		*configFile = oldConfigFile
+		_ = os.RemoveAll(dir)
+	}
+}
+
+// writeConfig writes the config for the site in dir, with extra appended.
+func writeConfig(t *testing.T, dir, extra string) {
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "config.yaml"), []byte(`
+origins: [https://example.com]
+source: `+filepath.Join(dir, "site")+`
+redirect_file: `+filepath.Join(dir, "redirects")+`
+`+extra), 0644))
+}
+
+// redirectOf returns the Location and status that the state st gives path.
+func redirectOf(st *serverState, path string) (string, int) {
+	s := st.sites[st.cfg.Sites[0]]
+	w := httptest.NewRecorder()
+	s.static(w, httptest.NewRequest("GET", "https://example.com"+path, nil))
+	return w.Header().Get("Location"), w.Code
+}
+
+func TestReload(t *testing.T) {
+	dir, cleanup := reloadSite(t)
+	defer cleanup()
+	old := state.Load().(*serverState)
+	location, status := redirectOf(old, "/old")
+	assert.Equal(t, http.StatusMovedPermanently, status)
+	assert.Equal(t, "/about", location)
+	assert.Equal(t, "public, max-age=300", old.cfg.Sites[0].CacheControl("/about.html"))
+
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "redirects"), []byte("/old /about 302\n/new /about 301\n"), 0644))
+	writeConfig(t, dir, "cache: [{pattern: '*.html', cache_control: no-cache}]\n")
+	reload("test")
+
+	cur := state.Load().(*serverState)
+	assert.NotEqual(t, old, cur)
+	_, status = redirectOf(cur, "/old")
+	assert.Equal(t, http.StatusFound, status)
+	location, status = redirectOf(cur, "/new")
+	assert.Equal(t, http.StatusMovedPermanently, status)
+	assert.Equal(t, "/about", location)
+	assert.Equal(t, "no-cache", getConfig().Sites[0].CacheControl("/about.html"))
+
+	// Requests still holding the old state keep working with it.
+	_, status = redirectOf(old, "/old")
+	assert.Equal(t, http.StatusMovedPermanently, status)
+	_, status = redirectOf(old, "/new")
+	assert.Equal(t, http.StatusNotFound, status)
+	assert.Equal(t, "public, max-age=300", old.cfg.Sites[0].CacheControl("/about.html"))
+
+	// A config that fails to load keeps the current state.
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "config.yaml"), []byte("origins: [example.com]\n"), 0644))
+	reload("test")
+	assert.Equal(t, cur, state.Load().(*serverState))
+}
+
+func TestWatchedFiles(t *testing.T) {
+	dir, cleanup := reloadSite(t)
+	defer cleanup()
+	files := watchedFiles()
+	assert.Len(t, files, 2)
+	redirects := filepath.Join(dir, "redirects")
+	assert.False(t, files[redirects].IsZero())
+	assert.False(t, files[filepath.Join(dir, "config.yaml")].IsZero())
+
+	later := time.Now().Add(time.Hour)
+	assert.NoError(t, os.Chtimes(redirects, later, later))
+	assert.True(t, watchedFiles()[redirects].After(files[redirects]))
+
+	// Files that can't be read are still watched, for when they come back.
+	assert.NoError(t, os.Remove(redirects))
+	assert.True(t, watchedFiles()[redirects].IsZero())
+}
+
+func TestFieldChanges(t *testing.T) {
+	type fields struct {
+		Name    string
+		Hosts   []string
+		private int
+	}
+	assert.Empty(t, fieldChanges(fields{Name: "a", Hosts: []string{"x"}}, fields{Name: "a", Hosts: []string{"x"}}))
+	assert.Equal(t, []string{
+		"Name changed from a to b",
+		"Hosts changed from [x] to [x y]",
+	}, fieldChanges(fields{Name: "a", Hosts: []string{"x"}, private: 1}, fields{Name: "b", Hosts: []string{"x", "y"}, private: 2}))
+}
+
+func TestWatch(t *testing.T) {
+	dir, cleanup := reloadSite(t)
+	defer cleanup()
+	hup := make(chan os.Signal)
+	tick := make(chan time.Time)
+	done := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		watch(hup, tick, done)
+		close(finished)
+	}()
+	defer func() {
+		close(done)
+		<-finished
+	}()
+
+	// A tick without changes doesn't reload.
+	old := state.Load().(*serverState)
+	tick <- time.Now()
+	tick <- time.Now()
+	assert.Equal(t, old, state.Load().(*serverState))
+
+	// SIGHUP reloads, even without changes.
+	hup <- syscall.SIGHUP
+	tick <- time.Now()
+	cur := state.Load().(*serverState)
+	assert.NotEqual(t, old, cur)
+
+	// A tick after a file changes reloads.
+	redirects := filepath.Join(dir, "redirects")
+	assert.NoError(t, ioutil.WriteFile(redirects, []byte("/old /about 302\n"), 0644))
+	later := time.Now().Add(time.Hour)
+	assert.NoError(t, os.Chtimes(redirects, later, later))
+	tick <- time.Now()
+	// The second tick is only received once the first was handled.
+	tick <- time.Now()
+	assert.NotEqual(t, cur, state.Load().(*serverState))
+	_, status := redirectOf(state.Load().(*serverState), "/old")
+	assert.Equal(t, http.StatusFound, status)
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/jcgregorio/userve/go/analytics"
+	"github.com/jcgregorio/userve/go/config"
+	"github.com/jcgregorio/userve/go/mention"
+	"github.com/jcgregorio/userve/go/redirect"
+	"github.com/jcgregorio/userve/go/referrers"
+	"github.com/skia-dev/glog"
+)
+
+// site is a configured Site along with the things loaded for it.
+type site struct {
+	*config.Site
+
+	// redirects are the loaded redirect rules, nil if there aren't any.
+	redirects *redirect.Rules
+
+	// static serves the static files of the Site.
+	static http.HandlerFunc
+}
+
+// serverState is everything loaded from the config, which is replaced as a
+// whole when the config or a redirect file changes, see reload.
+type serverState struct {
+	cfg   *config.Config
+	sites map[*config.Site]*site
+
+	// blocklist is the referrer spam that isn't counted.
+	blocklist *referrers.Blocklist
+
+	// geoIP finds the countries of visitors, nil if there's no database.
+	geoIP *analytics.GeoIP
+}
+
+// state holds the current *serverState.
+var state atomic.Value
+
+// getConfig returns the current config.
+func getConfig() *config.Config {
+	return state.Load().(*serverState).cfg
+}
+
+// newServerState loads the redirects of every Site in cfg and makes their
+// handlers, and loads the referrer blocklist and GeoIP database.
+func newServerState(cfg *config.Config) *serverState {
+	st := &serverState{
+		cfg:   cfg,
+		sites: map[*config.Site]*site{},
+	}
+	for _, cs := range cfg.Sites {
+		s := &site{Site: cs}
+		if cs.RedirectFile != "" {
+			var errs []error
+			s.redirects, errs = redirect.Load(cs.RedirectFile)
+			for _, err := range errs {
+				glog.Errorf("Failed to load redirect for %s: %s", cs.Name, err)
+			}
+		}
+		s.static = makeStaticHandler(s)
+		st.sites[cs] = s
+	}
+	var errs []error
+	st.blocklist, errs = referrers.LoadBlocklist(cfg.ReferrerBlocklist)
+	for _, err := range errs {
+		glog.Errorf("Failed to load referrer blocklist: %s", err)
+	}
+	var err error
+	if st.geoIP, err = analytics.LoadGeoIP(cfg.GeoIPDatabase); err != nil {
+		glog.Errorf("Failed to load GeoIP database, countries will be unknown: %s", err)
+	}
+	return st
+}
+
+// siteKey is the context key for the site a request is for.
+type siteKey struct{}
+
+// siteFromRequest returns the site the request is for, as found by
+// siteHandler.
+func siteFromRequest(r *http.Request) *site {
+	return r.Context().Value(siteKey{}).(*site)
+}
+
+// siteHandler finds the site for each request from its Host header and adds
+// it, along with the mention namespace of the site, to the request context.
+func siteHandler(h http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		st := state.Load().(*serverState)
+		cs := st.cfg.SiteFor(r.Host)
+		if cs == nil {
+			http.Error(w, "Unknown host", 404)
+			return
+		}
+		ctx := context.WithValue(r.Context(), siteKey{}, st.sites[cs])
+		ctx = mention.WithNamespace(ctx, cs.Namespace)
+		h.ServeHTTP(w, r.WithContext(ctx))
+	}
+}
+
+// staticHandler serves the static files of the site the request is for.
+func staticHandler(w http.ResponseWriter, r *http.Request) {
+	siteFromRequest(r).static(w, r)
+}
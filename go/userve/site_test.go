@@ -0,0 +1,92 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jcgregorio/userve/go/config"
+	"github.com/jcgregorio/userve/go/mention"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSiteHandler(t *testing.T) {
+	dir, err := ioutil.TempDir("", "userve")
+	assert.NoError(t, err)
+	defer func() {
+		_ = os.RemoveAll(dir)
+	}()
+	for _, name := range []string{"example", "blog"} {
+		assert.NoError(t, os.MkdirAll(filepath.Join(dir, name), 0755))
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, name, "index.html"), []byte(name), 0644))
+	}
+	cfg, err := config.Parse([]byte(`
+sites:
+  - name: example
+    origins: [https://example.com, https://www.example.com]
+    source: ` + filepath.Join(dir, "example") + `
+  - name: blog
+    origins: [https://blog.example.org]
+    source: ` + filepath.Join(dir, "blog") + `
+    namespace: blog
+`))
+	assert.NoError(t, err)
+	state.Store(newServerState(cfg))
+	mention.Init(mention.NewMemoryStore())
+
+	static := siteHandler(http.HandlerFunc(staticHandler))
+	for host, want := range map[string]string{
+		"example.com":          "example",
+		"www.example.com":      "example",
+		"EXAMPLE.com:8443":     "example",
+		"blog.example.org":     "blog",
+		"blog.example.org:443": "blog",
+	} {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Host = host
+		w := httptest.NewRecorder()
+		static(w, r)
+		assert.Equal(t, http.StatusOK, w.Code, host)
+		assert.Equal(t, want, w.Body.String(), host)
+	}
+	for _, host := range []string{"other.org", "example.com.evil.org", ""} {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Host = host
+		w := httptest.NewRecorder()
+		static(w, r)
+		assert.Equal(t, http.StatusNotFound, w.Code, host)
+	}
+
+	// Mentions are kept in the namespace of their site, and each site only
+	// takes mentions of its own pages.
+	webmention := siteHandler(http.HandlerFunc(webmentionHandler))
+	send := func(host, target string) int {
+		form := url.Values{
+			"source": {"https://other.org/reply"},
+			"target": {target},
+		}
+		r := httptest.NewRequest("POST", "/u/webmention", strings.NewReader(form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.Host = host
+		w := httptest.NewRecorder()
+		webmention(w, r)
+		return w.Code
+	}
+	assert.Equal(t, http.StatusCreated, send("example.com", "https://example.com/post"))
+	assert.Equal(t, http.StatusCreated, send("blog.example.org", "https://blog.example.org/post"))
+	assert.Equal(t, http.StatusBadRequest, send("blog.example.org", "https://example.com/other"))
+
+	ctx := context.Background()
+	mentions := mention.GetTriage(mention.WithNamespace(ctx, ""), 10, 0)
+	assert.Len(t, mentions, 1)
+	assert.Equal(t, "https://example.com/post", mentions[0].Target)
+	mentions = mention.GetTriage(mention.WithNamespace(ctx, "blog"), 10, 0)
+	assert.Len(t, mentions, 1)
+	assert.Equal(t, "https://blog.example.org/post", mentions[0].Target)
+}
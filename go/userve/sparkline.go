@@ -0,0 +1,34 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+)
+
+// sparkline returns an inline SVG line chart of values, width by height
+// pixels, drawn entirely on the server so the page needs no JavaScript.
+func sparkline(values []int64, width, height int) template.HTML {
+	var max int64
+	for _, v := range values {
+		if v > max {
+			max = v
+		}
+	}
+	var points bytes.Buffer
+	for i, v := range values {
+		x := 0.0
+		if len(values) > 1 {
+			x = float64(i) * float64(width-2) / float64(len(values)-1)
+		}
+		y := float64(height - 1)
+		if max > 0 {
+			y -= float64(v) * float64(height-2) / float64(max)
+		}
+		if i > 0 {
+			points.WriteString(" ")
+		}
+		fmt.Fprintf(&points, "%.1f,%.1f", x+1, y)
+	}
+	return template.HTML(fmt.Sprintf(`<svg class="sparkline" width="%d" height="%d" viewBox="0 0 %d %d" xmlns="http://www.w3.org/2000/svg"><polyline fill="none" stroke="currentColor" stroke-width="1.5" points="%s"/></svg>`, width, height, width, height, points.String()))
+}
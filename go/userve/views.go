@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"html/template"
+	"net"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/jcgregorio/userve/go/analytics"
+	"github.com/skia-dev/glog"
+)
+
+var (
+	// views buffers the view counts of recent requests until they are
+	// written to viewStore.
+	views = analytics.NewCounter()
+
+	// viewStore is where view counts are kept.
+	viewStore analytics.Store
+
+	// visitors tells visitors apart without cookies.
+	visitors = analytics.NewHasher()
+)
+
+var (
+	viewsTemplate *template.Template
+	viewsSource   = `<!DOCTYPE html>
+<html>
+<head>
+    <title></title>
+    <meta charset="utf-8" />
+    <meta http-equiv="X-UA-Compatible" content="IE=egde,chrome=1">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <meta name="google-signin-scope" content="profile email">
+    <meta name="google-signin-client_id" content="{{ .ClientID }}">
+    <script src="https://apis.google.com/js/platform.js" async defer></script>
+</head>
+<body>
+  <nav><a href="/u/ref">Referrers</a> | Views</nav>
+  <div class="g-signin2" data-onsuccess="onSignIn" data-theme="dark"></div>
+    <script>
+      function onSignIn(googleUser) {
+        document.cookie = "id_token=" + googleUser.getAuthResponse().id_token;
+        if (!{{.IsAdmin}}) {
+          window.location.reload();
+        }
+      };
+    </script>
+  <form method="get">
+    From <input type="date" name="from" value="{{.From}}">
+    to <input type="date" name="to" value="{{.To}}">
+    <input type="submit" value="Show">
+    or the last
+    {{range .Ranges}}
+      <a href="?days={{.}}">{{if eq . 1}}day{{else}}{{.}} days{{end}}</a>
+    {{end}}
+  </form>
+  {{with .Report}}
+  <h2>{{.All.TotalViews}} views by {{.All.TotalVisitors}} daily visitors</h2>
+  {{sparkline .All.Views 600 80}}
+  {{sparkline .All.Visitors 600 80}}
+
+  <h3>Paths</h3>
+  <table>
+    <tr><th></th><th>Views</th><th>Visitors</th><th>Path</th></tr>
+    {{range .Paths}}
+    <tr><td>{{sparkline .Views 120 20}}</td><td>{{.TotalViews}}</td><td>{{.TotalVisitors}}</td><td><a href="{{.Name}}">{{.Name}}</a></td></tr>
+    {{end}}
+  </table>
+
+  <h3>Countries</h3>
+  <table>
+    <tr><th></th><th>Views</th><th>Visitors</th><th>Country</th></tr>
+    {{range .Countries}}
+    <tr><td>{{sparkline .Views 120 20}}</td><td>{{.TotalViews}}</td><td>{{.TotalVisitors}}</td><td>{{.Name}}</td></tr>
+    {{end}}
+  </table>
+
+  <h3>Devices</h3>
+  <table>
+    <tr><th></th><th>Views</th><th>Visitors</th><th>Device</th></tr>
+    {{range .Devices}}
+    <tr><td>{{sparkline .Views 120 20}}</td><td>{{.TotalViews}}</td><td>{{.TotalVisitors}}</td><td>{{.Name}}</td></tr>
+    {{end}}
+  </table>
+  {{end}}
+</body>
+</html>
+`
+)
+
+func init() {
+	viewsTemplate = template.Must(template.New("views").Funcs(template.FuncMap{
+		"sparkline": sparkline,
+	}).Parse(viewsSource))
+}
+
+// viewWriter records the status of a response.
+type viewWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (v *viewWriter) WriteHeader(status int) {
+	if v.status == 0 {
+		v.status = status
+	}
+	v.ResponseWriter.WriteHeader(status)
+}
+
+func (v *viewWriter) Write(b []byte) (int, error) {
+	if v.status == 0 {
+		v.status = http.StatusOK
+	}
+	return v.ResponseWriter.Write(b)
+}
+
+// isPage returns true if the response to r is an HTML page that was served,
+// or one the browser already had, which is only known from the path since a
+// 304 has no Content-Type.
+func (v *viewWriter) isPage(r *http.Request) bool {
+	switch v.status {
+	case http.StatusOK:
+		return strings.HasPrefix(v.Header().Get("Content-Type"), "text/html")
+	case http.StatusNotModified:
+		ext := path.Ext(r.URL.Path)
+		return ext == "" || ext == ".html"
+	}
+	return false
+}
+
+// countViews counts the pages served by h as views.
+func countViews(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vw := &viewWriter{ResponseWriter: w}
+		h(vw, r)
+		if r.Method == "GET" && vw.isPage(r) {
+			incView(r, time.Now())
+		}
+	}
+}
+
+// incView counts a view of the page requested by r at time t.
+func incView(r *http.Request, t time.Time) {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	userAgent := r.UserAgent()
+	device := analytics.Device(userAgent)
+	country := analytics.UNKNOWN_COUNTRY
+	if device != analytics.BOT_DEVICE {
+		country = state.Load().(*serverState).geoIP.Country(ip)
+	}
+	views.Add(analytics.View{
+		Path:    sitePath(siteFromRequest(r).Site, r.URL.Path),
+		Visitor: visitors.Visitor(ip, userAgent, t),
+		Country: country,
+		Device:  device,
+		Time:    t,
+	})
+}
+
+// flushViews writes the buffered counts to viewStore.
+func flushViews(ctx context.Context) {
+	if err := views.Flush(ctx, viewStore); err != nil {
+		glog.Errorf("Failed to write view counts: %s", err)
+	}
+}
+
+// viewCounts returns the counts, stored and buffered, in [begin, end).
+func viewCounts(ctx context.Context, begin, end time.Time) ([]*analytics.Count, error) {
+	counts, err := viewStore.Get(ctx, begin, end)
+	if err != nil {
+		return nil, err
+	}
+	for _, c := range views.Counts() {
+		if !c.Day.Before(begin) && c.Day.Before(end) {
+			counts = append(counts, c)
+		}
+	}
+	return counts, nil
+}
+
+type viewsPageContext struct {
+	ClientID string
+	IsAdmin  bool
+	From     string
+	To       string
+	Ranges   []int
+	Report   *analytics.Report
+}
+
+func viewsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html")
+	begin, end, err := dayRange(r, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	pageContext := viewsPageContext{
+		ClientID: getConfig().Admin.ClientID,
+		IsAdmin:  *local || isAdmin(r),
+		From:     begin.Format(REF_DATE_FORMAT),
+		To:       end.AddDate(0, 0, -1).Format(REF_DATE_FORMAT),
+		Ranges:   []int{1, 7, 30, 365},
+	}
+	if pageContext.IsAdmin {
+		counts, err := viewCounts(r.Context(), begin, end)
+		if err != nil {
+			glog.Errorf("Failed to read view counts: %s", err)
+			http.Error(w, "Failed to read view counts.", http.StatusInternalServerError)
+			return
+		}
+		pageContext.Report = analytics.NewReport(counts, begin, end)
+	}
+	if err := viewsTemplate.Execute(w, pageContext); err != nil {
+		glog.Errorf("Failed to render views template: %s", err)
+	}
+}
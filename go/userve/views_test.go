@@ -0,0 +1,70 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/jcgregorio/userve/go/analytics"
+	"github.com/jcgregorio/userve/go/config"
+	"github.com/stretchr/testify/assert"
+)
+
+const testBrowser = "Mozilla/5.0 (X11; Linux x86_64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/66.0.3359.139 Safari/537.36"
+
+func TestCountViews(t *testing.T) {
+	_, root, cleanup := testSite(t)
+	defer cleanup()
+	cfg, err := config.Parse([]byte("origins: [https://example.com]\nsource: " + root + "\n"))
+	assert.NoError(t, err)
+	state.Store(newServerState(cfg))
+	views = analytics.NewCounter()
+	viewStore = analytics.NewMemoryStore()
+	oldLocal := *local
+	*local = true
+	defer func() {
+		*local = oldLocal
+	}()
+
+	h := siteHandler(countViews(staticHandler))
+	request := func(path, remoteAddr, userAgent string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "https://example.com"+path, nil)
+		r.RemoteAddr = remoteAddr
+		r.Header.Set("User-Agent", userAgent)
+		w := httptest.NewRecorder()
+		h(w, r)
+		return w
+	}
+	assert.Equal(t, http.StatusOK, request("/", "192.0.2.1:1234", testBrowser).Code)
+	assert.Equal(t, http.StatusOK, request("/about.html", "192.0.2.1:5678", testBrowser).Code)
+	assert.Equal(t, http.StatusOK, request("/about.html", "192.0.2.2:1234", testBrowser).Code)
+	assert.Equal(t, http.StatusOK, request("/about.html", "192.0.2.3:1234", "Googlebot/2.1").Code)
+	// Only pages are counted.
+	assert.Equal(t, http.StatusOK, request("/css/main.css", "192.0.2.1:1234", testBrowser).Code)
+	assert.Equal(t, http.StatusOK, request("/feed/index.atom", "192.0.2.1:1234", testBrowser).Code)
+	assert.Equal(t, http.StatusNotFound, request("/missing.html", "192.0.2.1:1234", testBrowser).Code)
+
+	flushViews(context.Background())
+	day := analytics.Day(time.Now())
+	counts, err := viewStore.Get(context.Background(), day, day.AddDate(0, 0, 1))
+	assert.NoError(t, err)
+	r := analytics.NewReport(counts, day, day.AddDate(0, 0, 1))
+	assert.Equal(t, int64(3), r.All.TotalViews)
+	assert.Equal(t, int64(2), r.All.TotalVisitors)
+	assert.Len(t, r.Paths, 2)
+	assert.Equal(t, "/about.html", r.Paths[0].Name)
+	assert.Equal(t, int64(2), r.Paths[0].TotalVisitors)
+	assert.Len(t, r.Countries, 1)
+	assert.Equal(t, analytics.UNKNOWN_COUNTRY, r.Countries[0].Name)
+	assert.Len(t, r.Devices, 2)
+	assert.Equal(t, analytics.DESKTOP_DEVICE, r.Devices[0].Name)
+	assert.Equal(t, analytics.BOT_DEVICE, r.Devices[1].Name)
+
+	w := httptest.NewRecorder()
+	siteHandler(http.HandlerFunc(viewsHandler))(w, httptest.NewRequest("GET", "https://example.com/u/views?days=7", nil))
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "3 views by 2 daily visitors")
+	assert.Contains(t, w.Body.String(), "/about.html")
+}